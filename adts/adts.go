@@ -0,0 +1,229 @@
+// Package adts parses and writes ADTS (Audio Data Transport Stream) frames
+// as produced by an encoder configured with TtMp4Adts.
+package adts
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Size of the fixed and variable header.
+	HeaderSize = 7
+	// Number of samples per channel in one raw data block.
+	SamplesPerRawDataBlock = 1024
+	// Buffer fullness value signaling a variable bitrate stream.
+	BufferFullnessVBR = 0x7FF
+	// Maximum value of the 13 bit frame_length field.
+	MaxFrameLength = 0x1FFF
+)
+
+var (
+	ErrShortHeader   = errors.New("adts: header is truncated")
+	ErrNoSync        = errors.New("adts: syncword not found")
+	ErrInvalidHeader = errors.New("adts: invalid header")
+	ErrCRCMismatch   = errors.New("adts: CRC mismatch")
+	// The CRC of a frame with a single raw data block also covers parts of the
+	// channel elements, which can only be located by decoding the AAC syntax.
+	ErrCRCUnverifiable = errors.New("adts: CRC covers channel element syntax and cannot be verified")
+)
+
+var sampleRates = [...]int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// SampleRate returns the sampling frequency for a sampling_frequency_index,
+// or 0 if the index is reserved.
+func SampleRate(index uint8) int {
+	if int(index) < len(sampleRates) {
+		return sampleRates[index]
+	}
+	return 0
+}
+
+// SampleRateIndex returns the sampling_frequency_index for a sampling frequency.
+func SampleRateIndex(rate int) (uint8, bool) {
+	for i, r := range sampleRates {
+		if r == rate {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// Header is an ADTS frame header, including the header error check fields.
+type Header struct {
+	// MPEG identifier, 0 for MPEG-4, 1 for MPEG-2.
+	ID uint8
+	// Layer, always 0.
+	Layer uint8
+	// No CRC is present when set.
+	ProtectionAbsent bool
+	// Profile, the MPEG-4 audio object type minus 1 (0: Main, 1: LC, 2: SSR, 3: LTP).
+	Profile uint8
+	// Sampling frequency index.
+	SamplingFrequencyIndex uint8
+	// Private bit, free for the application.
+	PrivateBit bool
+	// Channel configuration, 0 means defined by a PCE in the raw data block.
+	ChannelConfiguration uint8
+	// Original/copy flag.
+	OriginalCopy bool
+	// Home flag.
+	Home bool
+	// Copyright identification bit.
+	CopyrightIDBit bool
+	// Copyright identification start.
+	CopyrightIDStart bool
+	// Length of the frame in bytes, including the header.
+	FrameLength int
+	// Buffer fullness, BufferFullnessVBR for variable bitrate.
+	BufferFullness int
+	// number_of_raw_data_blocks_in_frame, one less than the number of raw data blocks.
+	NumRawDataBlocks int
+	// Byte offsets of raw data blocks 1..NumRawDataBlocks relative to the first
+	// raw data block. Only present when protected with more than one block.
+	RawDataBlockPositions []int
+	// The crc_check of the adts_error_check or adts_header_error_check.
+	CRC uint16
+}
+
+// Size returns the number of bytes of the header including the error check fields.
+func (h *Header) Size() int {
+	n := HeaderSize
+	if !h.ProtectionAbsent {
+		if h.NumRawDataBlocks > 0 {
+			n += 2 * h.NumRawDataBlocks
+		}
+		n += 2
+	}
+	return n
+}
+
+// SampleRate returns the sampling frequency in Hz.
+func (h *Header) SampleRate() int {
+	return SampleRate(h.SamplingFrequencyIndex)
+}
+
+// AudioObjectType returns the MPEG-4 audio object type of the profile.
+func (h *Header) AudioObjectType() int {
+	return int(h.Profile) + 1
+}
+
+// Samples returns the number of samples per channel carried by the frame.
+func (h *Header) Samples() int {
+	return (h.NumRawDataBlocks + 1) * SamplesPerRawDataBlock
+}
+
+// Parse parses the header at the start of b.
+func Parse(b []byte) (*Header, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShortHeader
+	}
+	if b[0] != 0xFF || b[1]&0xF0 != 0xF0 {
+		return nil, ErrNoSync
+	}
+
+	h := &Header{
+		ID:                     (b[1] >> 3) & 0x01,
+		Layer:                  (b[1] >> 1) & 0x03,
+		ProtectionAbsent:       b[1]&0x01 != 0,
+		Profile:                b[2] >> 6,
+		SamplingFrequencyIndex: (b[2] >> 2) & 0x0F,
+		PrivateBit:             (b[2]>>1)&0x01 != 0,
+		ChannelConfiguration:   (b[2]&0x01)<<2 | b[3]>>6,
+		OriginalCopy:           (b[3]>>5)&0x01 != 0,
+		Home:                   (b[3]>>4)&0x01 != 0,
+		CopyrightIDBit:         (b[3]>>3)&0x01 != 0,
+		CopyrightIDStart:       (b[3]>>2)&0x01 != 0,
+		FrameLength:            int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5,
+		BufferFullness:         int(b[5]&0x1F)<<6 | int(b[6])>>2,
+		NumRawDataBlocks:       int(b[6] & 0x03),
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+
+	if !h.ProtectionAbsent {
+		if len(b) < h.Size() {
+			return nil, ErrShortHeader
+		}
+		p := b[HeaderSize:]
+		if h.NumRawDataBlocks > 0 {
+			h.RawDataBlockPositions = make([]int, h.NumRawDataBlocks)
+			for i := range h.RawDataBlockPositions {
+				h.RawDataBlockPositions[i] = int(p[0])<<8 | int(p[1])
+				p = p[2:]
+			}
+		}
+		h.CRC = uint16(p[0])<<8 | uint16(p[1])
+	}
+	return h, nil
+}
+
+func (h *Header) validate() error {
+	if h.ID > 1 || h.Layer != 0 || h.Profile > 3 || h.ChannelConfiguration > 7 ||
+		h.BufferFullness < 0 || h.BufferFullness > BufferFullnessVBR ||
+		h.NumRawDataBlocks < 0 || h.NumRawDataBlocks > 3 {
+		return ErrInvalidHeader
+	}
+	if SampleRate(h.SamplingFrequencyIndex) == 0 {
+		return fmt.Errorf("%w: reserved sampling frequency index %d", ErrInvalidHeader, h.SamplingFrequencyIndex)
+	}
+	if h.FrameLength < h.Size() || h.FrameLength > MaxFrameLength {
+		return fmt.Errorf("%w: frame length %d", ErrInvalidHeader, h.FrameLength)
+	}
+	if !h.ProtectionAbsent && h.NumRawDataBlocks > 0 && len(h.RawDataBlockPositions) != 0 &&
+		len(h.RawDataBlockPositions) != h.NumRawDataBlocks {
+		return fmt.Errorf("%w: %d raw data block positions for %d blocks", ErrInvalidHeader,
+			len(h.RawDataBlockPositions), h.NumRawDataBlocks+1)
+	}
+	return nil
+}
+
+// Marshal returns the binary header including the error check fields.
+// For protected frames with several raw data blocks the header CRC is computed,
+// otherwise the CRC field is written as is.
+func (h *Header) Marshal() ([]byte, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, h.Size())
+	b[0] = 0xFF
+	b[1] = 0xF0 | h.ID<<3 | h.Layer<<1 | bit(h.ProtectionAbsent)
+	b[2] = h.Profile<<6 | h.SamplingFrequencyIndex<<2 | bit(h.PrivateBit)<<1 | h.ChannelConfiguration>>2
+	b[3] = h.ChannelConfiguration<<6 | bit(h.OriginalCopy)<<5 | bit(h.Home)<<4 |
+		bit(h.CopyrightIDBit)<<3 | bit(h.CopyrightIDStart)<<2 | uint8(h.FrameLength>>11)
+	b[4] = uint8(h.FrameLength >> 3)
+	b[5] = uint8(h.FrameLength<<5) | uint8(h.BufferFullness>>6)
+	b[6] = uint8(h.BufferFullness<<2) | uint8(h.NumRawDataBlocks)
+
+	if !h.ProtectionAbsent {
+		p := b[HeaderSize:]
+		crc := h.CRC
+		if h.NumRawDataBlocks > 0 {
+			for i := 0; i < h.NumRawDataBlocks; i++ {
+				pos := 0
+				if i < len(h.RawDataBlockPositions) {
+					pos = h.RawDataBlockPositions[i]
+				}
+				p[0] = uint8(pos >> 8)
+				p[1] = uint8(pos)
+				p = p[2:]
+			}
+			crc = CRC16(b[:len(b)-2])
+		}
+		p[0] = uint8(crc >> 8)
+		p[1] = uint8(crc)
+	}
+	return b, nil
+}
+
+func bit(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package adts
+
+import (
+	"bytes"
+	"testing"
+)
+
+var header0 = []byte{0xff, 0xf1, 0x50, 0x80, 0x0e, 0x60, 0xfc}
+
+func TestHeader(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		h, err := Parse(header0)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if h.ID != 0 || h.Layer != 0 || !h.ProtectionAbsent {
+			t.Errorf("unexpected id/layer/protection: %+v", h)
+		}
+		if h.AudioObjectType() != 2 {
+			t.Errorf("expected AOT 2, got %d", h.AudioObjectType())
+		}
+		if h.SampleRate() != 44100 {
+			t.Errorf("expected sample rate 44100, got %d", h.SampleRate())
+		}
+		if h.ChannelConfiguration != 2 {
+			t.Errorf("expected channel configuration 2, got %d", h.ChannelConfiguration)
+		}
+		if h.FrameLength != 115 {
+			t.Errorf("expected frame length 115, got %d", h.FrameLength)
+		}
+		if h.BufferFullness != 63 {
+			t.Errorf("expected buffer fullness 63, got %d", h.BufferFullness)
+		}
+		if h.NumRawDataBlocks != 0 || h.Samples() != 1024 {
+			t.Errorf("expected a single raw data block, got %d", h.NumRawDataBlocks)
+		}
+	})
+
+	t.Run("Marshal round trip", func(t *testing.T) {
+		h, err := Parse(header0)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		b, err := h.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !bytes.Equal(b, header0) {
+			t.Errorf("expected %x, got %x", header0, b)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := Parse(header0[:5]); err != ErrShortHeader {
+			t.Errorf("expected ErrShortHeader, got %v", err)
+		}
+		if _, err := Parse([]byte{0xff, 0xe1, 0x50, 0x80, 0x0e, 0x60, 0xfc}); err != ErrNoSync {
+			t.Errorf("expected ErrNoSync, got %v", err)
+		}
+		// Reserved sampling frequency index 15.
+		if _, err := Parse([]byte{0xff, 0xf1, 0x7c, 0x80, 0x0e, 0x60, 0xfc}); err == nil {
+			t.Error("expected error for reserved sampling frequency index")
+		}
+		// Frame length shorter than the header.
+		if _, err := Parse([]byte{0xff, 0xf1, 0x50, 0x80, 0x00, 0x20, 0xfc}); err == nil {
+			t.Error("expected error for short frame length")
+		}
+	})
+
+	t.Run("CRC16", func(t *testing.T) {
+		if crc := CRC16([]byte("123456789")); crc != 0xAEE7 {
+			t.Errorf("expected 0xAEE7, got 0x%04X", crc)
+		}
+	})
+}
+
+func TestFrame(t *testing.T) {
+	t.Run("Single raw data block", func(t *testing.T) {
+		raw := []byte{0x21, 0x17, 0x55, 0x45}
+		f, err := NewFrame(Header{Profile: 1, SamplingFrequencyIndex: 3, ChannelConfiguration: 2,
+			ProtectionAbsent: true, BufferFullness: BufferFullnessVBR}, raw)
+		if err != nil {
+			t.Fatalf("NewFrame failed: %v", err)
+		}
+		if f.Header.FrameLength != HeaderSize+len(raw) || len(f.Data) != f.Header.FrameLength {
+			t.Errorf("unexpected frame length %d", f.Header.FrameLength)
+		}
+		blocks, err := f.RawDataBlocks()
+		if err != nil || len(blocks) != 1 || !bytes.Equal(blocks[0], raw) {
+			t.Errorf("unexpected raw data blocks %x, %v", blocks, err)
+		}
+		if err = f.VerifyHeaderCRC(); err != nil {
+			t.Errorf("expected nil for unprotected frame, got %v", err)
+		}
+
+		f, err = NewFrame(Header{Profile: 1, SamplingFrequencyIndex: 3, ChannelConfiguration: 2,
+			BufferFullness: BufferFullnessVBR}, raw)
+		if err != nil {
+			t.Fatalf("NewFrame failed: %v", err)
+		}
+		if err = f.VerifyHeaderCRC(); err != ErrCRCUnverifiable {
+			t.Errorf("expected ErrCRCUnverifiable for a protected frame, got %v", err)
+		}
+	})
+
+	t.Run("Protected raw data blocks", func(t *testing.T) {
+		raws := [][]byte{{0x01, 0x02, 0x03}, {0x04, 0x05}, {0x06}}
+		var payload []byte
+		var positions []int
+		for i, raw := range raws {
+			if i > 0 {
+				positions = append(positions, len(payload))
+			}
+			payload = append(payload, raw...)
+			payload = append(payload, 0x00, 0x00) // raw data block CRC
+		}
+
+		h := &Header{Profile: 1, SamplingFrequencyIndex: 4, ChannelConfiguration: 1,
+			BufferFullness: BufferFullnessVBR, NumRawDataBlocks: len(raws) - 1,
+			RawDataBlockPositions: positions}
+		h.FrameLength = h.Size() + len(payload)
+		hdr, err := h.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		data := append(hdr, payload...)
+
+		parsed, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if parsed.Samples() != 3072 {
+			t.Errorf("expected 3072 samples, got %d", parsed.Samples())
+		}
+		f := &Frame{Header: parsed, Data: data}
+		if err = f.VerifyHeaderCRC(); err != nil {
+			t.Errorf("VerifyHeaderCRC failed: %v", err)
+		}
+		blocks, err := f.RawDataBlocks()
+		if err != nil {
+			t.Fatalf("RawDataBlocks failed: %v", err)
+		}
+		for i := range raws {
+			if !bytes.Equal(blocks[i], raws[i]) {
+				t.Errorf("block %d: expected %x, got %x", i, raws[i], blocks[i])
+			}
+		}
+
+		for _, c := range []struct {
+			name string
+			pos  int
+			mask byte
+		}{
+			{"original_copy", 3, 0x20},
+			{"buffer fullness", 5, 0x01},
+			{"raw data block position", HeaderSize + 1, 0x01},
+		} {
+			corrupted := append([]byte(nil), data...)
+			corrupted[c.pos] ^= c.mask
+			parsed, err = Parse(corrupted)
+			if err != nil {
+				t.Fatalf("%s: Parse failed: %v", c.name, err)
+			}
+			f = &Frame{Header: parsed, Data: corrupted}
+			if err = f.VerifyHeaderCRC(); err != ErrCRCMismatch {
+				t.Errorf("%s: expected ErrCRCMismatch, got %v", c.name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package adts
+
+import "errors"
+
+// Frame is a complete ADTS frame.
+type Frame struct {
+	Header *Header
+	// The complete frame, including the header.
+	Data []byte
+}
+
+// NewFrame builds a frame carrying a single raw data block. FrameLength and
+// NumRawDataBlocks of h are set from raw.
+func NewFrame(h Header, raw []byte) (*Frame, error) {
+	h.NumRawDataBlocks = 0
+	h.RawDataBlockPositions = nil
+	h.FrameLength = h.Size() + len(raw)
+
+	hdr, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{
+		Header: &h,
+		Data:   append(hdr, raw...),
+	}, nil
+}
+
+// Payload returns the frame data following the header and its error check fields.
+func (f *Frame) Payload() []byte {
+	return f.Data[f.Header.Size():]
+}
+
+// RawDataBlocks splits the payload into its raw data blocks, without the
+// adts_raw_data_block_error_check of protected frames. The block boundaries of
+// unprotected frames with several raw data blocks are not signaled.
+func (f *Frame) RawDataBlocks() ([][]byte, error) {
+	h := f.Header
+	payload := f.Payload()
+	if h.NumRawDataBlocks == 0 {
+		return [][]byte{payload}, nil
+	}
+	if h.ProtectionAbsent {
+		return nil, errors.New("adts: raw data block positions are not signaled without protection")
+	}
+
+	blocks := make([][]byte, 0, h.NumRawDataBlocks+1)
+	start := 0
+	for i := 0; i <= h.NumRawDataBlocks; i++ {
+		end := len(payload)
+		if i < h.NumRawDataBlocks {
+			end = h.RawDataBlockPositions[i]
+		}
+		// Each block is followed by its 16 bit CRC.
+		if end-2 < start || end > len(payload) {
+			return nil, errors.New("adts: invalid raw data block position")
+		}
+		blocks = append(blocks, payload[start:end-2])
+		start = end
+	}
+	return blocks, nil
+}
+
+// VerifyHeaderCRC checks the adts_header_error_check of a protected frame with
+// several raw data blocks, which covers the header and the raw data block
+// positions. It returns nil for unprotected frames and ErrCRCUnverifiable for
+// protected frames with a single raw data block.
+//
+// The adts_error_check of single block frames and the
+// adts_raw_data_block_error_check of each block also cover the first bits of
+// every channel element, which can only be located by decoding the AAC
+// syntax. They are not checked here; the decoder reports them as CRC errors.
+func (f *Frame) VerifyHeaderCRC() error {
+	h := f.Header
+	if h.ProtectionAbsent {
+		return nil
+	}
+	if h.NumRawDataBlocks == 0 {
+		return ErrCRCUnverifiable
+	}
+	if CRC16(f.Data[:h.Size()-2]) != h.CRC {
+		return ErrCRCMismatch
+	}
+	return nil
+}
+
+// CRC16 computes the CRC used by the ADTS error check, polynomial 0x8005
+// with an initial value of 0xFFFF.
+func CRC16(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,66 @@
+package adts
+
+import (
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac/internal/scan"
+)
+
+// Scanner reads complete ADTS frames from an io.Reader. Data that does not
+// belong to a valid frame is skipped and the scanner resynchronizes on the
+// next header. While resynchronizing, a header is only accepted when it is
+// followed by another syncword or the end of the stream.
+type Scanner struct {
+	s     *scan.Scanner
+	frame *Frame
+}
+
+// NewScanner returns a Scanner reading from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{s: scan.New(r, isSync)}
+}
+
+// Scan advances to the next frame, which is then available through Frame.
+// It returns false at the end of the stream or on a read error.
+func (s *Scanner) Scan() bool {
+	s.frame = nil
+	for s.s.Sync(HeaderSize) {
+		// The header with the error check fields of three positions has 15 bytes.
+		s.s.Fill(HeaderSize + 8)
+		h, err := Parse(s.s.Bytes())
+		if err != nil {
+			s.s.Resync()
+			continue
+		}
+		if !s.s.Accept(h.FrameLength) {
+			continue
+		}
+
+		s.frame = &Frame{
+			Header: h,
+			Data:   append([]byte(nil), s.s.Bytes()[:h.FrameLength]...),
+		}
+		s.s.Consume(h.FrameLength)
+		return true
+	}
+	return false
+}
+
+// Frame returns the frame found by the last call to Scan.
+func (s *Scanner) Frame() *Frame {
+	return s.frame
+}
+
+// Err returns the first read error other than io.EOF.
+func (s *Scanner) Err() error {
+	return s.s.Err()
+}
+
+// Skipped returns the number of bytes discarded while searching for frames.
+func (s *Scanner) Skipped() int64 {
+	return s.s.Skipped()
+}
+
+func isSync(b []byte) bool {
+	return b[0] == 0xFF && b[1]&0xF6 == 0xF0
+}
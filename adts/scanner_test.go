@@ -0,0 +1,52 @@
+package adts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	hdr := Header{Profile: 1, SamplingFrequencyIndex: 4, ChannelConfiguration: 2,
+		ProtectionAbsent: true, BufferFullness: BufferFullnessVBR}
+
+	var frames [][]byte
+	for i := 0; i < 5; i++ {
+		raw := bytes.Repeat([]byte{byte(i + 1)}, 100+i*50)
+		f, err := NewFrame(hdr, raw)
+		if err != nil {
+			t.Fatalf("NewFrame failed: %v", err)
+		}
+		frames = append(frames, f.Data)
+	}
+
+	garbage := []byte{0x00, 0xff, 0xf1, 0x12, 0xff}
+	var stream []byte
+	stream = append(stream, garbage...)
+	stream = append(stream, frames[0]...)
+	stream = append(stream, frames[1]...)
+	stream = append(stream, garbage...)
+	stream = append(stream, frames[2]...)
+	stream = append(stream, frames[3]...)
+	stream = append(stream, frames[4]...)
+	stream = append(stream, frames[0][:20]...) // truncated frame
+
+	s := NewScanner(bytes.NewReader(stream))
+	var got [][]byte
+	for s.Scan() {
+		got = append(got, s.Frame().Data)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("expected %d frames, got %d", len(frames), len(got))
+	}
+	for i := range frames {
+		if !bytes.Equal(got[i], frames[i]) {
+			t.Errorf("frame %d differs", i)
+		}
+	}
+	if expected := int64(2*len(garbage) + 20); s.Skipped() != expected {
+		t.Errorf("expected %d skipped bytes, got %d", expected, s.Skipped())
+	}
+}
@@ -0,0 +1,839 @@
+// Package asc parses and builds the MPEG-4 AudioSpecificConfig, the decoder
+// configuration returned by the encoder in EncInfo.ConfBuf and accepted by
+// Decoder.ConfigRaw.
+package asc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/internal/bits"
+)
+
+// Audio object types handled by this package. fdkaac.AudioObjectType takes the
+// values of these types from here.
+const (
+	AotAacMain   = 1
+	AotAacLc     = 2
+	AotAacSsr    = 3
+	AotAacLtp    = 4
+	AotSbr       = 5
+	AotAacScal   = 6
+	AotTwinVq    = 7
+	AotErAacLc   = 17
+	AotErAacLtp  = 19
+	AotErAacScal = 20
+	AotErTwinVq  = 21
+	AotErBsac    = 22
+	AotErAacLd   = 23
+	AotPs        = 29
+	AotEscape    = 31
+	AotErAacEld  = 39
+)
+
+// Signaling of SBR and PS. The values match fdkaac.SignalingMode.
+type Signaling int
+
+const (
+	// No SBR/PS signaling in the config, the decoder detects it in the bitstream.
+	SignalingImplicit Signaling = iota
+	// Core AOT first, followed by a sync extension with the SBR/PS flags.
+	SignalingExplicitCompatible
+	// AotSbr or AotPs first, followed by the core AOT.
+	SignalingExplicitHierarchical
+)
+
+const (
+	syncExtensionSbr = 0x2B7
+	syncExtensionPs  = 0x548
+	explicitRate     = 0x0F
+)
+
+var (
+	ErrShort       = errors.New("asc: config is truncated")
+	ErrInvalid     = errors.New("asc: invalid config")
+	ErrUnsupported = errors.New("asc: unsupported config")
+)
+
+// AudioSpecificConfig is the MPEG-4 AudioSpecificConfig.
+type AudioSpecificConfig struct {
+	// Audio object type of the core coder.
+	ObjectType int
+	// Sampling frequency of the core coder in Hz. Rates without a sampling
+	// frequency index are written explicitly.
+	SamplingFrequency int
+	// Channel configuration, 0 means defined by ProgramConfig.
+	ChannelConfiguration int
+
+	// How SBR and PS are signaled.
+	Signaling Signaling
+	// SBR is present, set for explicit signaling only.
+	SbrPresent bool
+	// PS is present, set for explicit signaling only.
+	PsPresent bool
+	// Output sampling frequency of SBR.
+	ExtensionSamplingFrequency int
+	// Extension channel configuration for ER BSAC.
+	ExtensionChannelConfiguration int
+
+	// Core frame length is 960 (480 for LD/ELD) instead of 1024 (512).
+	FrameLengthFlag bool
+	// The core depends on a core coder, with CoreCoderDelay samples delay.
+	DependsOnCoreCoder bool
+	CoreCoderDelay     int
+	// extensionFlag of the GASpecificConfig, always set for ER object types.
+	ExtensionFlag bool
+	// Layer number of scalable object types.
+	LayerNr int
+	// Number of sub frames and layer length of ER BSAC.
+	NumOfSubFrame int
+	LayerLength   int
+	// Error resilience flags of ER object types.
+	SectionDataResilience     bool
+	ScalefactorDataResilience bool
+	SpectralDataResilience    bool
+	// extensionFlag3, reserved for version 3.
+	ExtensionFlag3 bool
+	// Program config element, present when ChannelConfiguration is 0.
+	ProgramConfig *ProgramConfigElement
+	// ELD specific config, present for AotErAacEld.
+	ELD *ELDSpecificConfig
+
+	// Error protection config of ER object types, only 0 and 1 are supported.
+	EpConfig int
+}
+
+// ChannelElement is a front, side or back element of a program config element.
+type ChannelElement struct {
+	// Channel pair element instead of a single channel element.
+	IsCPE     bool
+	TagSelect int
+}
+
+// CouplingElement is a coupling channel element of a program config element.
+type CouplingElement struct {
+	// Independently switched coupling channel.
+	IsIndSw   bool
+	TagSelect int
+}
+
+// ProgramConfigElement describes the channel elements of a stream.
+type ProgramConfigElement struct {
+	ElementInstanceTag int
+	// Profile, the audio object type minus 1.
+	ObjectType             int
+	SamplingFrequencyIndex int
+	FrontElements          []ChannelElement
+	SideElements           []ChannelElement
+	BackElements           []ChannelElement
+	LfeElements            []int
+	AssocDataElements      []int
+	CcElements             []CouplingElement
+	MonoMixdownPresent     bool
+	MonoMixdownElement     int
+	StereoMixdownPresent   bool
+	StereoMixdownElement   int
+	MatrixMixdownPresent   bool
+	MatrixMixdownIdx       int
+	PseudoSurround         bool
+	// Comment field, may carry the height extension.
+	Comment []byte
+}
+
+// Channels returns the number of channels described by the element.
+func (p *ProgramConfigElement) Channels() int {
+	n := len(p.LfeElements)
+	for _, els := range [][]ChannelElement{p.FrontElements, p.SideElements, p.BackElements} {
+		for _, el := range els {
+			n++
+			if el.IsCPE {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// ELDSpecificConfig holds the fields of the ELDSpecificConfig that are not
+// shared with the GASpecificConfig.
+type ELDSpecificConfig struct {
+	// Low delay SBR is present.
+	LdSbrPresent bool
+	// SBR runs at twice the core sampling rate.
+	LdSbrSamplingRate bool
+	// SBR payload is protected by a CRC.
+	LdSbrCrc bool
+	// One SBR header per channel element.
+	SbrHeaders []SbrHeader
+	// Extensions other than ELDEXT_TERM, e.g. LD MPEG Surround.
+	Extensions []ELDExtension
+}
+
+// SbrHeader is the sbr_header of a low delay SBR config.
+type SbrHeader struct {
+	AmpRes        bool
+	StartFreq     int
+	StopFreq      int
+	XoverBand     int
+	HeaderExtra1  bool
+	FreqScale     int
+	AlterScale    bool
+	NoiseBands    int
+	HeaderExtra2  bool
+	LimiterBands  int
+	LimiterGains  int
+	InterpolFreq  bool
+	SmoothingMode bool
+}
+
+// ELDExtension is an extension element of the ELDSpecificConfig.
+type ELDExtension struct {
+	Type int
+	Data []byte
+}
+
+// SampleRate returns the output sampling frequency in Hz.
+func (c *AudioSpecificConfig) SampleRate() int {
+	if c.SbrPresent && c.ExtensionSamplingFrequency > 0 {
+		return c.ExtensionSamplingFrequency
+	}
+	if c.ELD != nil && c.ELD.LdSbrPresent && c.ELD.LdSbrSamplingRate {
+		return 2 * c.SamplingFrequency
+	}
+	return c.SamplingFrequency
+}
+
+// FrameLength returns the number of output samples per channel of an access unit.
+func (c *AudioSpecificConfig) FrameLength() int {
+	n := 1024
+	if c.ObjectType == AotErAacLd || c.ObjectType == AotErAacEld {
+		n = 512
+	}
+	if c.FrameLengthFlag {
+		n = n * 15 / 16
+	}
+	if c.SampleRate() != c.SamplingFrequency {
+		n *= c.SampleRate() / c.SamplingFrequency
+	}
+	return n
+}
+
+// Channels returns the number of output channels, or 0 if unknown.
+func (c *AudioSpecificConfig) Channels() int {
+	if c.ChannelConfiguration == 0 {
+		if c.ProgramConfig != nil {
+			return c.ProgramConfig.Channels()
+		}
+		return 0
+	}
+	if c.ChannelConfiguration == 1 && c.PsPresent {
+		return 2
+	}
+	return ChannelConfigurationChannels(c.ChannelConfiguration)
+}
+
+// ChannelConfigurationChannels returns the number of channels of a channel
+// configuration, or 0 if the configuration is reserved.
+func ChannelConfigurationChannels(chConfig int) int {
+	switch {
+	case chConfig >= 1 && chConfig <= 6:
+		return chConfig
+	case chConfig == 7 || chConfig == 12 || chConfig == 14:
+		return 8
+	case chConfig == 11:
+		return 7
+	case chConfig == 13:
+		return 24
+	}
+	return 0
+}
+
+// Parse parses an AudioSpecificConfig, e.g. EncInfo.ConfBuf.
+func Parse(b []byte) (*AudioSpecificConfig, error) {
+	c, _, err := ParseBits(b, 0, len(b)*8)
+	return c, err
+}
+
+// ParseBits parses an AudioSpecificConfig starting at bit offset of b, with
+// length bits available. If length is negative the length is unknown, as in
+// LATM with AudioMuxVersion 0, and trailing sync extensions are not parsed.
+// Returns the number of bits consumed.
+func ParseBits(b []byte, offset, length int) (*AudioSpecificConfig, int, error) {
+	r := bits.NewReader(b)
+	r.Skip(offset)
+	if r.Err() != nil {
+		return nil, 0, ErrShort
+	}
+
+	c, err := parse(r, length)
+	if err == nil {
+		err = r.Err()
+	}
+	if errors.Is(err, bits.ErrShort) {
+		err = ErrShort
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if length >= 0 && r.Pos()-offset > length {
+		return nil, 0, ErrShort
+	}
+	return c, r.Pos() - offset, nil
+}
+
+func parse(r *bits.Reader, length int) (*AudioSpecificConfig, error) {
+	start := r.Pos()
+	c := &AudioSpecificConfig{}
+
+	c.ObjectType = readObjectType(r)
+	c.SamplingFrequency = readSamplingFrequency(r)
+	c.ChannelConfiguration = r.ReadInt(4)
+
+	if c.ObjectType == AotSbr || c.ObjectType == AotPs {
+		c.Signaling = SignalingExplicitHierarchical
+		c.SbrPresent = true
+		c.PsPresent = c.ObjectType == AotPs
+		c.ExtensionSamplingFrequency = readSamplingFrequency(r)
+		c.ObjectType = readObjectType(r)
+		if c.ObjectType == AotErBsac {
+			c.ExtensionChannelConfiguration = r.ReadInt(4)
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if c.SamplingFrequency == 0 || (c.SbrPresent && c.ExtensionSamplingFrequency == 0) {
+		return nil, fmt.Errorf("%w: reserved sampling frequency index", ErrInvalid)
+	}
+
+	switch {
+	case isGA(c.ObjectType):
+		if err := c.parseGA(r, start); err != nil {
+			return nil, err
+		}
+	case c.ObjectType == AotErAacEld:
+		if err := c.parseELD(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: audio object type %d", ErrUnsupported, c.ObjectType)
+	}
+
+	if isER(c.ObjectType) {
+		c.EpConfig = r.ReadInt(2)
+		if c.EpConfig > 1 {
+			return nil, fmt.Errorf("%w: epConfig %d", ErrUnsupported, c.EpConfig)
+		}
+	}
+
+	if c.Signaling != SignalingExplicitHierarchical && length >= 0 &&
+		length-(r.Pos()-start) >= 16 {
+		c.parseSyncExtension(r, start, length)
+	}
+	return c, r.Err()
+}
+
+func (c *AudioSpecificConfig) parseGA(r *bits.Reader, start int) error {
+	c.FrameLengthFlag = r.ReadBool()
+	c.DependsOnCoreCoder = r.ReadBool()
+	if c.DependsOnCoreCoder {
+		c.CoreCoderDelay = r.ReadInt(14)
+	}
+	c.ExtensionFlag = r.ReadBool()
+	if c.ChannelConfiguration == 0 {
+		pce, err := parsePCE(r, start)
+		if err != nil {
+			return err
+		}
+		c.ProgramConfig = pce
+	}
+	if c.ObjectType == AotAacScal || c.ObjectType == AotErAacScal {
+		c.LayerNr = r.ReadInt(3)
+	}
+	if c.ExtensionFlag {
+		if c.ObjectType == AotErBsac {
+			c.NumOfSubFrame = r.ReadInt(5)
+			c.LayerLength = r.ReadInt(11)
+		}
+		if hasResilienceFlags(c.ObjectType) {
+			c.SectionDataResilience = r.ReadBool()
+			c.ScalefactorDataResilience = r.ReadBool()
+			c.SpectralDataResilience = r.ReadBool()
+		}
+		c.ExtensionFlag3 = r.ReadBool()
+	}
+	return r.Err()
+}
+
+func (c *AudioSpecificConfig) parseELD(r *bits.Reader) error {
+	eld := &ELDSpecificConfig{}
+	c.ELD = eld
+	c.ExtensionFlag = true
+	c.FrameLengthFlag = r.ReadBool()
+	c.SectionDataResilience = r.ReadBool()
+	c.ScalefactorDataResilience = r.ReadBool()
+	c.SpectralDataResilience = r.ReadBool()
+
+	eld.LdSbrPresent = r.ReadBool()
+	if eld.LdSbrPresent {
+		eld.LdSbrSamplingRate = r.ReadBool()
+		eld.LdSbrCrc = r.ReadBool()
+		eld.SbrHeaders = make([]SbrHeader, numSbrHeaders(c.ChannelConfiguration))
+		for i := range eld.SbrHeaders {
+			eld.SbrHeaders[i].parse(r)
+		}
+	}
+
+	for r.Err() == nil {
+		extType := r.ReadInt(4)
+		if extType == 0 {
+			break
+		}
+		n := r.ReadInt(4)
+		if n == 15 {
+			add := r.ReadInt(8)
+			n += add
+			if add == 255 {
+				n += r.ReadInt(16)
+			}
+		}
+		if r.Err() != nil || r.Left() < n*8 {
+			return ErrShort
+		}
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(r.Read(8))
+		}
+		eld.Extensions = append(eld.Extensions, ELDExtension{Type: extType, Data: data})
+	}
+	return r.Err()
+}
+
+func (c *AudioSpecificConfig) parseSyncExtension(r *bits.Reader, start, length int) {
+	// Peek at the sync word and extension type, so that trailing bits which
+	// are not a sync extension are not counted as part of the config.
+	v := r.Peek(16)
+	if int(v>>5) != syncExtensionSbr || (int(v&0x1F) != AotSbr && int(v&0x1F) != AotErBsac) {
+		return
+	}
+	r.Skip(11)
+	extType := readObjectType(r)
+	c.Signaling = SignalingExplicitCompatible
+	c.SbrPresent = r.ReadBool()
+	if c.SbrPresent {
+		c.ExtensionSamplingFrequency = readSamplingFrequency(r)
+	}
+	if extType == AotErBsac {
+		c.ExtensionChannelConfiguration = r.ReadInt(4)
+		return
+	}
+	if c.SbrPresent && length-(r.Pos()-start) >= 12 {
+		if r.Peek(11) == syncExtensionPs {
+			r.Skip(11)
+			c.PsPresent = r.ReadBool()
+		}
+	}
+}
+
+func (h *SbrHeader) parse(r *bits.Reader) {
+	h.AmpRes = r.ReadBool()
+	h.StartFreq = r.ReadInt(4)
+	h.StopFreq = r.ReadInt(4)
+	h.XoverBand = r.ReadInt(3)
+	r.Skip(2)
+	h.HeaderExtra1 = r.ReadBool()
+	h.HeaderExtra2 = r.ReadBool()
+	if h.HeaderExtra1 {
+		h.FreqScale = r.ReadInt(2)
+		h.AlterScale = r.ReadBool()
+		h.NoiseBands = r.ReadInt(2)
+	}
+	if h.HeaderExtra2 {
+		h.LimiterBands = r.ReadInt(2)
+		h.LimiterGains = r.ReadInt(2)
+		h.InterpolFreq = r.ReadBool()
+		h.SmoothingMode = r.ReadBool()
+	}
+}
+
+func parsePCE(r *bits.Reader, start int) (*ProgramConfigElement, error) {
+	p := &ProgramConfigElement{}
+	p.ElementInstanceTag = r.ReadInt(4)
+	p.ObjectType = r.ReadInt(2)
+	p.SamplingFrequencyIndex = r.ReadInt(4)
+	p.FrontElements = make([]ChannelElement, r.ReadInt(4))
+	p.SideElements = make([]ChannelElement, r.ReadInt(4))
+	p.BackElements = make([]ChannelElement, r.ReadInt(4))
+	p.LfeElements = make([]int, r.ReadInt(2))
+	p.AssocDataElements = make([]int, r.ReadInt(3))
+	p.CcElements = make([]CouplingElement, r.ReadInt(4))
+
+	p.MonoMixdownPresent = r.ReadBool()
+	if p.MonoMixdownPresent {
+		p.MonoMixdownElement = r.ReadInt(4)
+	}
+	p.StereoMixdownPresent = r.ReadBool()
+	if p.StereoMixdownPresent {
+		p.StereoMixdownElement = r.ReadInt(4)
+	}
+	p.MatrixMixdownPresent = r.ReadBool()
+	if p.MatrixMixdownPresent {
+		p.MatrixMixdownIdx = r.ReadInt(2)
+		p.PseudoSurround = r.ReadBool()
+	}
+
+	for _, els := range [][]ChannelElement{p.FrontElements, p.SideElements, p.BackElements} {
+		for i := range els {
+			els[i].IsCPE = r.ReadBool()
+			els[i].TagSelect = r.ReadInt(4)
+		}
+	}
+	for i := range p.LfeElements {
+		p.LfeElements[i] = r.ReadInt(4)
+	}
+	for i := range p.AssocDataElements {
+		p.AssocDataElements[i] = r.ReadInt(4)
+	}
+	for i := range p.CcElements {
+		p.CcElements[i].IsIndSw = r.ReadBool()
+		p.CcElements[i].TagSelect = r.ReadInt(4)
+	}
+
+	// byte_alignment() is relative to the start of the AudioSpecificConfig.
+	if n := (r.Pos() - start) & 7; n != 0 {
+		r.Skip(8 - n)
+	}
+	p.Comment = make([]byte, r.ReadInt(8))
+	if r.Err() != nil || r.Left() < len(p.Comment)*8 {
+		return nil, ErrShort
+	}
+	for i := range p.Comment {
+		p.Comment[i] = byte(r.Read(8))
+	}
+	return p, nil
+}
+
+// Marshal returns the binary AudioSpecificConfig, padded to a whole byte.
+func (c *AudioSpecificConfig) Marshal() ([]byte, error) {
+	b, _, err := c.MarshalBits()
+	return b, err
+}
+
+// MarshalBits returns the binary AudioSpecificConfig and its length in bits,
+// for embedding into bit oriented syntax such as the LATM StreamMuxConfig.
+func (c *AudioSpecificConfig) MarshalBits() ([]byte, int, error) {
+	if err := c.validate(); err != nil {
+		return nil, 0, err
+	}
+
+	w := &bits.Writer{}
+	if c.Signaling == SignalingExplicitHierarchical && c.SbrPresent {
+		if c.PsPresent {
+			writeObjectType(w, AotPs)
+		} else {
+			writeObjectType(w, AotSbr)
+		}
+		writeSamplingFrequency(w, c.SamplingFrequency)
+		w.WriteInt(c.ChannelConfiguration, 4)
+		writeSamplingFrequency(w, c.ExtensionSamplingFrequency)
+		writeObjectType(w, c.ObjectType)
+		if c.ObjectType == AotErBsac {
+			w.WriteInt(c.ExtensionChannelConfiguration, 4)
+		}
+	} else {
+		writeObjectType(w, c.ObjectType)
+		writeSamplingFrequency(w, c.SamplingFrequency)
+		w.WriteInt(c.ChannelConfiguration, 4)
+	}
+
+	if c.ObjectType == AotErAacEld {
+		c.marshalELD(w)
+	} else {
+		c.marshalGA(w)
+	}
+	if isER(c.ObjectType) {
+		w.WriteInt(c.EpConfig, 2)
+	}
+
+	if c.Signaling == SignalingExplicitCompatible {
+		w.WriteInt(syncExtensionSbr, 11)
+		if c.ObjectType == AotErBsac {
+			writeObjectType(w, AotErBsac)
+		} else {
+			writeObjectType(w, AotSbr)
+		}
+		w.WriteBool(c.SbrPresent)
+		if c.SbrPresent {
+			writeSamplingFrequency(w, c.ExtensionSamplingFrequency)
+		}
+		if c.ObjectType == AotErBsac {
+			w.WriteInt(c.ExtensionChannelConfiguration, 4)
+		} else if c.SbrPresent && c.PsPresent {
+			w.WriteInt(syncExtensionPs, 11)
+			w.WriteBool(true)
+		}
+	}
+
+	n := w.Len()
+	w.ByteAlign()
+	return w.Bytes(), n, nil
+}
+
+func (c *AudioSpecificConfig) marshalGA(w *bits.Writer) {
+	w.WriteBool(c.FrameLengthFlag)
+	w.WriteBool(c.DependsOnCoreCoder)
+	if c.DependsOnCoreCoder {
+		w.WriteInt(c.CoreCoderDelay, 14)
+	}
+	extensionFlag := c.ExtensionFlag || isER(c.ObjectType)
+	w.WriteBool(extensionFlag)
+	if c.ChannelConfiguration == 0 {
+		c.ProgramConfig.marshal(w)
+	}
+	if c.ObjectType == AotAacScal || c.ObjectType == AotErAacScal {
+		w.WriteInt(c.LayerNr, 3)
+	}
+	if extensionFlag {
+		if c.ObjectType == AotErBsac {
+			w.WriteInt(c.NumOfSubFrame, 5)
+			w.WriteInt(c.LayerLength, 11)
+		}
+		if hasResilienceFlags(c.ObjectType) {
+			w.WriteBool(c.SectionDataResilience)
+			w.WriteBool(c.ScalefactorDataResilience)
+			w.WriteBool(c.SpectralDataResilience)
+		}
+		w.WriteBool(c.ExtensionFlag3)
+	}
+}
+
+func (c *AudioSpecificConfig) marshalELD(w *bits.Writer) {
+	eld := c.ELD
+	w.WriteBool(c.FrameLengthFlag)
+	w.WriteBool(c.SectionDataResilience)
+	w.WriteBool(c.ScalefactorDataResilience)
+	w.WriteBool(c.SpectralDataResilience)
+
+	w.WriteBool(eld.LdSbrPresent)
+	if eld.LdSbrPresent {
+		w.WriteBool(eld.LdSbrSamplingRate)
+		w.WriteBool(eld.LdSbrCrc)
+		for i := range eld.SbrHeaders {
+			eld.SbrHeaders[i].marshal(w)
+		}
+	}
+
+	for _, ext := range eld.Extensions {
+		w.WriteInt(ext.Type, 4)
+		n := len(ext.Data)
+		switch {
+		case n < 15:
+			w.WriteInt(n, 4)
+		case n < 15+255:
+			w.WriteInt(15, 4)
+			w.WriteInt(n-15, 8)
+		default:
+			w.WriteInt(15, 4)
+			w.WriteInt(255, 8)
+			w.WriteInt(n-15-255, 16)
+		}
+		for _, v := range ext.Data {
+			w.WriteInt(int(v), 8)
+		}
+	}
+	w.WriteInt(0, 4)
+}
+
+func (h *SbrHeader) marshal(w *bits.Writer) {
+	w.WriteBool(h.AmpRes)
+	w.WriteInt(h.StartFreq, 4)
+	w.WriteInt(h.StopFreq, 4)
+	w.WriteInt(h.XoverBand, 3)
+	w.WriteInt(0, 2)
+	w.WriteBool(h.HeaderExtra1)
+	w.WriteBool(h.HeaderExtra2)
+	if h.HeaderExtra1 {
+		w.WriteInt(h.FreqScale, 2)
+		w.WriteBool(h.AlterScale)
+		w.WriteInt(h.NoiseBands, 2)
+	}
+	if h.HeaderExtra2 {
+		w.WriteInt(h.LimiterBands, 2)
+		w.WriteInt(h.LimiterGains, 2)
+		w.WriteBool(h.InterpolFreq)
+		w.WriteBool(h.SmoothingMode)
+	}
+}
+
+func (p *ProgramConfigElement) marshal(w *bits.Writer) {
+	w.WriteInt(p.ElementInstanceTag, 4)
+	w.WriteInt(p.ObjectType, 2)
+	w.WriteInt(p.SamplingFrequencyIndex, 4)
+	w.WriteInt(len(p.FrontElements), 4)
+	w.WriteInt(len(p.SideElements), 4)
+	w.WriteInt(len(p.BackElements), 4)
+	w.WriteInt(len(p.LfeElements), 2)
+	w.WriteInt(len(p.AssocDataElements), 3)
+	w.WriteInt(len(p.CcElements), 4)
+
+	w.WriteBool(p.MonoMixdownPresent)
+	if p.MonoMixdownPresent {
+		w.WriteInt(p.MonoMixdownElement, 4)
+	}
+	w.WriteBool(p.StereoMixdownPresent)
+	if p.StereoMixdownPresent {
+		w.WriteInt(p.StereoMixdownElement, 4)
+	}
+	w.WriteBool(p.MatrixMixdownPresent)
+	if p.MatrixMixdownPresent {
+		w.WriteInt(p.MatrixMixdownIdx, 2)
+		w.WriteBool(p.PseudoSurround)
+	}
+
+	for _, els := range [][]ChannelElement{p.FrontElements, p.SideElements, p.BackElements} {
+		for _, el := range els {
+			w.WriteBool(el.IsCPE)
+			w.WriteInt(el.TagSelect, 4)
+		}
+	}
+	for _, tag := range p.LfeElements {
+		w.WriteInt(tag, 4)
+	}
+	for _, tag := range p.AssocDataElements {
+		w.WriteInt(tag, 4)
+	}
+	for _, el := range p.CcElements {
+		w.WriteBool(el.IsIndSw)
+		w.WriteInt(el.TagSelect, 4)
+	}
+
+	w.ByteAlign()
+	w.WriteInt(len(p.Comment), 8)
+	for _, v := range p.Comment {
+		w.WriteInt(int(v), 8)
+	}
+}
+
+func (c *AudioSpecificConfig) validate() error {
+	if !isGA(c.ObjectType) && c.ObjectType != AotErAacEld {
+		return fmt.Errorf("%w: audio object type %d", ErrUnsupported, c.ObjectType)
+	}
+	if c.SamplingFrequency <= 0 || c.SamplingFrequency >= 1<<24 {
+		return fmt.Errorf("%w: sampling frequency %d", ErrInvalid, c.SamplingFrequency)
+	}
+	if c.ChannelConfiguration < 0 || c.ChannelConfiguration > 15 {
+		return fmt.Errorf("%w: channel configuration %d", ErrInvalid, c.ChannelConfiguration)
+	}
+	if c.Signaling < SignalingImplicit || c.Signaling > SignalingExplicitHierarchical {
+		return fmt.Errorf("%w: signaling %d", ErrInvalid, c.Signaling)
+	}
+	if c.Signaling == SignalingImplicit && (c.SbrPresent || c.PsPresent) {
+		return fmt.Errorf("%w: SBR/PS present with implicit signaling", ErrInvalid)
+	}
+	if c.PsPresent && !c.SbrPresent {
+		return fmt.Errorf("%w: PS present without SBR", ErrInvalid)
+	}
+	if c.SbrPresent && (c.ExtensionSamplingFrequency <= 0 || c.ExtensionSamplingFrequency >= 1<<24) {
+		return fmt.Errorf("%w: extension sampling frequency %d", ErrInvalid, c.ExtensionSamplingFrequency)
+	}
+	if c.ObjectType == AotErAacEld {
+		if c.ELD == nil {
+			return fmt.Errorf("%w: missing ELD specific config", ErrInvalid)
+		}
+		if c.Signaling != SignalingImplicit {
+			return fmt.Errorf("%w: ELD signals SBR in the ELD specific config", ErrInvalid)
+		}
+		if c.ELD.LdSbrPresent && len(c.ELD.SbrHeaders) != numSbrHeaders(c.ChannelConfiguration) {
+			return fmt.Errorf("%w: %d SBR headers for channel configuration %d", ErrInvalid,
+				len(c.ELD.SbrHeaders), c.ChannelConfiguration)
+		}
+		for _, ext := range c.ELD.Extensions {
+			if ext.Type <= 0 || ext.Type > 15 || len(ext.Data) >= 15+255+1<<16 {
+				return fmt.Errorf("%w: ELD extension type %d", ErrInvalid, ext.Type)
+			}
+		}
+	} else if c.ChannelConfiguration == 0 {
+		p := c.ProgramConfig
+		if p == nil {
+			return fmt.Errorf("%w: channel configuration 0 without program config", ErrInvalid)
+		}
+		if len(p.FrontElements) > 15 || len(p.SideElements) > 15 || len(p.BackElements) > 15 ||
+			len(p.LfeElements) > 3 || len(p.AssocDataElements) > 7 || len(p.CcElements) > 15 ||
+			len(p.Comment) > 255 {
+			return fmt.Errorf("%w: program config element too large", ErrInvalid)
+		}
+	}
+	if c.EpConfig < 0 || c.EpConfig > 1 {
+		return fmt.Errorf("%w: epConfig %d", ErrUnsupported, c.EpConfig)
+	}
+	return nil
+}
+
+func readObjectType(r *bits.Reader) int {
+	aot := r.ReadInt(5)
+	if aot == AotEscape {
+		aot = 32 + r.ReadInt(6)
+	}
+	return aot
+}
+
+func writeObjectType(w *bits.Writer, aot int) {
+	if aot >= AotEscape {
+		w.WriteInt(AotEscape, 5)
+		w.WriteInt(aot-32, 6)
+	} else {
+		w.WriteInt(aot, 5)
+	}
+}
+
+// readSamplingFrequency returns 0 for a reserved index.
+func readSamplingFrequency(r *bits.Reader) int {
+	index := r.ReadInt(4)
+	if index == explicitRate {
+		return r.ReadInt(24)
+	}
+	return adts.SampleRate(uint8(index))
+}
+
+func writeSamplingFrequency(w *bits.Writer, rate int) {
+	if index, ok := adts.SampleRateIndex(rate); ok {
+		w.WriteInt(int(index), 4)
+	} else {
+		w.WriteInt(explicitRate, 4)
+		w.WriteInt(rate, 24)
+	}
+}
+
+func numSbrHeaders(chConfig int) int {
+	switch chConfig {
+	case 1, 2:
+		return 1
+	case 3:
+		return 2
+	case 4, 5, 6:
+		return 3
+	case 7, 11, 12, 14:
+		return 4
+	}
+	return 0
+}
+
+// isGA reports whether the object type uses the GASpecificConfig.
+func isGA(aot int) bool {
+	switch aot {
+	case AotAacMain, AotAacLc, AotAacSsr, AotAacLtp, AotAacScal, AotTwinVq,
+		AotErAacLc, AotErAacLtp, AotErAacScal, AotErTwinVq, AotErBsac, AotErAacLd:
+		return true
+	}
+	return false
+}
+
+// isER reports whether the object type is error resilient and carries an epConfig.
+func isER(aot int) bool {
+	return (aot >= AotErAacLc && aot <= 27) || aot == AotErAacEld
+}
+
+func hasResilienceFlags(aot int) bool {
+	return aot == AotErAacLc || aot == AotErAacLtp || aot == AotErAacScal || aot == AotErAacLd
+}
@@ -0,0 +1,204 @@
+package asc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAudioSpecificConfig(t *testing.T) {
+	t.Run("Parse round trip", func(t *testing.T) {
+		cases := []struct {
+			name       string
+			data       []byte
+			aot        int
+			signaling  Signaling
+			sbr, ps    bool
+			sampleRate int
+			channels   int
+			frameLen   int
+		}{
+			{"LC", []byte{0x12, 0x10}, AotAacLc, SignalingImplicit, false, false, 44100, 2, 1024},
+			{"LC 960", []byte{0x11, 0x8C}, AotAacLc, SignalingImplicit, false, false, 48000, 1, 960},
+			{"HE implicit", []byte{0x13, 0x90}, AotAacLc, SignalingImplicit, false, false, 22050, 2, 1024},
+			{"HE hierarchical", []byte{0x2B, 0x92, 0x08, 0x00}, AotAacLc, SignalingExplicitHierarchical, true, false, 44100, 2, 2048},
+			{"HE compatible", []byte{0x13, 0x90, 0x56, 0xE5, 0xA0}, AotAacLc, SignalingExplicitCompatible, true, false, 44100, 2, 2048},
+			{"HEv2 hierarchical", []byte{0xEB, 0x8A, 0x08, 0x00}, AotAacLc, SignalingExplicitHierarchical, true, true, 44100, 2, 2048},
+			{"HEv2 compatible", []byte{0x13, 0x88, 0x56, 0xE5, 0xA5, 0x48, 0x80}, AotAacLc, SignalingExplicitCompatible, true, true, 44100, 2, 2048},
+		}
+		for _, c := range cases {
+			config, err := Parse(c.data)
+			if err != nil {
+				t.Errorf("%s: Parse failed: %v", c.name, err)
+				continue
+			}
+			if config.ObjectType != c.aot || config.Signaling != c.signaling ||
+				config.SbrPresent != c.sbr || config.PsPresent != c.ps {
+				t.Errorf("%s: unexpected config %+v", c.name, config)
+			}
+			if config.SampleRate() != c.sampleRate {
+				t.Errorf("%s: expected sample rate %d, got %d", c.name, c.sampleRate, config.SampleRate())
+			}
+			if config.Channels() != c.channels {
+				t.Errorf("%s: expected %d channels, got %d", c.name, c.channels, config.Channels())
+			}
+			if config.FrameLength() != c.frameLen {
+				t.Errorf("%s: expected frame length %d, got %d", c.name, c.frameLen, config.FrameLength())
+			}
+
+			b, err := config.Marshal()
+			if err != nil {
+				t.Errorf("%s: Marshal failed: %v", c.name, err)
+			} else if !bytes.Equal(b, c.data) {
+				t.Errorf("%s: round trip % x, expected % x", c.name, b, c.data)
+			}
+		}
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			config AudioSpecificConfig
+		}{
+			{"explicit rate", AudioSpecificConfig{
+				ObjectType:           AotAacLc,
+				SamplingFrequency:    44000,
+				ChannelConfiguration: 2,
+			}},
+			{"LD", AudioSpecificConfig{
+				ObjectType:           AotErAacLd,
+				SamplingFrequency:    48000,
+				ChannelConfiguration: 2,
+				FrameLengthFlag:      true,
+				ExtensionFlag:        true,
+			}},
+			{"ELD with SBR", AudioSpecificConfig{
+				ObjectType:           AotErAacEld,
+				SamplingFrequency:    24000,
+				ChannelConfiguration: 3,
+				ExtensionFlag:        true,
+				ELD: &ELDSpecificConfig{
+					LdSbrPresent:      true,
+					LdSbrSamplingRate: true,
+					SbrHeaders: []SbrHeader{
+						{AmpRes: true, StartFreq: 5, StopFreq: 9, HeaderExtra1: true, FreqScale: 2, NoiseBands: 2},
+						{StartFreq: 5, StopFreq: 9, HeaderExtra2: true, LimiterBands: 2, LimiterGains: 2, SmoothingMode: true},
+					},
+					Extensions: []ELDExtension{{Type: 1, Data: bytes.Repeat([]byte{0xA5}, 20)}},
+				},
+			}},
+			{"ELD 7.1 with SBR", AudioSpecificConfig{
+				ObjectType:           AotErAacEld,
+				SamplingFrequency:    48000,
+				ChannelConfiguration: 12,
+				ELD: &ELDSpecificConfig{
+					LdSbrPresent: true,
+					SbrHeaders:   make([]SbrHeader, 4),
+				},
+			}},
+			{"PCE", AudioSpecificConfig{
+				ObjectType:        AotAacLc,
+				SamplingFrequency: 48000,
+				ProgramConfig: &ProgramConfigElement{
+					ObjectType:             1,
+					SamplingFrequencyIndex: 3,
+					FrontElements:          []ChannelElement{{TagSelect: 0}, {IsCPE: true, TagSelect: 0}},
+					BackElements:           []ChannelElement{{IsCPE: true, TagSelect: 1}},
+					LfeElements:            []int{0},
+					MatrixMixdownPresent:   true,
+					MatrixMixdownIdx:       1,
+					Comment:                []byte("5.1"),
+				},
+			}},
+		}
+		for _, c := range cases {
+			b, err := c.config.Marshal()
+			if err != nil {
+				t.Errorf("%s: Marshal failed: %v", c.name, err)
+				continue
+			}
+			config, err := Parse(b)
+			if err != nil {
+				t.Errorf("%s: Parse failed: %v", c.name, err)
+				continue
+			}
+			b2, err := config.Marshal()
+			if err != nil || !bytes.Equal(b, b2) {
+				t.Errorf("%s: round trip % x, expected % x (%v)", c.name, b2, b, err)
+			}
+		}
+
+		config, _ := Parse(mustMarshal(t, &cases[0].config))
+		if config.SamplingFrequency != 44000 {
+			t.Errorf("expected explicit sampling frequency 44000, got %d", config.SamplingFrequency)
+		}
+		config, _ = Parse(mustMarshal(t, &cases[2].config))
+		if config.SampleRate() != 48000 || config.FrameLength() != 1024 || config.Channels() != 3 {
+			t.Errorf("unexpected ELD rate %d, frame length %d, channels %d",
+				config.SampleRate(), config.FrameLength(), config.Channels())
+		}
+		if len(config.ELD.Extensions) != 1 || len(config.ELD.Extensions[0].Data) != 20 {
+			t.Errorf("unexpected ELD extensions %+v", config.ELD.Extensions)
+		}
+		config, _ = Parse(mustMarshal(t, &cases[4].config))
+		if config.Channels() != 6 || string(config.ProgramConfig.Comment) != "5.1" {
+			t.Errorf("unexpected PCE %+v", config.ProgramConfig)
+		}
+	})
+
+	t.Run("ParseBits", func(t *testing.T) {
+		// Two bits of padding in front of LC 44.1 kHz stereo.
+		data := []byte{0x04, 0x84, 0x00}
+		config, n, err := ParseBits(data, 2, -1)
+		if err != nil {
+			t.Fatalf("ParseBits failed: %v", err)
+		}
+		if n != 16 {
+			t.Errorf("expected 16 bits consumed, got %d", n)
+		}
+		if config.ObjectType != AotAacLc || config.SampleRate() != 44100 || config.ChannelConfiguration != 2 {
+			t.Errorf("unexpected config %+v", config)
+		}
+
+		b, nBits, err := config.MarshalBits()
+		if err != nil || nBits != 16 || !bytes.Equal(b, []byte{0x12, 0x10}) {
+			t.Errorf("unexpected MarshalBits % x, %d bits (%v)", b, nBits, err)
+		}
+
+		// Trailing bits which are not a sync extension are not consumed.
+		_, n, err = ParseBits([]byte{0x12, 0x10, 0xAB, 0xCD}, 0, 32)
+		if err != nil || n != 16 {
+			t.Errorf("expected 16 bits consumed, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := Parse([]byte{0x12}); !errors.Is(err, ErrShort) {
+			t.Errorf("expected ErrShort, got %v", err)
+		}
+		// Reserved sampling frequency index 13.
+		if _, err := Parse([]byte{0x16, 0x90}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		// USAC, escaped object type 42.
+		if _, err := Parse([]byte{0xF9, 0x46, 0x40, 0x00}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+		bad := AudioSpecificConfig{ObjectType: AotAacLc, SamplingFrequency: 44100, ChannelConfiguration: 2, PsPresent: true}
+		if _, err := bad.Marshal(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		bad = AudioSpecificConfig{ObjectType: AotAacLc, SamplingFrequency: 44100}
+		if _, err := bad.Marshal(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for missing PCE, got %v", err)
+		}
+	})
+}
+
+func mustMarshal(t *testing.T, c *AudioSpecificConfig) []byte {
+	b, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	return b
+}
@@ -1,5 +1,7 @@
 package fdkaac
 
+import "github.com/lizc2003/audio-fdkaac/asc"
+
 // The FileFormat is file format.
 type FileFormat int
 
@@ -52,19 +54,19 @@ const (
 	// Null Object
 	AotNullObject AudioObjectType = 0
 	// Main profile
-	AotAacMain AudioObjectType = 1
+	AotAacMain AudioObjectType = asc.AotAacMain
 	// Low Complexity object
-	AotAacLc AudioObjectType = 2
+	AotAacLc AudioObjectType = asc.AotAacLc
 	// Scalable Sampling Rate
-	AotAacSsr AudioObjectType = 3
+	AotAacSsr AudioObjectType = asc.AotAacSsr
 	// Long Term Prediction
-	AotAacLtp AudioObjectType = 4
+	AotAacLtp AudioObjectType = asc.AotAacLtp
 	// Spectral Band Replication
-	AotSbr AudioObjectType = 5
+	AotSbr AudioObjectType = asc.AotSbr
 	// Scalable
-	AotAacScal AudioObjectType = 6
+	AotAacScal AudioObjectType = asc.AotAacScal
 	// TwinVQ
-	AotTwinVq AudioObjectType = 7
+	AotTwinVq AudioObjectType = asc.AotTwinVq
 	// Code-Excited Linear Prediction
 	AotCelp AudioObjectType = 8
 	// Harmonic Vector Excitation Coding
@@ -84,19 +86,19 @@ const (
 	// Algorithmic Synthesis and Audio FX object
 	AotAlgSynthAudFx AudioObjectType = 16
 	// Error Resilient(ER) AAC Low Complexity
-	AotErAacLc AudioObjectType = 17
+	AotErAacLc AudioObjectType = asc.AotErAacLc
 	// Reserved
 	AotRsvd18 AudioObjectType = 18
 	// Error Resilient(ER) AAC LTP object
-	AotErAacLtp AudioObjectType = 19
+	AotErAacLtp AudioObjectType = asc.AotErAacLtp
 	// Error Resilient(ER) AAC Scalable object
-	AotErAacScal AudioObjectType = 20
+	AotErAacScal AudioObjectType = asc.AotErAacScal
 	// Error Resilient(ER) TwinVQ object
-	AotErTwinVq AudioObjectType = 21
+	AotErTwinVq AudioObjectType = asc.AotErTwinVq
 	// Error Resilient(ER) BSAC object
-	AotErBsac AudioObjectType = 22
+	AotErBsac AudioObjectType = asc.AotErBsac
 	// Error Resilient(ER) AAC LowDelay object
-	AotErAacLd AudioObjectType = 23
+	AotErAacLd AudioObjectType = asc.AotErAacLd
 	// Error Resilient(ER) CELP object
 	AotErCelp AudioObjectType = 24
 	// Error Resilient(ER) HVXC object
@@ -108,12 +110,12 @@ const (
 	// Might become SSC
 	AotRsvd28 AudioObjectType = 28
 	// PS, Parametric Stereo (includes SBR)
-	AotPs AudioObjectType = 29
+	AotPs AudioObjectType = asc.AotPs
 	// MPEG Surround
 	AotMpegs AudioObjectType = 30
 
 	// Signal AOT uses more than 5 bits
-	AotEscape AudioObjectType = 31
+	AotEscape AudioObjectType = asc.AotEscape
 
 	// MPEG-Layer1 in mp4
 	AotMp3OnMp4L1 AudioObjectType = 32
@@ -130,7 +132,7 @@ const (
 	// Scalable To Lossless
 	AotSls AudioObjectType = 38
 	// AAC Enhanced Low Delay
-	AotErAacEld AudioObjectType = 39
+	AotErAacEld AudioObjectType = asc.AotErAacEld
 	// Unified Speech and Audio Coding
 	AotUsac AudioObjectType = 42
 	// Spatial Audio Object Coding
@@ -242,6 +244,16 @@ const (
 	ActBackBottom AudioChannelType = 0x23
 )
 
+// Position returns the horizontal speaker position (ActNone, ActFront, ActSide, ActBack or ActLfe).
+func (t AudioChannelType) Position() AudioChannelType {
+	return t & 0x0F
+}
+
+// Height returns the vertical speaker position (ActNone for normal height, ActTop or ActBottom).
+func (t AudioChannelType) Height() AudioChannelType {
+	return t & 0xF0
+}
+
 // SBR Mode
 type SbrMode int
 
@@ -0,0 +1,387 @@
+// Package dash generates MPEG-DASH manifests (MPDs) for AAC representations
+// packaged as CMAF with mp4.Segmenter. The codecs string, sampling rate and
+// channel configuration are taken from the AudioSpecificConfig in
+// EncInfo.ConfBuf.
+package dash
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/mp4"
+)
+
+// Addressing selects how the SegmentTemplate addresses the media segments.
+type Addressing int
+
+const (
+	// $Number$ with a SegmentTimeline.
+	AddressingNumberTimeline Addressing = iota
+	// $Time$ with a SegmentTimeline.
+	AddressingTimeTimeline
+	// $Number$ with the constant nominal duration of MPDConfig.SegmentDuration.
+	AddressingNumberDuration
+)
+
+const (
+	// Default media segment template.
+	DefaultMediaNumber = "$RepresentationID$/segment-$Number$.m4s"
+	// Default media segment template for AddressingTimeTimeline.
+	DefaultMediaTime = "$RepresentationID$/segment-$Time$.m4s"
+	// Default init segment template.
+	DefaultInitialization = "$RepresentationID$/init.mp4"
+	// Default minimum buffer time.
+	DefaultMinBufferTime = 2 * time.Second
+
+	profileLive = "urn:mpeg:dash:profile:isoff-live:2011"
+	profileCmaf = "urn:mpeg:dash:profile:cmaf:2019"
+	schemeCicp  = "urn:mpeg:mpegB:cicp:ChannelConfiguration"
+	scheme23003 = "urn:mpeg:dash:23003:3:audio_channel_configuration:2011"
+)
+
+var (
+	ErrInvalid = errors.New("dash: invalid data")
+)
+
+// Segment is a media segment of a representation, with times in the
+// timescale of the representation, the sample rate of its track.
+type Segment struct {
+	// $Number$ of the segment, the sequence number of its moof.
+	Number uint32
+	// Decode time of the first access unit, the tfdt value.
+	Time uint64
+	// Duration, the sum of the access unit durations.
+	Duration uint64
+}
+
+// Representation is an encoded AAC representation.
+type Representation struct {
+	// Representation@id, substituted for $RepresentationID$.
+	ID string
+	// Representation@bandwidth in bits per second.
+	Bandwidth int
+	// Media segments available, in order. For a dynamic MPD only the
+	// segments of the time shift buffer should be listed.
+	Segments []Segment
+
+	codecs     string
+	timescale  int
+	sampleRate int
+	channels   int
+	channelCfg int
+}
+
+// NewRepresentation returns a Representation for the stream described by
+// conf, the AudioSpecificConfig from EncInfo.ConfBuf. objectType is the
+// audio object type of the encoder, matching fdkaac.AudioObjectType; if 0 it
+// is derived from conf, which only shows SBR and PS with explicit signaling.
+func NewRepresentation(id string, conf []byte, objectType int) (*Representation, error) {
+	config, err := asc.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	r := &Representation{
+		ID:         id,
+		timescale:  config.SampleRate(),
+		sampleRate: config.SampleRate(),
+		channels:   config.Channels(),
+		channelCfg: config.ChannelConfiguration,
+	}
+	if r.timescale == 0 {
+		return nil, fmt.Errorf("%w: no sample rate", ErrInvalid)
+	}
+
+	if objectType == 0 {
+		objectType = config.ObjectType
+		if config.PsPresent {
+			objectType = asc.AotPs
+		} else if config.SbrPresent && config.ObjectType != asc.AotErAacEld {
+			objectType = asc.AotSbr
+		}
+	} else if (objectType == asc.AotSbr || objectType == asc.AotPs) && !config.SbrPresent {
+		// With implicit signaling the output rate is twice the core rate.
+		r.sampleRate *= 2
+	}
+	if objectType == asc.AotPs && config.ChannelConfiguration == 1 {
+		r.channels = 2
+		r.channelCfg = 2
+	}
+	r.codecs = fmt.Sprintf("mp4a.40.%d", objectType)
+	return r, nil
+}
+
+// Codecs returns the codecs attribute.
+func (r *Representation) Codecs() string {
+	return r.codecs
+}
+
+// Timescale returns the timescale of the segment times.
+func (r *Representation) Timescale() int {
+	return r.timescale
+}
+
+// AddSegment appends a segment produced by mp4.Segmenter.
+func (r *Representation) AddSegment(s *mp4.Segment) {
+	r.Segments = append(r.Segments, Segment{
+		Number:   s.Sequence,
+		Time:     s.DecodeTime,
+		Duration: s.Duration,
+	})
+}
+
+// MPDConfig configures the generated MPD.
+type MPDConfig struct {
+	// Dynamic selects a live MPD, otherwise a static (VOD) MPD is generated.
+	Dynamic bool
+	// Segment addressing of the SegmentTemplate.
+	Addressing Addressing
+	// Media segment template, DefaultMediaNumber or DefaultMediaTime if empty.
+	Media string
+	// Init segment template (default DefaultInitialization).
+	Initialization string
+	// Nominal segment duration, required for AddressingNumberDuration.
+	SegmentDuration time.Duration
+	// MPD@minBufferTime (default DefaultMinBufferTime).
+	MinBufferTime time.Duration
+	// Language of the AdaptationSet, if known.
+	Lang string
+
+	// MPD@availabilityStartTime of a dynamic MPD, the wall clock time of time 0.
+	// Required for a dynamic MPD.
+	AvailabilityStartTime time.Time
+	// MPD@publishTime of a dynamic MPD (default the current time).
+	PublishTime time.Time
+	// MPD@minimumUpdatePeriod of a dynamic MPD, if set.
+	MinimumUpdatePeriod time.Duration
+	// MPD@timeShiftBufferDepth of a dynamic MPD, if set.
+	TimeShiftBufferDepth time.Duration
+	// MPD@suggestedPresentationDelay of a dynamic MPD, if set.
+	SuggestedPresentationDelay time.Duration
+}
+
+type mpd struct {
+	XMLName                    xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string   `xml:"profiles,attr"`
+	Type                       string   `xml:"type,attr"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	MediaPresentationDuration  string   `xml:"mediaPresentationDuration,attr,omitempty"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                string   `xml:"publishTime,attr,omitempty"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr,omitempty"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr,omitempty"`
+	Period                     period   `xml:"Period"`
+}
+
+type period struct {
+	ID            string        `xml:"id,attr"`
+	Start         string        `xml:"start,attr"`
+	AdaptationSet adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ContentType      string           `xml:"contentType,attr"`
+	MimeType         string           `xml:"mimeType,attr"`
+	Lang             string           `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool             `xml:"segmentAlignment,attr"`
+	Representations  []representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID                        string          `xml:"id,attr"`
+	Bandwidth                 int             `xml:"bandwidth,attr"`
+	Codecs                    string          `xml:"codecs,attr"`
+	AudioSamplingRate         int             `xml:"audioSamplingRate,attr"`
+	AudioChannelConfiguration descriptor      `xml:"AudioChannelConfiguration"`
+	SegmentTemplate           segmentTemplate `xml:"SegmentTemplate"`
+}
+
+type descriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type segmentTemplate struct {
+	Timescale              int              `xml:"timescale,attr"`
+	PresentationTimeOffset uint64           `xml:"presentationTimeOffset,attr,omitempty"`
+	Initialization         string           `xml:"initialization,attr"`
+	Media                  string           `xml:"media,attr"`
+	StartNumber            *uint32          `xml:"startNumber,attr"`
+	Duration               uint64           `xml:"duration,attr,omitempty"`
+	SegmentTimeline        *segmentTimeline `xml:"SegmentTimeline"`
+}
+
+type segmentTimeline struct {
+	S []timelineEntry `xml:"S"`
+}
+
+type timelineEntry struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr,omitempty"`
+}
+
+// Marshal returns the MPD with one audio AdaptationSet holding reps.
+func Marshal(config *MPDConfig, reps ...*Representation) ([]byte, error) {
+	var c MPDConfig
+	if config != nil {
+		c = *config
+	}
+	if c.MinBufferTime <= 0 {
+		c.MinBufferTime = DefaultMinBufferTime
+	}
+	if c.Initialization == "" {
+		c.Initialization = DefaultInitialization
+	}
+	identifier := "$Number$"
+	if c.Addressing == AddressingTimeTimeline {
+		identifier = "$Time$"
+	}
+	if c.Media == "" {
+		c.Media = DefaultMediaNumber
+		if c.Addressing == AddressingTimeTimeline {
+			c.Media = DefaultMediaTime
+		}
+	}
+	if !strings.Contains(c.Media, identifier) {
+		return nil, fmt.Errorf("%w: media template %q lacks %s", ErrInvalid, c.Media, identifier)
+	}
+	if c.Addressing < AddressingNumberTimeline || c.Addressing > AddressingNumberDuration ||
+		c.Addressing == AddressingNumberDuration && c.SegmentDuration <= 0 {
+		return nil, fmt.Errorf("%w: addressing %d with segment duration %v", ErrInvalid, c.Addressing, c.SegmentDuration)
+	}
+	if len(reps) == 0 {
+		return nil, fmt.Errorf("%w: no representation", ErrInvalid)
+	}
+	if c.Dynamic && c.AvailabilityStartTime.IsZero() {
+		return nil, fmt.Errorf("%w: dynamic MPD without availability start time", ErrInvalid)
+	}
+
+	m := &mpd{
+		Profiles:      profileLive + "," + profileCmaf,
+		Type:          "static",
+		MinBufferTime: formatDuration(c.MinBufferTime),
+		Period: period{
+			ID:    "0",
+			Start: "PT0S",
+			AdaptationSet: adaptationSet{
+				ContentType:      "audio",
+				MimeType:         "audio/mp4",
+				Lang:             c.Lang,
+				SegmentAlignment: true,
+			},
+		},
+	}
+	if c.Dynamic {
+		m.Type = "dynamic"
+		publish := c.PublishTime
+		if publish.IsZero() {
+			publish = time.Now()
+		}
+		m.AvailabilityStartTime = c.AvailabilityStartTime.UTC().Format(time.RFC3339Nano)
+		m.PublishTime = publish.UTC().Format(time.RFC3339Nano)
+		m.MinimumUpdatePeriod = formatDuration(c.MinimumUpdatePeriod)
+		m.TimeShiftBufferDepth = formatDuration(c.TimeShiftBufferDepth)
+		m.SuggestedPresentationDelay = formatDuration(c.SuggestedPresentationDelay)
+	}
+
+	var duration time.Duration
+	for _, r := range reps {
+		rep := representation{
+			ID:                r.ID,
+			Bandwidth:         r.Bandwidth,
+			Codecs:            r.codecs,
+			AudioSamplingRate: r.sampleRate,
+			AudioChannelConfiguration: descriptor{
+				SchemeIDURI: schemeCicp,
+				Value:       strconv.Itoa(r.channelCfg),
+			},
+			SegmentTemplate: segmentTemplate{
+				Timescale:      r.timescale,
+				Initialization: c.Initialization,
+				Media:          c.Media,
+			},
+		}
+		if r.channelCfg < 1 || r.channelCfg > 7 {
+			// Channels given by a PCE.
+			rep.AudioChannelConfiguration = descriptor{
+				SchemeIDURI: scheme23003,
+				Value:       strconv.Itoa(r.channels),
+			}
+		}
+
+		st := &rep.SegmentTemplate
+		if !c.Dynamic && len(r.Segments) > 0 {
+			// The period of a static MPD starts with the first segment. A
+			// dynamic MPD maps the media time to the availability start time.
+			st.PresentationTimeOffset = r.Segments[0].Time
+		}
+		if c.Addressing != AddressingTimeTimeline {
+			number := uint32(1)
+			if len(r.Segments) > 0 {
+				number = r.Segments[0].Number
+			}
+			st.StartNumber = &number
+		}
+		if c.Addressing == AddressingNumberDuration {
+			st.Duration = uint64(c.SegmentDuration) * uint64(r.timescale) / uint64(time.Second)
+		} else {
+			st.SegmentTimeline = timeline(r.Segments)
+		}
+		m.Period.AdaptationSet.Representations = append(m.Period.AdaptationSet.Representations, rep)
+
+		if n := len(r.Segments); n > 0 {
+			end := r.Segments[n-1].Time + r.Segments[n-1].Duration - r.Segments[0].Time
+			duration = max(duration, time.Duration(end*uint64(time.Second)/uint64(r.timescale)))
+		}
+	}
+	if !c.Dynamic {
+		if duration <= 0 {
+			return nil, fmt.Errorf("%w: static MPD without segments", ErrInvalid)
+		}
+		m.MediaPresentationDuration = formatDuration(duration)
+	}
+
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// timeline returns the SegmentTimeline of segments. Runs of contiguous
+// segments of equal duration are merged into one S element with a repeat
+// count, and t is only given where the timeline starts or has a gap.
+func timeline(segments []Segment) *segmentTimeline {
+	tl := &segmentTimeline{S: []timelineEntry{}}
+	var next uint64
+	for i, s := range segments {
+		if i > 0 && s.Time == next {
+			last := &tl.S[len(tl.S)-1]
+			if last.D == s.Duration {
+				last.R++
+				next += s.Duration
+				continue
+			}
+			tl.S = append(tl.S, timelineEntry{D: s.Duration})
+		} else {
+			t := s.Time
+			tl.S = append(tl.S, timelineEntry{T: &t, D: s.Duration})
+		}
+		next = s.Time + s.Duration
+	}
+	return tl
+}
+
+// formatDuration returns d as an xs:duration in seconds, or "" if d is 0.
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
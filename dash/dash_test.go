@@ -0,0 +1,192 @@
+package dash
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/mp4"
+)
+
+// LC 44.1 kHz stereo.
+var testConf = []byte{0x12, 0x10}
+
+func testRepresentation(t *testing.T, numAUs int) *Representation {
+	s, err := mp4.NewSegmenter(testConf, &mp4.SegmenterConfig{SegmentDuration: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSegmenter failed: %v", err)
+	}
+	r, err := NewRepresentation("a64", testConf, 0)
+	if err != nil {
+		t.Fatalf("NewRepresentation failed: %v", err)
+	}
+	r.Bandwidth = 64000
+	for i := 0; i < numAUs; i++ {
+		seg, err := s.WriteAU(make([]byte, 100))
+		if err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+		if seg != nil {
+			r.AddSegment(seg)
+		}
+	}
+	if seg := s.Flush(); seg != nil {
+		r.AddSegment(seg)
+	}
+	return r
+}
+
+func parse(t *testing.T, b []byte) *mpd {
+	m := &mpd{}
+	if err := xml.Unmarshal(b, m); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, b)
+	}
+	return m
+}
+
+func TestMarshal(t *testing.T) {
+	t.Run("Representation", func(t *testing.T) {
+		cases := []struct {
+			conf       []byte
+			objectType int
+			codecs     string
+			sampleRate int
+			timescale  int
+		}{
+			{testConf, 0, "mp4a.40.2", 44100, 44100},
+			{[]byte{0x13, 0x90}, 5, "mp4a.40.5", 44100, 22050},
+			{[]byte{0x2B, 0x92, 0x08, 0x00}, 0, "mp4a.40.5", 44100, 44100},
+			{[]byte{0xEB, 0x8A, 0x08, 0x00}, 0, "mp4a.40.29", 44100, 44100},
+		}
+		for _, c := range cases {
+			r, err := NewRepresentation("a", c.conf, c.objectType)
+			if err != nil {
+				t.Fatalf("NewRepresentation failed: %v", err)
+			}
+			if r.Codecs() != c.codecs || r.sampleRate != c.sampleRate || r.Timescale() != c.timescale {
+				t.Errorf("expected %s at %d Hz, timescale %d, got %s at %d Hz, timescale %d",
+					c.codecs, c.sampleRate, c.timescale, r.Codecs(), r.sampleRate, r.Timescale())
+			}
+		}
+	})
+
+	t.Run("Static timeline", func(t *testing.T) {
+		// 87, 86, 86 and 41 AUs of 1024 samples.
+		r := testRepresentation(t, 300)
+		b, err := Marshal(nil, r)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		m := parse(t, b)
+		if m.Type != "static" || m.MediaPresentationDuration != "PT6.965986394S" {
+			t.Errorf("unexpected MPD %s, duration %s", m.Type, m.MediaPresentationDuration)
+		}
+		rep := m.Period.AdaptationSet.Representations[0]
+		if rep.Codecs != "mp4a.40.2" || rep.AudioSamplingRate != 44100 || rep.Bandwidth != 64000 {
+			t.Errorf("unexpected representation %+v", rep)
+		}
+		if rep.AudioChannelConfiguration != (descriptor{schemeCicp, "2"}) {
+			t.Errorf("unexpected channel configuration %+v", rep.AudioChannelConfiguration)
+		}
+		st := rep.SegmentTemplate
+		if st.Timescale != 44100 || st.PresentationTimeOffset != 0 || st.StartNumber == nil || *st.StartNumber != 1 ||
+			st.Media != DefaultMediaNumber || st.Initialization != DefaultInitialization {
+			t.Errorf("unexpected segment template %+v", st)
+		}
+		s := st.SegmentTimeline.S
+		if len(s) != 3 || s[0].T == nil || *s[0].T != 0 || s[0].D != 87*1024 ||
+			s[1].T != nil || s[1].D != 86*1024 || s[1].R != 1 || s[2].D != 41*1024 {
+			t.Errorf("unexpected timeline %s", b)
+		}
+	})
+
+	t.Run("Static offset", func(t *testing.T) {
+		// A VOD cut out of a longer stream starts at the time of its first segment.
+		r := testRepresentation(t, 500)
+		r.Segments = r.Segments[2:4]
+		b, err := Marshal(nil, r)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		m := parse(t, b)
+		st := m.Period.AdaptationSet.Representations[0].SegmentTemplate
+		if st.PresentationTimeOffset != r.Segments[0].Time || *st.StartNumber != 3 {
+			t.Errorf("expected presentationTimeOffset %d, got %+v", r.Segments[0].Time, st)
+		}
+		if m.MediaPresentationDuration != "PT3.993832199S" {
+			t.Errorf("unexpected duration %s", m.MediaPresentationDuration)
+		}
+	})
+
+	t.Run("Dynamic time", func(t *testing.T) {
+		r := testRepresentation(t, 500)
+		// Keep the last three segments, as a live packager would.
+		r.Segments = r.Segments[len(r.Segments)-3:]
+		start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		b, err := Marshal(&MPDConfig{
+			Dynamic:               true,
+			Addressing:            AddressingTimeTimeline,
+			AvailabilityStartTime: start,
+			PublishTime:           start.Add(time.Minute),
+			MinimumUpdatePeriod:   2 * time.Second,
+			TimeShiftBufferDepth:  6 * time.Second,
+			Lang:                  "en",
+		}, r)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		m := parse(t, b)
+		if m.Type != "dynamic" || m.MediaPresentationDuration != "" ||
+			m.AvailabilityStartTime != "2026-01-02T03:04:05Z" || m.PublishTime != "2026-01-02T03:05:05Z" ||
+			m.MinimumUpdatePeriod != "PT2S" || m.TimeShiftBufferDepth != "PT6S" {
+			t.Errorf("unexpected MPD:\n%s", b)
+		}
+		st := m.Period.AdaptationSet.Representations[0].SegmentTemplate
+		if st.StartNumber != nil || st.PresentationTimeOffset != 0 || st.Media != DefaultMediaTime {
+			t.Errorf("unexpected segment template %+v", st)
+		}
+		if s := st.SegmentTimeline.S; len(s) == 0 || s[0].T == nil || *s[0].T != r.Segments[0].Time {
+			t.Errorf("timeline does not start at %d:\n%s", r.Segments[0].Time, b)
+		}
+		if m.Period.AdaptationSet.Lang != "en" {
+			t.Errorf("expected lang en, got %q", m.Period.AdaptationSet.Lang)
+		}
+	})
+
+	t.Run("Number duration", func(t *testing.T) {
+		r := testRepresentation(t, 100)
+		b, err := Marshal(&MPDConfig{Addressing: AddressingNumberDuration, SegmentDuration: 2 * time.Second}, r)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		st := parse(t, b).Period.AdaptationSet.Representations[0].SegmentTemplate
+		if st.Duration != 88200 || st.SegmentTimeline != nil {
+			t.Errorf("unexpected segment template %+v", st)
+		}
+		if !strings.HasPrefix(string(b), xml.Header) {
+			t.Errorf("missing XML header")
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		r := testRepresentation(t, 10)
+		if _, err := Marshal(&MPDConfig{Addressing: AddressingTimeTimeline, Media: "$Number$.m4s"}, r); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		if _, err := Marshal(&MPDConfig{Addressing: AddressingNumberDuration}, r); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		if _, err := Marshal(nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		if _, err := Marshal(&MPDConfig{Dynamic: true}, r); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for a dynamic MPD without start time, got %v", err)
+		}
+		empty, _ := NewRepresentation("a", testConf, 0)
+		if _, err := Marshal(nil, empty); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for a static MPD without segments, got %v", err)
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 )
 
 /*
+#include <stdlib.h>
 #include "deps/include/aacdecoder_lib.h"
 
 AAC_DECODER_ERROR aacDecoder_ConfigRawWrapped(HANDLE_AACDECODER self,
@@ -46,6 +47,23 @@ AAC_DECODER_ERROR aacDecoder_DecodeWrapped(HANDLE_AACDECODER self,
 	return AAC_DEC_OK;
 }
 
+AAC_DECODER_ERROR aacDecoder_FillWrapped(HANDLE_AACDECODER self,
+			UCHAR *pBuffer, const UINT bufferSize, UINT *bytesValid) {
+	return aacDecoder_Fill(self, &pBuffer, &bufferSize, bytesValid);
+}
+
+AAC_DECODER_ERROR aacDecoder_DecodeFrameWrapped(HANDLE_AACDECODER self,
+			UCHAR *pOut, INT outSize, UINT flags, UINT *bytesDecode) {
+	AAC_DECODER_ERROR errNo;
+	*bytesDecode = 0;
+	errNo = aacDecoder_DecodeFrame(self, (INT_PCM *)pOut, outSize/2, flags);
+	if (IS_OUTPUT_VALID(errNo) || (errNo > aac_dec_anc_data_error_start && errNo < aac_dec_anc_data_error_end)) {
+		CStreamInfo* info = aacDecoder_GetStreamInfo(self);
+		*bytesDecode = info->frameSize * info->numChannels * 2;
+	}
+	return errNo;
+}
+
 */
 import "C"
 
@@ -89,6 +107,14 @@ var decErrors = [...]error{
 	C.AAC_DEC_TOO_MANY_ANC_ELEMENTS:         errors.New("more than the allowed number of ancillary data elements should be written to buffer"),
 }
 
+// The registered ancillary data buffer is too small to receive the parsed data.
+// The frame's PCM output is still valid.
+var DecErrTooSmallAncBuffer = decErrors[C.AAC_DEC_TOO_SMALL_ANC_BUFFER]
+
+// More ancillary data elements were found than fit into the registered buffer.
+// The frame's PCM output is still valid.
+var DecErrTooManyAncElements = decErrors[C.AAC_DEC_TOO_MANY_ANC_ELEMENTS]
+
 // getDecError safely converts C error code to Go error
 func getDecError(errNo C.AAC_DECODER_ERROR) error {
 	if int(errNo) >= 0 && int(errNo) < len(decErrors) {
@@ -197,6 +223,9 @@ type DecoderConfig struct {
 	EnableUnidrcAlbumMode bool
 	// Quadrature Mirror Filter (QMF) Bank processing mode.
 	QmfLowpowerMode QmfLowpowerMode
+	// Size in bytes of the ancillary data buffer registered with the decoder.
+	// Ancillary data is only extracted when this is greater than 0.
+	AncDataBufSize int
 }
 
 // StreamInfo gives information about the currently decoded audio data.
@@ -209,6 +238,8 @@ type StreamInfo struct {
 	FrameBytes int
 	// The number of output audio channels before the rendering module.
 	NumChannels int
+	// Speaker position and index of each output audio channel, in output buffer order.
+	Channels []ChannelInfo
 	// Decoder internal members.
 	//Sampling rate in Hz without SBR divided by a (ELD) downscale factor if present.
 	AacSampleRate int
@@ -252,10 +283,29 @@ type StreamInfo struct {
 	DrcPresMode int8
 }
 
+// AncillaryData holds the ancillary data elements parsed from one decoded frame.
+type AncillaryData struct {
+	// Byte offset of the frame's PCM within the output buffer.
+	PcmOffset int
+	// Ancillary data elements in bitstream order.
+	Elements [][]byte
+}
+
+// ChannelInfo describes one output audio channel.
+type ChannelInfo struct {
+	// Speaker position of the channel.
+	Type AudioChannelType
+	// Sub index among the channels of the same type, starting with 0 at the front
+	// center and incrementing pairwise from left to right and front to back.
+	Index int
+}
+
 type Decoder struct {
 	ph         C.HANDLE_AACDECODER
 	info       *StreamInfo
 	remainData []byte
+	ancBuf     *C.uchar
+	pcmBuf     []byte
 }
 
 // NewDecoder
@@ -389,6 +439,16 @@ func NewDecoder(config *DecoderConfig) (*Decoder, error) {
 			return nil, getDecError(errNo)
 		}
 	}
+	if config.AncDataBufSize > 0 {
+		// The library keeps the pointer, so the buffer must live in C memory.
+		dec.ancBuf = (*C.uchar)(C.malloc(C.size_t(config.AncDataBufSize)))
+		if errNo = C.aacDecoder_AncDataInit(dec.ph, dec.ancBuf,
+			C.int(config.AncDataBufSize)); errNo != C.AAC_DEC_OK {
+			C.free(unsafe.Pointer(dec.ancBuf))
+			dec.ancBuf = nil
+			return nil, getDecError(errNo)
+		}
+	}
 
 	return dec, nil
 }
@@ -444,6 +504,222 @@ func (dec *Decoder) Decode(in, out []byte) (n int, err error) {
 	return int(bytesDecoded), nil
 }
 
+// DecodeWithAncillary works like Decode and additionally returns the ancillary data
+// elements of every decoded frame. The decoder must be created with AncDataBufSize > 0.
+// If a frame's ancillary data did not fit into the registered buffer, the decoded PCM
+// is still returned together with DecErrTooSmallAncBuffer or DecErrTooManyAncElements.
+// On other errors the PCM and ancillary data of the frames decoded before the
+// failing one are returned with the error.
+func (dec *Decoder) DecodeWithAncillary(in, out []byte) (n int, anc []AncillaryData, err error) {
+	if dec.ancBuf == nil {
+		return 0, nil, errors.New("ancillary data buffer is not configured")
+	}
+	if len(in) == 0 {
+		return 0, nil, errors.New("input buffer is empty")
+	}
+	if len(out) < dec.EstimateOutBufBytes(EstimateFrames) {
+		return 0, nil, errors.New("output buffer size is not enough")
+	}
+
+	if err = dec.fill(in); err != nil {
+		return 0, nil, err
+	}
+
+	var ancErr error
+	for {
+		if n >= len(out) {
+			return n, anc, getDecError(C.AAC_DEC_OUTPUT_BUFFER_TOO_SMALL)
+		}
+		var bytesDecoded C.uint
+		errNo := C.aacDecoder_DecodeFrameWrapped(dec.ph, (*C.uchar)(unsafe.Pointer(&out[n])),
+			C.INT(len(out)-n), 0, &bytesDecoded)
+		switch errNo {
+		case C.AAC_DEC_OK:
+		case C.AAC_DEC_TOO_SMALL_ANC_BUFFER, C.AAC_DEC_TOO_MANY_ANC_ELEMENTS:
+			ancErr = getDecError(errNo)
+		case C.AAC_DEC_NOT_ENOUGH_BITS:
+			return n, anc, ancErr
+		default:
+			return n, anc, getDecError(errNo)
+		}
+
+		anc = append(anc, AncillaryData{PcmOffset: n, Elements: dec.getAncElements()})
+		n += int(bytesDecoded)
+
+		if dec.info == nil {
+			if dec.info, err = getStreamInfo(dec.ph); err != nil {
+				return n, anc, err
+			}
+		}
+	}
+}
+
+// DecodeFlag controls a single DecodeFrame call.
+type DecodeFlag uint
+
+const (
+	// Trigger the built-in error concealment for a lost access unit.
+	DecodeFlagConceal DecodeFlag = C.AACDEC_CONCEAL
+	// Discard input data and flush the filter banks to output the delayed audio.
+	DecodeFlagFlush DecodeFlag = C.AACDEC_FLUSH
+	// Signal an input discontinuity, the decoder resynchronizes as necessary.
+	DecodeFlagIntr DecodeFlag = C.AACDEC_INTR
+	// Clear all signal delay lines and history buffers.
+	DecodeFlagClearHistory DecodeFlag = C.AACDEC_CLRHIST
+)
+
+// decErrorCodes maps the errors of decErrors back to their codes.
+var decErrorCodes = func() map[error]int {
+	codes := make(map[error]int)
+	for code, err := range decErrors {
+		if err != nil {
+			codes[err] = code
+		}
+	}
+	return codes
+}()
+
+// IsDecodeError reports whether err is a bitstream decode error after which
+// DecodeFrame still returns valid, concealed PCM.
+func IsDecodeError(err error) bool {
+	code, ok := decErrorCodes[err]
+	return ok && code >= C.aac_dec_decode_error_start && code <= C.aac_dec_decode_error_end
+}
+
+// DecodeFrame feeds in to the decoder and decodes exactly one access unit.
+// in may be empty, e.g. to decode the next frame already buffered, to conceal
+// a lost access unit with DecodeFlagConceal or to drain with DecodeFlagFlush.
+// Input that did not fit into the decoder's buffer on earlier calls is fed
+// first, also when in is empty.
+// Returns the number of PCM bytes written and a StreamInfo snapshot for the frame.
+// n is 0 with a nil error when more input is needed. On decode errors (see
+// IsDecodeError) the concealed PCM is returned together with the error.
+func (dec *Decoder) DecodeFrame(in, out []byte, flags DecodeFlag) (n int, info *StreamInfo, err error) {
+	if len(out) < dec.EstimateOutBufBytes(1) {
+		return 0, nil, errors.New("output buffer size is not enough")
+	}
+	if len(in) > 0 || len(dec.remainData) > 0 {
+		if err = dec.fill(in); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var bytesDecoded C.uint
+	errNo := C.aacDecoder_DecodeFrameWrapped(dec.ph, (*C.uchar)(unsafe.Pointer(&out[0])),
+		C.INT(len(out)), C.uint(flags), &bytesDecoded)
+	if errNo == C.AAC_DEC_NOT_ENOUGH_BITS {
+		return 0, nil, nil
+	}
+	if bytesDecoded == 0 {
+		return 0, nil, getDecError(errNo)
+	}
+
+	if info, err = getStreamInfo(dec.ph); err != nil {
+		return 0, nil, err
+	}
+	if dec.info == nil {
+		dec.info = info
+	}
+	return int(bytesDecoded), info, getDecError(errNo)
+}
+
+// Flush decodes the input still buffered and drains the samples delayed in
+// the decoder's filter banks (StreamInfo.OutputDelay) at the end of the stream.
+// out must hold at least the delayed samples plus one frame.
+// Returns the number of PCM bytes written to out. If the buffered input does
+// not fit, the PCM decoded so far is returned with an error and Flush can be
+// called again.
+func (dec *Decoder) Flush(out []byte) (n int, err error) {
+	if dec.info == nil {
+		return 0, nil
+	}
+	info, err := getStreamInfo(dec.ph)
+	if err != nil {
+		return 0, err
+	}
+	delayBytes := info.OutputDelay * info.NumChannels * SampleBitDepth / 8
+	// The delayed samples are drained in whole frames.
+	drainBytes := delayBytes + info.FrameBytes
+	if len(out) < drainBytes {
+		return 0, errors.New("output buffer size is not enough")
+	}
+
+	// Decode the input that did not fit into the decoder's buffer yet, as
+	// DecodeFrame does, instead of dropping it.
+	for len(dec.remainData) > 0 {
+		if err = dec.fill(nil); err != nil {
+			return n, err
+		}
+		start := n
+		for {
+			if len(out)-n < drainBytes+info.FrameBytes {
+				return n, errors.New("output buffer size is not enough")
+			}
+			var bytesDecoded C.uint
+			C.aacDecoder_DecodeFrameWrapped(dec.ph, (*C.uchar)(unsafe.Pointer(&out[n])),
+				C.INT(len(out)-n), 0, &bytesDecoded)
+			if bytesDecoded == 0 {
+				break
+			}
+			n += int(bytesDecoded)
+		}
+		if n == start {
+			// The rest is not a decodable frame.
+			dec.remainData = nil
+		}
+	}
+	if delayBytes == 0 {
+		return n, nil
+	}
+
+	delayStart := n
+	for n-delayStart < delayBytes {
+		var bytesDecoded C.uint
+		errNo := C.aacDecoder_DecodeFrameWrapped(dec.ph, (*C.uchar)(unsafe.Pointer(&out[n])),
+			C.INT(len(out)-n), C.uint(DecodeFlagFlush), &bytesDecoded)
+		if errNo != C.AAC_DEC_OK || bytesDecoded == 0 {
+			break
+		}
+		n += int(bytesDecoded)
+	}
+
+	// Anything beyond the delayed samples is padding produced by the flush.
+	return min(n, delayStart+delayBytes), nil
+}
+
+// fill copies in, after any leftover data, into the decoder's internal input buffer.
+func (dec *Decoder) fill(in []byte) error {
+	if len(dec.remainData) > 0 {
+		in = append(dec.remainData, in...)
+		dec.remainData = nil
+	}
+
+	szIn := len(in)
+	bytesValid := C.uint(szIn)
+	if errNo := C.aacDecoder_FillWrapped(dec.ph, (*C.uchar)(unsafe.Pointer(&in[0])),
+		C.uint(szIn), &bytesValid); errNo != C.AAC_DEC_OK {
+		return getDecError(errNo)
+	}
+	if bytesValid > 0 {
+		dec.remainData = append(dec.remainData, in[szIn-int(bytesValid):]...)
+	}
+	return nil
+}
+
+// getAncElements copies the ancillary data elements of the last decoded frame.
+func (dec *Decoder) getAncElements() [][]byte {
+	var elements [][]byte
+	for i := 0; ; i++ {
+		var ptr *C.uchar
+		var size C.int
+		if C.aacDecoder_AncDataGet(dec.ph, C.int(i), &ptr, &size) != C.AAC_DEC_OK || ptr == nil {
+			break
+		}
+		elements = append(elements, C.GoBytes(unsafe.Pointer(ptr), size))
+	}
+	return elements
+}
+
 // ClearBuffer clears the decoder's internal buffer.
 // This is useful when seeking or switching between streams.
 func (dec *Decoder) ClearBuffer() error {
@@ -458,6 +734,11 @@ func (dec *Decoder) Close() {
 		dec.ph = nil
 		dec.remainData = nil
 		dec.info = nil
+		dec.pcmBuf = nil
+	}
+	if dec.ancBuf != nil {
+		C.free(unsafe.Pointer(dec.ancBuf))
+		dec.ancBuf = nil
 	}
 }
 
@@ -522,6 +803,18 @@ func getStreamInfo(ph C.HANDLE_AACDECODER) (*StreamInfo, error) {
 		DrcPresMode:         int8(originInfo.drcPresMode),
 	}
 
+	if si.NumChannels > 0 && originInfo.pChannelType != nil && originInfo.pChannelIndices != nil {
+		types := unsafe.Slice(originInfo.pChannelType, si.NumChannels)
+		indices := unsafe.Slice(originInfo.pChannelIndices, si.NumChannels)
+		si.Channels = make([]ChannelInfo, si.NumChannels)
+		for i := range si.Channels {
+			si.Channels[i] = ChannelInfo{
+				Type:  AudioChannelType(types[i]),
+				Index: int(indices[i]),
+			}
+		}
+	}
+
 	// fdk-aac only supports 16 bits (2 bytes) depth.
 	si.FrameBytes = si.FrameLength * si.NumChannels * SampleBitDepth / 8
 	return si, nil
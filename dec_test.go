@@ -1,6 +1,9 @@
 package fdkaac
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -162,6 +165,231 @@ func TestAacDecoder(t *testing.T) {
 			t.Errorf("expected decoded bytes 4096, got %d", n)
 		}
 	})
+
+	t.Run("Decoder decode with ancillary", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt:   TtMp4Adts,
+			AncDataBufSize: 1024,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		outBuf := make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))
+
+		n, anc, err := decoder.DecodeWithAncillary(AAC0, outBuf)
+		if err != nil {
+			t.Errorf("DecodeWithAncillary failed: %v", err)
+		}
+		if n != 4096 {
+			t.Errorf("expected decoded bytes 4096, got %d", n)
+		}
+		if len(anc) != 1 {
+			t.Fatalf("expected ancillary data for 1 frame, got %d", len(anc))
+		}
+		if anc[0].PcmOffset != 0 {
+			t.Errorf("expected PcmOffset 0, got %d", anc[0].PcmOffset)
+		}
+	})
+
+	t.Run("Decoder decode frame", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt: TtMp4Raw,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		err = decoder.ConfigRaw([]byte{0x12, 0x10})
+		if err != nil {
+			t.Errorf("ConfigRaw failed: %v", err)
+		}
+
+		outBuf := make([]byte, decoder.EstimateOutBufBytes(1))
+
+		n, info, err := decoder.DecodeFrame(AAC0[7:], outBuf, 0)
+		if err != nil {
+			t.Errorf("DecodeFrame failed: %v", err)
+		}
+		if n != 4096 {
+			t.Errorf("expected decoded bytes 4096, got %d", n)
+		}
+		if info == nil || info.SampleRate != 44100 {
+			t.Errorf("expected stream info with SampleRate 44100, got %+v", info)
+		}
+
+		// Conceal a lost access unit.
+		n, _, err = decoder.DecodeFrame(nil, outBuf, DecodeFlagConceal)
+		if err != nil {
+			t.Errorf("DecodeFrame conceal failed: %v", err)
+		}
+		if n != 4096 {
+			t.Errorf("expected concealed bytes 4096, got %d", n)
+		}
+
+		n, _, err = decoder.DecodeFrame(AAC1[7:], outBuf, DecodeFlagIntr)
+		if err != nil {
+			t.Errorf("DecodeFrame failed: %v", err)
+		}
+		if n != 4096 {
+			t.Errorf("expected decoded bytes 4096, got %d", n)
+		}
+	})
+
+	t.Run("Decoder decode frame drains remaining input", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt: TtMp4Adts,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		// More input than the decoder's buffer holds.
+		in := bytes.Repeat(append(append(append([]byte(nil), AAC0...), AAC1...), AAC2...), 1000)
+		outBuf := make([]byte, decoder.EstimateOutBufBytes(1))
+		frames := 0
+		for n, _, err := decoder.DecodeFrame(in, outBuf, 0); n > 0; n, _, err = decoder.DecodeFrame(nil, outBuf, 0) {
+			if err != nil {
+				t.Fatalf("DecodeFrame failed after %d frames: %v", frames, err)
+			}
+			frames++
+		}
+		if frames != 3000 {
+			t.Errorf("expected 3000 frames, got %d", frames)
+		}
+	})
+
+	t.Run("Decoder flush", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt: TtMp4Adts,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		outBuf := make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))
+
+		n, err := decoder.Flush(outBuf)
+		if err != nil || n != 0 {
+			t.Errorf("expected empty flush before decoding, got %d, %v", n, err)
+		}
+
+		if _, err = decoder.Decode(AAC0, outBuf); err != nil {
+			t.Errorf("Decode failed: %v", err)
+		}
+
+		info, _ := decoder.GetRawStreamInfo()
+		// Too small for the delayed samples, nothing is drained.
+		if n, err = decoder.Flush(outBuf[:info.FrameBytes]); err == nil || n != 0 {
+			t.Errorf("expected an error for a small buffer, got %d, %v", n, err)
+		}
+		n, err = decoder.Flush(outBuf)
+		if err != nil {
+			t.Errorf("Flush failed: %v", err)
+		}
+		if expected := info.OutputDelay * info.NumChannels * SampleBitDepth / 8; n != expected {
+			t.Errorf("expected %d flushed bytes, got %d", expected, n)
+		}
+	})
+
+	t.Run("Wav round trip with delayed samples", func(t *testing.T) {
+		pcm, err := os.ReadFile("samples/sample.pcm")
+		if err != nil {
+			t.Fatalf("open samples/sample.pcm failed: %v", err)
+		}
+		wavIn := append(GenerateWavHeader(len(pcm), 44100, 2, SampleBitDepth), pcm...)
+
+		// HE-AAC, as the SBR decoder delays its output.
+		var aac bytes.Buffer
+		_, totalFrames, _, err := EncodeFromWav(bytes.NewReader(wavIn), &aac, &EncoderConfig{
+			TransMux: TtMp4Adts,
+			AOT:      AotSbr,
+			Bitrate:  64000,
+		})
+		if err != nil {
+			t.Fatalf("EncodeFromWav failed: %v", err)
+		}
+
+		// The output frame length and delay of the stream.
+		decoder, err := NewDecoder(&DecoderConfig{TransportFmt: TtMp4Adts})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+		b := aac.Bytes()
+		frameLength := int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5
+		if _, err = decoder.Decode(b[:frameLength], make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		info, err := decoder.GetStreamInfo()
+		if err != nil {
+			t.Fatalf("GetStreamInfo failed: %v", err)
+		}
+		if info.OutputDelay == 0 {
+			t.Fatalf("expected an output delay for HE-AAC, got %+v", info)
+		}
+
+		wavOut, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+		if err != nil {
+			t.Fatalf("create file failed: %v", err)
+		}
+		defer wavOut.Close()
+		totalBytes, totalSamples, sampleRate, err := DecodeToWav(bytes.NewReader(b), wavOut, &DecoderConfig{
+			TransportFmt: TtMp4Adts,
+		})
+		if err != nil {
+			t.Fatalf("DecodeToWav failed: %v", err)
+		}
+
+		// Every access unit plus the delayed tail drained by Flush.
+		if expected := totalFrames*info.FrameLength + info.OutputDelay; totalSamples != expected {
+			t.Errorf("expected %d samples, got %d", expected, totalSamples)
+		}
+		if totalSamples-info.OutputDelay < len(pcm)/4 {
+			t.Errorf("expected at least the %d input samples, got %d", len(pcm)/4, totalSamples-info.OutputDelay)
+		}
+		if sampleRate != 44100 || totalBytes != totalSamples*4+WavHeaderSize {
+			t.Errorf("unexpected total bytes %d for %d samples at %d Hz", totalBytes, totalSamples, sampleRate)
+		}
+		if fi, _ := wavOut.Stat(); fi.Size() != int64(totalBytes) {
+			t.Errorf("expected a file of %d bytes, got %d", totalBytes, fi.Size())
+		}
+	})
+
+	t.Run("Decoder channel layout", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt: TtMp4Adts,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		outBuf := make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))
+		if _, err = decoder.Decode(AAC0, outBuf); err != nil {
+			t.Errorf("Decode failed: %v", err)
+		}
+
+		info, err := decoder.GetStreamInfo()
+		if err != nil {
+			t.Fatalf("GetStreamInfo failed: %v", err)
+		}
+		if len(info.Channels) != 2 {
+			t.Fatalf("expected 2 channels, got %d", len(info.Channels))
+		}
+		for i, ch := range info.Channels {
+			if ch.Type.Position() != ActFront || ch.Type.Height() != ActNone {
+				t.Errorf("channel %d: expected front speaker, got 0x%x", i, ch.Type)
+			}
+			if ch.Index != i {
+				t.Errorf("channel %d: expected index %d, got %d", i, i, ch.Index)
+			}
+		}
+	})
 }
 
 var AAC0 = []byte{
@@ -5,37 +5,66 @@ package fdkaac
 
 AACENC_ERROR aacEncEncodeWrapped(const HANDLE_AACENCODER hAacEncoder,
 		void* in, int inLen, int sampleBitDepth,
-		void* out, int outLen, int* numOutBytes) {
+		void* anc, int ancLen, AACENC_MetaData* meta,
+		void* out, int outLen, int* numOutBytes, int* numAncBytes,
+		int* auSizes, int auSizesLen) {
 	AACENC_ERROR err;
 	AACENC_BufDesc inBuf = { 0 }, outBuf = { 0 };
 	AACENC_InArgs inArgs = { 0 };
 	AACENC_OutArgs outArgs = { 0 };
-	int inIdentifier = IN_AUDIO_DATA;
+	void* inBufs[3];
+	int inIdentifiers[3], inSizes[3], inElemSizes[3];
 	int inElemSize = sampleBitDepth / 8;
-	int outIdentifier = OUT_BITSTREAM_DATA;
-	int outElemSize = 1;
+	void* outBufs[2] = { out, auSizes };
+	int outIdentifiers[2] = { OUT_BITSTREAM_DATA, OUT_AU_SIZES };
+	int outSizes[2] = { outLen, auSizesLen * (int)sizeof(int) };
+	int outElemSizes[2] = { 1, sizeof(int) };
+	int n = 0;
 
 	inArgs.numInSamples = in ? inLen / inElemSize : -1;
-	inBuf.numBufs = 1;
-	inBuf.bufs = &in;
-	inBuf.bufferIdentifiers = &inIdentifier;
-	inBuf.bufSizes = &inLen;
-	inBuf.bufElSizes = &inElemSize;
-	outBuf.numBufs = 1;
-	outBuf.bufs = &out;
-	outBuf.bufferIdentifiers = &outIdentifier;
-	outBuf.bufSizes = &outLen;
-	outBuf.bufElSizes = &outElemSize;
+	inBufs[n] = in;
+	inIdentifiers[n] = IN_AUDIO_DATA;
+	inSizes[n] = inLen;
+	inElemSizes[n] = inElemSize;
+	n++;
+	if (anc && ancLen > 0) {
+		inArgs.numAncBytes = ancLen;
+		inBufs[n] = anc;
+		inIdentifiers[n] = IN_ANCILLRY_DATA;
+		inSizes[n] = ancLen;
+		inElemSizes[n] = 1;
+		n++;
+	}
+	if (meta) {
+		inBufs[n] = meta;
+		inIdentifiers[n] = IN_METADATA_SETUP;
+		inSizes[n] = sizeof(AACENC_MetaData);
+		inElemSizes[n] = sizeof(AACENC_MetaData);
+		n++;
+	}
+	inBuf.numBufs = n;
+	inBuf.bufs = inBufs;
+	inBuf.bufferIdentifiers = inIdentifiers;
+	inBuf.bufSizes = inSizes;
+	inBuf.bufElSizes = inElemSizes;
+	outBuf.numBufs = auSizes ? 2 : 1;
+	outBuf.bufs = outBufs;
+	outBuf.bufferIdentifiers = outIdentifiers;
+	outBuf.bufSizes = outSizes;
+	outBuf.bufElSizes = outElemSizes;
 
 	err = aacEncEncode(hAacEncoder, &inBuf, &outBuf, &inArgs, &outArgs);
 	*numOutBytes = outArgs.numOutBytes;
+	*numAncBytes = outArgs.numAncBytes;
 	return err;
 }
 */
 import "C"
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"unsafe"
 )
 
@@ -78,6 +107,21 @@ const (
 	BitrateModeVeryHigh
 )
 
+// Encoder reset mode, may be combined.
+type ResetMode int
+
+const (
+	// Reset all encoder modules history buffer.
+	ResetStates ResetMode = C.AACENC_INIT_STATES
+	// Reset fill level of internal input buffer.
+	ResetInBuffer ResetMode = C.AACENC_RESET_INBUFFER
+	// Initialize all.
+	ResetAll ResetMode = C.AACENC_INIT_ALL
+)
+
+// Maximum number of access unit sizes reported per encoder call.
+const maxAuSizes = 16
+
 // Signaling Mode
 type SignalingMode int
 
@@ -97,6 +141,67 @@ const (
 	MetaDataModeNoneAncillaryDataOnly
 )
 
+// Meta Data Compression Profile
+type MetaDataDrcProfile int
+
+const (
+	MetaDataDrcNone          MetaDataDrcProfile = 0
+	MetaDataDrcFilmStandard  MetaDataDrcProfile = 1
+	MetaDataDrcFilmLight     MetaDataDrcProfile = 2
+	MetaDataDrcMusicStandard MetaDataDrcProfile = 3
+	MetaDataDrcMusicLight    MetaDataDrcProfile = 4
+	MetaDataDrcSpeech        MetaDataDrcProfile = 5
+	// Disable writing gain factor (used for CompProfile only).
+	MetaDataDrcNotPresent MetaDataDrcProfile = 256
+)
+
+// EncoderMetaData is the meta data setup embedded into the bitstream
+// when MetaDataMode is not MetaDataModeNone. Levels are given in dB.
+type EncoderMetaData struct {
+	// MPEG DRC compression profile.
+	DrcProfile MetaDataDrcProfile
+	// ETSI heavy compression profile.
+	CompProfile MetaDataDrcProfile
+	// Expected target level of the DRC compressor in dB.
+	DrcTargetRefLevel float64
+	// Adjust limiter to avoid overload, in dB.
+	CompTargetRefLevel float64
+	// Write the programme reference level.
+	ProgRefLevelPresent bool
+	// Programme reference level (dialogue level), -31.75 dB .. 0 dB in 0.25 dB steps.
+	ProgRefLevel float64
+	// Write the downmix index into the program config element.
+	PceMixdownIdxPresent bool
+	// Write the downmix levels into the ETSI ancillary data.
+	EtsiDmxLvlPresent bool
+	// Center downmix level index (0...7).
+	CenterMixLevel int
+	// Surround downmix level index (0...7).
+	SurroundMixLevel int
+	// Dolby surround mode (0: not indicated, 1: not encoded, 2: encoded).
+	DolbySurroundMode int
+	// DRC presentation mode (0: not indicated, 1: mode 1, 2: mode 2).
+	DrcPresentationMode int
+	// Insert MPEG4_ext_ancillary_data().
+	ExtAncDataEnable bool
+	// Insert ext_downmixing_levels().
+	ExtDownmixLevelEnable bool
+	// Downmix level index A (0...7).
+	ExtDownmixLevelA int
+	// Downmix level index B (0...7).
+	ExtDownmixLevelB int
+	// Insert ext_downmixing_global_gains().
+	DmxGainEnable bool
+	// Gain factor for downmix to 5 channels, -15.75 dB .. 15.75 dB in 0.25 dB steps.
+	DmxGain5 float64
+	// Gain factor for downmix to 2 channels, -15.75 dB .. 15.75 dB in 0.25 dB steps.
+	DmxGain2 float64
+	// Insert ext_downmixing_lfe_level().
+	LfeDmxEnable bool
+	// Downmix level index for LFE (0...15).
+	LfeDmxLevel int
+}
+
 // AAC Encoder Config
 type EncoderConfig struct {
 	// Number of channels to be allocated.
@@ -141,6 +246,10 @@ type EncoderConfig struct {
 	AncillaryBitrate int
 	// Configure Meta Data.
 	MetaDataMode MetaDataMode
+	// Initial meta data setup, used when MetaDataMode is not MetaDataModeNone.
+	MetaData *EncoderMetaData
+	// Add TPDF dither when EncodeFloat32 or EncodeInt32 quantize to 16 bits.
+	InputDither bool
 }
 
 // EncInfo provides some info about the encoder configuration.
@@ -172,7 +281,207 @@ type EncInfo struct {
 type Encoder struct {
 	ph C.HANDLE_AACENCODER
 	EncInfo
-	frameData []byte
+	frameData    []byte
+	metaDataMode MetaDataMode
+	metaData     *EncoderMetaData
+	ancData      []byte
+	// Ancillary bytes consumed per frame during EncodeWithAncillary.
+	ancCounts []int
+	// Samples per channel emitted so far, including the encoder delay.
+	outSamples int64
+	packets    []Packet
+	collecting bool
+	pktBuf     []byte
+	// Conversion buffer and dither state for float32/int32 input.
+	pcmBuf      []byte
+	dither      bool
+	ditherState uint64
+}
+
+// Packet is one encoded access unit, or transport frame when several
+// sub frames are packed together.
+type Packet struct {
+	// Encoded bytes.
+	Data []byte
+	// Presentation timestamp in samples per channel at the input sample rate.
+	// It is shifted by EncInfo.NDelay, so packets that only carry the encoder
+	// priming samples have a negative timestamp.
+	PTS int64
+	// Duration in samples per channel.
+	Duration int
+}
+
+// SetMetaData updates the meta data setup. The new setup is passed to the
+// library with the next encoded frame and stays in effect until it is replaced.
+func (enc *Encoder) SetMetaData(md *EncoderMetaData) error {
+	if enc.metaDataMode == MetaDataModeNone {
+		return errors.New("metadata mode is not enabled")
+	}
+	if md == nil {
+		return errors.New("metadata should not be nil")
+	}
+	m := *md
+	enc.metaData = &m
+	return nil
+}
+
+// EncodeWithAncillary works like Encode and additionally embeds anc as ancillary
+// data into the frames encoded by this call. The library takes as many bytes as
+// the configuration allows, either all at once or spread over the frames according
+// to AncillaryBitrate. The bytes a frame does not take are offered to the next
+// frame of the call; bytes not consumed by the end of the call are not kept, the
+// caller passes them again with the next call. To control the payload of every
+// frame, pass exactly FrameBytes of PCM per call.
+// In addition to the Encode results, nAnc holds the number of ancillary bytes
+// consumed by each of the nFrames frames.
+func (enc *Encoder) EncodeWithAncillary(in, anc, out []byte) (n int, nFrames int, nAnc []int, err error) {
+	if len(anc) > enc.MaxAncBytes {
+		return 0, 0, nil, fmt.Errorf("ancillary data is too large: %d bytes (max %d)", len(anc), enc.MaxAncBytes)
+	}
+
+	enc.ancData = anc
+	enc.ancCounts = make([]int, 0, len(in)/max(enc.FrameBytes, 1)+1)
+	defer func() {
+		enc.ancData = nil
+		enc.ancCounts = nil
+	}()
+
+	n, nFrames, err = enc.Encode(in, out)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return n, nFrames, enc.ancCounts, nil
+}
+
+// encodeFrame calls the library once, attaching any pending ancillary data and meta data setup.
+func (enc *Encoder) encodeFrame(inPtr unsafe.Pointer, inLen int, outPtr unsafe.Pointer, outLen int, nWrite *C.int) C.AACENC_ERROR {
+	var meta *C.AACENC_MetaData
+	if enc.metaData != nil {
+		meta = &C.AACENC_MetaData{}
+		enc.metaData.toC(meta)
+	}
+
+	var ancPtr unsafe.Pointer
+	ancLen := len(enc.ancData)
+	if ancLen > 0 && inPtr != nil {
+		ancPtr = unsafe.Pointer(&enc.ancData[0])
+	}
+
+	var nAnc C.int
+	var auSizes [maxAuSizes]C.int
+	errNo := C.aacEncEncodeWrapped(enc.ph,
+		inPtr, C.int(inLen), C.int(SampleBitDepth),
+		ancPtr, C.int(ancLen), meta,
+		outPtr, C.int(outLen), nWrite, &nAnc,
+		&auSizes[0], maxAuSizes)
+	if errNo == C.AACENC_OK {
+		enc.metaData = nil
+		if ancPtr != nil && nAnc > 0 {
+			enc.ancData = enc.ancData[int(nAnc):]
+		}
+		if enc.ancCounts != nil && inPtr != nil {
+			enc.ancCounts = append(enc.ancCounts, int(nAnc))
+		}
+		if *nWrite > 0 {
+			nAu := 0
+			for _, sz := range auSizes {
+				if sz > 0 {
+					nAu++
+				}
+			}
+			if nAu == 0 {
+				nAu = 1
+			}
+			duration := nAu * enc.FrameLength
+			if enc.collecting {
+				enc.packets = append(enc.packets, Packet{
+					Data:     C.GoBytes(outPtr, *nWrite),
+					PTS:      enc.outSamples - int64(enc.NDelay),
+					Duration: duration,
+				})
+			}
+			enc.outSamples += int64(duration)
+		}
+	}
+	return errNo
+}
+
+// EncodePackets works like Encode but returns every encoded access unit as
+// a separate Packet with its own duration and presentation timestamp.
+func (enc *Encoder) EncodePackets(in []byte) ([]Packet, error) {
+	if len(in) == 0 {
+		return nil, errors.New("input buffer is empty")
+	}
+	out := enc.packetBuffer(len(in))
+
+	enc.collecting = true
+	defer enc.stopCollecting()
+	if _, _, err := enc.Encode(in, out); err != nil {
+		return nil, err
+	}
+	return enc.packets, nil
+}
+
+// FlushPackets works like Flush but returns the remaining access units as Packets.
+func (enc *Encoder) FlushPackets() ([]Packet, error) {
+	out := enc.packetBuffer(0)
+
+	enc.collecting = true
+	defer enc.stopCollecting()
+	if _, _, err := enc.Flush(out); err != nil {
+		return nil, err
+	}
+	return enc.packets, nil
+}
+
+// packetBuffer returns a reusable scratch output buffer for inBytes of input.
+func (enc *Encoder) packetBuffer(inBytes int) []byte {
+	sz := enc.EstimateOutBufBytes(inBytes)
+	if cap(enc.pktBuf) < sz {
+		enc.pktBuf = make([]byte, sz)
+	}
+	return enc.pktBuf[:sz]
+}
+
+func (enc *Encoder) stopCollecting() {
+	enc.collecting = false
+	enc.packets = nil
+}
+
+func (md *EncoderMetaData) toC(m *C.AACENC_MetaData) {
+	m.drc_profile = C.AACENC_METADATA_DRC_PROFILE(md.DrcProfile)
+	m.comp_profile = C.AACENC_METADATA_DRC_PROFILE(md.CompProfile)
+	m.drc_TargetRefLevel = dbToQ16(md.DrcTargetRefLevel)
+	m.comp_TargetRefLevel = dbToQ16(md.CompTargetRefLevel)
+	m.prog_ref_level_present = C.INT(boolToInt(md.ProgRefLevelPresent))
+	m.prog_ref_level = dbToQ16(md.ProgRefLevel)
+	m.PCE_mixdown_idx_present = C.UCHAR(boolToInt(md.PceMixdownIdxPresent))
+	m.ETSI_DmxLvl_present = C.UCHAR(boolToInt(md.EtsiDmxLvlPresent))
+	m.centerMixLevel = C.SCHAR(md.CenterMixLevel)
+	m.surroundMixLevel = C.SCHAR(md.SurroundMixLevel)
+	m.dolbySurroundMode = C.UCHAR(md.DolbySurroundMode)
+	m.drcPresentationMode = C.UCHAR(md.DrcPresentationMode)
+	m.ExtMetaData.extAncDataEnable = C.UCHAR(boolToInt(md.ExtAncDataEnable))
+	m.ExtMetaData.extDownmixLevelEnable = C.UCHAR(boolToInt(md.ExtDownmixLevelEnable))
+	m.ExtMetaData.extDownmixLevel_A = C.UCHAR(md.ExtDownmixLevelA)
+	m.ExtMetaData.extDownmixLevel_B = C.UCHAR(md.ExtDownmixLevelB)
+	m.ExtMetaData.dmxGainEnable = C.UCHAR(boolToInt(md.DmxGainEnable))
+	m.ExtMetaData.dmxGain5 = dbToQ16(md.DmxGain5)
+	m.ExtMetaData.dmxGain2 = dbToQ16(md.DmxGain2)
+	m.ExtMetaData.lfeDmxEnable = C.UCHAR(boolToInt(md.LfeDmxEnable))
+	m.ExtMetaData.lfeDmxLevel = C.UCHAR(md.LfeDmxLevel)
+}
+
+// dbToQ16 converts a level in dB to the library's 16 bit scaled representation.
+func dbToQ16(db float64) C.INT {
+	return C.INT(math.Round(db * 65536))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // Encode encodes PCM audio data to AAC format.
@@ -201,9 +510,7 @@ func (enc *Encoder) Encode(in, out []byte) (n int, nFrames int, err error) {
 
 			inPtr = unsafe.Pointer(&enc.frameData[0])
 			outPtr = unsafe.Pointer(&out[0])
-			errNo := C.aacEncEncodeWrapped(enc.ph,
-				inPtr, C.int(frameSize), C.int(SampleBitDepth),
-				outPtr, C.int(szOut), &nWrite)
+			errNo := enc.encodeFrame(inPtr, frameSize, outPtr, szOut, &nWrite)
 			if errNo != 0 {
 				enc.frameData = enc.frameData[:currentLen]
 				return 0, 0, getEncError(errNo)
@@ -230,9 +537,7 @@ func (enc *Encoder) Encode(in, out []byte) (n int, nFrames int, err error) {
 	for szIn >= frameSize {
 		inPtr = unsafe.Pointer(&in[0])
 		outPtr = unsafe.Pointer(&out[0])
-		errNo := C.aacEncEncodeWrapped(enc.ph,
-			inPtr, C.int(frameSize), C.int(SampleBitDepth),
-			outPtr, C.int(szOut), &nWrite)
+		errNo := enc.encodeFrame(inPtr, frameSize, outPtr, szOut, &nWrite)
 		if errNo != 0 {
 			return 0, 0, getEncError(errNo)
 		}
@@ -265,9 +570,7 @@ func (enc *Encoder) Flush(out []byte) (n int, nFrames int, err error) {
 	if len(enc.frameData) > 0 {
 		inPtr := unsafe.Pointer(&enc.frameData[0])
 		outPtr = unsafe.Pointer(&out[0])
-		errNo := C.aacEncEncodeWrapped(enc.ph,
-			inPtr, C.int(len(enc.frameData)), C.int(SampleBitDepth),
-			outPtr, C.int(szOut), &nWrite)
+		errNo := enc.encodeFrame(inPtr, len(enc.frameData), outPtr, szOut, &nWrite)
 		if errNo != 0 {
 			return 0, 0, getEncError(errNo)
 		}
@@ -283,9 +586,7 @@ func (enc *Encoder) Flush(out []byte) (n int, nFrames int, err error) {
 
 	for {
 		outPtr = unsafe.Pointer(&out[0])
-		errNo := C.aacEncEncodeWrapped(enc.ph,
-			nil, 0, C.int(SampleBitDepth),
-			outPtr, C.int(szOut), &nWrite)
+		errNo := enc.encodeFrame(nil, 0, outPtr, szOut, &nWrite)
 		if errNo != 0 {
 			if errNo == C.AACENC_ENCODE_EOF {
 				return n, nFrames, nil
@@ -304,12 +605,104 @@ func (enc *Encoder) Flush(out []byte) (n int, nFrames int, err error) {
 	}
 }
 
+// SetBitrate changes the total encoder bitrate of the open encoder.
+// Reports whether the change produced a new AudioSpecificConfig in ConfBuf.
+func (enc *Encoder) SetBitrate(bitrate int) (confChanged bool, err error) {
+	if bitrate <= 0 {
+		return false, fmt.Errorf("invalid Bitrate: %d", bitrate)
+	}
+	return enc.reconfigure(C.AACENC_BITRATE, C.uint(bitrate))
+}
+
+// SetBitrateMode changes the bitrate mode of the open encoder.
+// Reports whether the change produced a new AudioSpecificConfig in ConfBuf.
+func (enc *Encoder) SetBitrateMode(mode BitrateMode) (confChanged bool, err error) {
+	if mode < BitrateModeConstant || mode > BitrateModeVeryHigh {
+		return false, fmt.Errorf("invalid BitrateMode: %d", mode)
+	}
+	return enc.reconfigure(C.AACENC_BITRATEMODE, C.uint(mode))
+}
+
+// SetBandwidth changes the core encoder audio bandwidth of the open encoder.
+// A bandwidth of 0 lets the library choose.
+// Reports whether the change produced a new AudioSpecificConfig in ConfBuf.
+func (enc *Encoder) SetBandwidth(bandwidth int) (confChanged bool, err error) {
+	if bandwidth < 0 {
+		return false, fmt.Errorf("invalid Bandwidth: %d", bandwidth)
+	}
+	return enc.reconfigure(C.AACENC_BANDWIDTH, C.uint(bandwidth))
+}
+
+// SetPeakBitrate changes the peak bitrate of the open encoder.
+// Reports whether the change produced a new AudioSpecificConfig in ConfBuf.
+func (enc *Encoder) SetPeakBitrate(peakBitrate int) (confChanged bool, err error) {
+	if peakBitrate < 0 {
+		return false, fmt.Errorf("invalid PeakBitrate: %d", peakBitrate)
+	}
+	return enc.reconfigure(C.AACENC_PEAK_BITRATE, C.uint(peakBitrate))
+}
+
+// reconfigure sets one parameter on the open handle and lets the library
+// reinitialize right away, instead of on the next Encode call. EncInfo, and with
+// it FrameBytes, NDelay and the EstimateOutBufBytes result, is re-queried
+// afterwards so that it describes the new configuration.
+func (enc *Encoder) reconfigure(param C.AACENC_PARAM, value C.uint) (bool, error) {
+	if enc.ph == nil {
+		return false, getEncError(C.AACENC_INVALID_HANDLE)
+	}
+	if errNo := C.aacEncoder_SetParam(enc.ph, param, value); errNo != C.AACENC_OK {
+		return false, getEncError(errNo)
+	}
+	// A call without buffers applies the initialization requested in
+	// AACENC_CONTROL_STATE, if any.
+	if errNo := C.aacEncEncode(enc.ph, nil, nil, nil, nil); errNo != C.AACENC_OK {
+		return false, getEncError(errNo)
+	}
+
+	oldConf := enc.ConfBuf
+	if errNo := enc.getInfo(); errNo != C.AACENC_OK {
+		return false, getEncError(errNo)
+	}
+	return !bytes.Equal(oldConf, enc.ConfBuf), nil
+}
+
+// Reset reinitializes the open encoder according to mode, e.g. before encoding
+// from a new position. Buffered PCM that has not been encoded yet and meta data
+// not yet sent by SetMetaData are discarded, and packet timestamps restart at zero.
+func (enc *Encoder) Reset(mode ResetMode) error {
+	if enc.ph == nil {
+		return getEncError(C.AACENC_INVALID_HANDLE)
+	}
+	if mode&ResetAll == 0 || mode&^ResetAll != 0 {
+		return fmt.Errorf("invalid ResetMode: 0x%x", int(mode))
+	}
+	if errNo := C.aacEncoder_SetParam(enc.ph, C.AACENC_CONTROL_STATE,
+		C.uint(mode)); errNo != C.AACENC_OK {
+		return getEncError(errNo)
+	}
+	if errNo := C.aacEncEncode(enc.ph, nil, nil, nil, nil); errNo != C.AACENC_OK {
+		return getEncError(errNo)
+	}
+	if errNo := enc.getInfo(); errNo != C.AACENC_OK {
+		return getEncError(errNo)
+	}
+
+	enc.frameData = enc.frameData[:0]
+	enc.metaData = nil
+	enc.ancData = nil
+	enc.outSamples = 0
+	return nil
+}
+
 // Close releases all resources associated with the encoder.
 func (enc *Encoder) Close() {
 	if enc.ph != nil {
 		C.aacEncClose(&enc.ph)
 		enc.ph = nil
 		enc.frameData = nil
+		enc.metaData = nil
+		enc.pktBuf = nil
+		enc.pcmBuf = nil
 	}
 }
 
@@ -333,8 +726,8 @@ func NewEncoder(config *EncoderConfig) (enc *Encoder, err error) {
 	if config.SampleRate <= 0 {
 		return nil, fmt.Errorf("invalid SampleRate: %d", config.SampleRate)
 	}
-	if config.MetaDataMode != MetaDataModeNone {
-		return nil, errors.New("metadata mode is not supported yet")
+	if config.MetaDataMode < MetaDataModeNone || config.MetaDataMode > MetaDataModeNoneAncillaryDataOnly {
+		return nil, fmt.Errorf("invalid MetaDataMode: %d", config.MetaDataMode)
 	}
 
 	enc = &Encoder{}
@@ -456,6 +849,13 @@ func NewEncoder(config *EncoderConfig) (enc *Encoder, err error) {
 		}
 	}
 
+	if config.MetaDataMode != MetaDataModeNone {
+		if errNo = C.aacEncoder_SetParam(enc.ph, C.AACENC_METADATA_MODE,
+			C.uint(config.MetaDataMode)); errNo != C.AACENC_OK {
+			return nil, getEncError(errNo)
+		}
+	}
+
 	if errNo = C.aacEncEncode(enc.ph, nil, nil, nil, nil); errNo != C.AACENC_OK {
 		return nil, getEncError(errNo)
 	}
@@ -465,6 +865,13 @@ func NewEncoder(config *EncoderConfig) (enc *Encoder, err error) {
 	}
 
 	enc.frameData = make([]byte, 0, enc.FrameBytes)
+	enc.metaDataMode = config.MetaDataMode
+	enc.dither = config.InputDither
+	enc.ditherState = 0x9E3779B97F4A7C15
+	if config.MetaDataMode != MetaDataModeNone && config.MetaData != nil {
+		md := *config.MetaData
+		enc.metaData = &md
+	}
 	return enc, nil
 }
 
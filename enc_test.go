@@ -1,6 +1,7 @@
 package fdkaac
 
 import (
+	"bytes"
 	"os"
 	"sync"
 	"testing"
@@ -70,6 +71,267 @@ func TestAacEncoder(t *testing.T) {
 			t.Errorf("expected flush bytes 538, got %d", n)
 		}
 	})
+
+	t.Run("Encode with metadata", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:     TtMp4Adts,
+			SampleRate:   44100,
+			MaxChannels:  2,
+			Bitrate:      64000,
+			MetaDataMode: MetaDataModeDynamicRangeInfoAndAncillaryData,
+			MetaData: &EncoderMetaData{
+				DrcProfile:          MetaDataDrcFilmStandard,
+				CompProfile:         MetaDataDrcFilmStandard,
+				ProgRefLevelPresent: true,
+				ProgRefLevel:        -23,
+				DrcPresentationMode: 1,
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		decoder, err := NewDecoder(&DecoderConfig{TransportFmt: TtMp4Adts})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		output := make([]byte, 8192)
+		pcm := make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))
+		decode := func(n int) {
+			if n == 0 {
+				return
+			}
+			if _, err := decoder.Decode(output[:n], pcm); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+		}
+		// The meta data is delayed with the audio, check the frames decoded
+		// last. prog_ref_level is given in steps of 0.25 dB.
+		check := func(progRefLev, presMode int8) {
+			info, err := decoder.GetStreamInfo()
+			if err != nil {
+				t.Fatalf("GetStreamInfo failed: %v", err)
+			}
+			if info.DrcProgRefLev != progRefLev || info.DrcPresMode != presMode {
+				t.Errorf("expected prog_ref_level %d and presentation mode %d, got %d and %d",
+					progRefLev, presMode, info.DrcProgRefLev, info.DrcPresMode)
+			}
+		}
+
+		for i := 0; i < 10; i++ {
+			n, _, err := encoder.Encode(PCM0, output)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decode(n)
+		}
+		check(92, 1)
+
+		err = encoder.SetMetaData(&EncoderMetaData{
+			DrcProfile:          MetaDataDrcSpeech,
+			CompProfile:         MetaDataDrcNotPresent,
+			ProgRefLevelPresent: true,
+			ProgRefLevel:        -31,
+			DrcPresentationMode: 2,
+		})
+		if err != nil {
+			t.Errorf("SetMetaData failed: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			n, _, err := encoder.Encode(PCM0, output)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decode(n)
+		}
+		n, _, err := encoder.Flush(output)
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		decode(n)
+		check(124, 2)
+	})
+
+	t.Run("Encode with ancillary data", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		output := make([]byte, 8192)
+		_, _, _, err = encoder.EncodeWithAncillary(PCM0, make([]byte, encoder.MaxAncBytes+1), output)
+		if err == nil {
+			t.Error("expected error for oversized ancillary data")
+		}
+
+		anc := []byte("title:sample")
+		_, nFrames, nAnc, err := encoder.EncodeWithAncillary(PCM0, anc, output)
+		if err != nil {
+			t.Errorf("EncodeWithAncillary failed: %v", err)
+		}
+		if nFrames != 1 {
+			t.Errorf("expected 1 frame, got %d", nFrames)
+		}
+		if len(nAnc) != nFrames {
+			t.Fatalf("expected ancillary counts for %d frames, got %v", nFrames, nAnc)
+		}
+		if nAnc[0] < 0 || nAnc[0] > len(anc) {
+			t.Errorf("expected at most %d ancillary bytes, got %d", len(anc), nAnc[0])
+		}
+	})
+
+	t.Run("Encode packets", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Raw,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		packets, err := encoder.EncodePackets(PCM0)
+		if err != nil {
+			t.Fatalf("EncodePackets failed: %v", err)
+		}
+		flushed, err := encoder.FlushPackets()
+		if err != nil {
+			t.Fatalf("FlushPackets failed: %v", err)
+		}
+		packets = append(packets, flushed...)
+		if len(packets) == 0 {
+			t.Fatal("expected packets")
+		}
+
+		pts := -int64(encoder.NDelay)
+		for i, pkt := range packets {
+			if len(pkt.Data) == 0 {
+				t.Errorf("packet %d is empty", i)
+			}
+			if pkt.PTS != pts {
+				t.Errorf("packet %d: expected PTS %d, got %d", i, pts, pkt.PTS)
+			}
+			if pkt.Duration != encoder.FrameLength {
+				t.Errorf("packet %d: expected duration %d, got %d", i, encoder.FrameLength, pkt.Duration)
+			}
+			pts += int64(pkt.Duration)
+		}
+	})
+
+	t.Run("Encoder reconfigure", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     128000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		output := make([]byte, 8192)
+		if _, _, err = encoder.Encode(PCM0, output); err != nil {
+			t.Errorf("Encode failed: %v", err)
+		}
+
+		confChanged, err := encoder.SetBitrate(64000)
+		if err != nil {
+			t.Errorf("SetBitrate failed: %v", err)
+		}
+		if confChanged {
+			t.Error("expected AudioSpecificConfig to be unchanged for AAC-LC")
+		}
+		if _, err = encoder.SetBandwidth(15000); err != nil {
+			t.Errorf("SetBandwidth failed: %v", err)
+		}
+		if _, err = encoder.SetPeakBitrate(96000); err != nil {
+			t.Errorf("SetPeakBitrate failed: %v", err)
+		}
+		if _, err = encoder.SetBitrate(-1); err == nil {
+			t.Error("expected error for negative bitrate")
+		}
+		if encoder.FrameLength != 1024 {
+			t.Errorf("expected FrameLength 1024, got %d", encoder.FrameLength)
+		}
+
+		if _, _, err = encoder.Encode(PCM0, output); err != nil {
+			t.Errorf("Encode failed: %v", err)
+		}
+
+		// EncInfo matches that of an encoder opened with the new parameters.
+		fresh, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+			Bandwidth:   15000,
+			PeakBitrate: 96000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer fresh.Close()
+		if encoder.FrameBytes != fresh.FrameBytes || encoder.NDelay != fresh.NDelay ||
+			encoder.MaxOutBufBytes != fresh.MaxOutBufBytes || !bytes.Equal(encoder.ConfBuf, fresh.ConfBuf) {
+			t.Errorf("expected EncInfo %+v after reconfigure, got %+v", fresh.EncInfo, encoder.EncInfo)
+		}
+		if n, m := encoder.EstimateOutBufBytes(len(PCM0)), fresh.EstimateOutBufBytes(len(PCM0)); n != m {
+			t.Errorf("expected output estimate %d, got %d", m, n)
+		}
+	})
+
+	t.Run("Encoder reset", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		output := make([]byte, 8192)
+		if _, _, err = encoder.Encode(PCM0[:1000], output); err != nil {
+			t.Errorf("Encode failed: %v", err)
+		}
+
+		// Meta data waiting for the next frame.
+		encoder.metaData = &EncoderMetaData{}
+		if err = encoder.Reset(ResetStates | ResetInBuffer); err != nil {
+			t.Errorf("Reset failed: %v", err)
+		}
+		if len(encoder.frameData) != 0 {
+			t.Errorf("expected empty frameData after reset, got %d bytes", len(encoder.frameData))
+		}
+		if encoder.metaData != nil {
+			t.Error("expected pending meta data to be cleared by reset")
+		}
+		if err = encoder.Reset(0); err == nil {
+			t.Error("expected error for empty reset mode")
+		}
+
+		n, _, err := encoder.Encode(PCM0, output)
+		if err != nil {
+			t.Errorf("Encode failed: %v", err)
+		}
+		if n == 0 {
+			t.Error("expected encoded bytes after reset")
+		}
+	})
 }
 
 func TestAacEncoderAdvance(t *testing.T) {
@@ -9,47 +9,26 @@ import (
 )
 
 func main() {
-	decoder, err := fdkaac.NewDecoder(&fdkaac.DecoderConfig{
-		TransportFmt: fdkaac.TtMp4Adts,
-	})
+	aacFile, err := os.Open("samples/sample.aac")
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	defer decoder.Close()
+	defer aacFile.Close()
 
-	aacFile, err := os.Open("samples/sample.aac")
+	reader, err := fdkaac.NewDecodeReader(aacFile, &fdkaac.DecoderConfig{
+		TransportFmt: fdkaac.TtMp4Adts,
+	})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	defer aacFile.Close()
-
-	pcmBuf := make([]byte, decoder.EstimateOutBufBytes(fdkaac.EstimateFrames))
-	totalBytes := 0
-	chunk := make([]byte, 2048)
-
-	for {
-		n, readErr := aacFile.Read(chunk)
-		if n > 0 {
-			decodedN, decErr := decoder.Decode(chunk[:n], pcmBuf)
-			if decErr != nil {
-				fmt.Println(decErr)
-				return
-			}
-
-			if decodedN > 0 {
-				totalBytes += decodedN
-			}
-		}
+	defer reader.Close()
 
-		if readErr != nil {
-			if readErr == io.EOF {
-				break
-			}
-			fmt.Println(readErr)
-			return
-		}
+	totalBytes, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 
 	fmt.Printf("Decoded %d bytes of PCM data\n", totalBytes)
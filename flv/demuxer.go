@@ -0,0 +1,120 @@
+package flv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Packet is an AAC audio tag.
+type Packet struct {
+	// PacketSequenceHeader or PacketRaw.
+	Type PacketType
+	// Timestamp in milliseconds.
+	Timestamp uint32
+	// The AudioSpecificConfig of a sequence header, or a raw access unit.
+	Data []byte
+}
+
+// Demuxer reads the AAC audio tags of an FLV file. Video, script data and
+// other audio tags are skipped.
+type Demuxer struct {
+	r      io.Reader
+	header [TagHeaderSize]byte
+	data   []byte
+	config []byte
+}
+
+// NewDemuxer returns a Demuxer reading from r. It reads the file header.
+func NewDemuxer(r io.Reader) (*Demuxer, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if header[0] != 'F' || header[1] != 'L' || header[2] != 'V' {
+		return nil, fmt.Errorf("%w: signature not found", ErrInvalid)
+	}
+	offset := int64(header[5])<<24 | int64(header[6])<<16 | int64(header[7])<<8 | int64(header[8])
+	if offset < HeaderSize {
+		return nil, fmt.Errorf("%w: data offset %d", ErrInvalid, offset)
+	}
+	// Skip the rest of the header and PreviousTagSize0.
+	if _, err := io.CopyN(io.Discard, r, offset-HeaderSize+4); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return &Demuxer{r: r}, nil
+}
+
+// ReadPacket returns the next AAC audio tag, or io.EOF at the end of the file.
+func (d *Demuxer) ReadPacket() (*Packet, error) {
+	for {
+		if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: truncated tag header", ErrInvalid)
+			}
+			return nil, err
+		}
+		h := d.header[:]
+		tagType := h[0] & 0x1F
+		filtered := h[0]&0x20 != 0
+		n := int(h[1])<<16 | int(h[2])<<8 | int(h[3])
+		timestamp := uint32(h[7])<<24 | uint32(h[4])<<16 | uint32(h[5])<<8 | uint32(h[6])
+
+		if cap(d.data) < n+4 {
+			d.data = make([]byte, n+4)
+		}
+		data := d.data[:n+4]
+		if read, err := io.ReadFull(d.r, data); err != nil {
+			// The last PreviousTagSize may be missing.
+			if read < n || err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: truncated tag", ErrInvalid)
+			}
+		}
+		data = data[:n]
+
+		if tagType != TagAudio || filtered {
+			continue
+		}
+		packetType, payload, err := ParseAudioData(data)
+		if errors.Is(err, ErrUnsupported) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &Packet{
+			Type:      packetType,
+			Timestamp: timestamp,
+			Data:      append([]byte(nil), payload...),
+		}, nil
+	}
+}
+
+// Config returns the AudioSpecificConfig of the last sequence header read by
+// ReadAU, or nil before the first one.
+func (d *Demuxer) Config() []byte {
+	return d.config
+}
+
+// Delay returns 0, FLV does not signal the encoder delay.
+func (d *Demuxer) Delay() time.Duration {
+	return 0
+}
+
+// ReadAU returns the next raw access unit, or io.EOF at the end of the file.
+// Sequence headers update Config, raw frames before the first one are
+// skipped. With Config and Delay, it lets fdkaac.NewDemuxDecodeReader decode
+// the audio tags, configuring the decoder from the sequence headers.
+func (d *Demuxer) ReadAU() ([]byte, int, error) {
+	for {
+		pkt, err := d.ReadPacket()
+		if err != nil {
+			return nil, 0, err
+		}
+		if pkt.Type == PacketSequenceHeader {
+			d.config = pkt.Data
+		} else if d.config != nil {
+			return pkt.Data, 0, nil
+		}
+	}
+}
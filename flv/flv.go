@@ -0,0 +1,75 @@
+// Package flv writes and reads AAC audio tags of FLV files, the same
+// AUDIODATA that RTMP audio messages carry. The sequence header carries the
+// AudioSpecificConfig from EncInfo.ConfBuf of an encoder configured with
+// TtMp4Raw, the following tags carry raw access units.
+package flv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PacketType is the AACPacketType of an AAC audio tag.
+type PacketType uint8
+
+const (
+	// AudioSpecificConfig.
+	PacketSequenceHeader PacketType = 0
+	// Raw access unit.
+	PacketRaw PacketType = 1
+)
+
+const (
+	// Size of the file header.
+	HeaderSize = 9
+	// Size of the tag header.
+	TagHeaderSize = 11
+
+	// Tag types.
+	TagAudio  = 8
+	TagVideo  = 9
+	TagScript = 18
+
+	// SoundFormat of AAC.
+	soundFormatAac = 10
+	// SoundFormat AAC, SoundRate 44 kHz, SoundSize 16 bit, SoundType stereo.
+	// The specification requires these flags for AAC whatever the actual
+	// rate and channels, the AudioSpecificConfig describes the stream.
+	aacSoundFlags = soundFormatAac<<4 | 3<<2 | 1<<1 | 1
+	// Header flag of a file with audio tags.
+	flagAudio = 0x04
+	// Largest DataSize of a tag.
+	maxDataSize = 1<<24 - 1
+)
+
+var (
+	ErrInvalid     = errors.New("flv: invalid data")
+	ErrUnsupported = errors.New("flv: unsupported data")
+)
+
+// AudioData returns the AUDIODATA of an AAC tag, which is also the payload
+// of an RTMP audio message.
+func AudioData(packetType PacketType, data []byte) []byte {
+	b := make([]byte, 0, 2+len(data))
+	b = append(b, aacSoundFlags, byte(packetType))
+	return append(b, data...)
+}
+
+// ParseAudioData parses the AUDIODATA of an audio tag. It fails with
+// ErrUnsupported for other sound formats than AAC.
+func ParseAudioData(b []byte) (PacketType, []byte, error) {
+	if len(b) < 1 {
+		return 0, nil, fmt.Errorf("%w: empty audio data", ErrInvalid)
+	}
+	if format := b[0] >> 4; format != soundFormatAac {
+		return 0, nil, fmt.Errorf("%w: sound format %d", ErrUnsupported, format)
+	}
+	if len(b) < 2 {
+		return 0, nil, fmt.Errorf("%w: AAC audio data without packet type", ErrInvalid)
+	}
+	packetType := PacketType(b[1])
+	if packetType != PacketSequenceHeader && packetType != PacketRaw {
+		return 0, nil, fmt.Errorf("%w: AAC packet type %d", ErrInvalid, packetType)
+	}
+	return packetType, b[2:], nil
+}
@@ -0,0 +1,130 @@
+package flv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+// LC 44.1 kHz stereo.
+var testConf = []byte{0x12, 0x10}
+
+func TestFlv(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		aus := testutil.Frames(5, 100, 13)
+		var buf bytes.Buffer
+		m, err := NewMuxer(&buf, testConf)
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, au := range aus {
+			if err := m.WriteAU(au); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+
+		b := buf.Bytes()
+		if !bytes.Equal(b[:13], []byte{'F', 'L', 'V', 1, 0x04, 0, 0, 0, 9, 0, 0, 0, 0}) {
+			t.Errorf("unexpected file header % x", b[:13])
+		}
+		// Audio tag of 4 bytes, AAC 44 kHz 16 bit stereo, sequence header.
+		if !bytes.Equal(b[13:30], []byte{8, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0xAF, 0, 0x12, 0x10, 0, 0}) {
+			t.Errorf("unexpected sequence header tag % x", b[13:30])
+		}
+
+		// A video tag is skipped.
+		video := []byte{TagVideo, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0x17, 0x00, 0, 0, 0, 13}
+		d, err := NewDemuxer(io.MultiReader(bytes.NewReader(b[:13]), bytes.NewReader(video), bytes.NewReader(b[13:])))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		p, err := d.ReadPacket()
+		if err != nil || p.Type != PacketSequenceHeader || !bytes.Equal(p.Data, testConf) {
+			t.Fatalf("unexpected sequence header %+v (%v)", p, err)
+		}
+		for i, au := range aus {
+			p, err := d.ReadPacket()
+			if err != nil {
+				t.Fatalf("ReadPacket failed: %v", err)
+			}
+			if p.Type != PacketRaw || !bytes.Equal(p.Data, au) {
+				t.Errorf("packet %d differs", i)
+			}
+			if ts := uint32(i * 1024 * 1000 / 44100); p.Timestamp != ts {
+				t.Errorf("packet %d: expected timestamp %d, got %d", i, ts, p.Timestamp)
+			}
+		}
+		if _, err := d.ReadPacket(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Access units", func(t *testing.T) {
+		aus := testutil.Frames(3, 100, 13)
+		var buf bytes.Buffer
+		m, err := NewMuxer(&buf, testConf)
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, au := range aus {
+			m.WriteAU(au)
+		}
+
+		// A copy of the second raw tag before the sequence header is skipped.
+		b := buf.Bytes()
+		start := 32 + TagHeaderSize + 2 + len(aus[0]) + 4
+		raw := b[start : start+TagHeaderSize+2+len(aus[1])+4]
+		d, err := NewDemuxer(io.MultiReader(bytes.NewReader(b[:13]), bytes.NewReader(raw), bytes.NewReader(b[13:])))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if d.Config() != nil {
+			t.Errorf("expected no config before the sequence header, got % x", d.Config())
+		}
+		for i, au := range aus {
+			data, lost, err := d.ReadAU()
+			if err != nil {
+				t.Fatalf("ReadAU failed: %v", err)
+			}
+			if !bytes.Equal(data, au) || lost != 0 {
+				t.Errorf("access unit %d differs", i)
+			}
+		}
+		if !bytes.Equal(d.Config(), testConf) || d.Delay() != 0 {
+			t.Errorf("unexpected config % x and delay %v", d.Config(), d.Delay())
+		}
+		if _, _, err := d.ReadAU(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Audio data", func(t *testing.T) {
+		if _, _, err := ParseAudioData([]byte{0x2F, 0xFF}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for MP3, got %v", err)
+		}
+		if _, _, err := ParseAudioData([]byte{0xAF, 2}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		pt, data, err := ParseAudioData(AudioData(PacketRaw, []byte{1, 2, 3}))
+		if err != nil || pt != PacketRaw || !bytes.Equal(data, []byte{1, 2, 3}) {
+			t.Errorf("unexpected audio data %d % x (%v)", pt, data, err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewDemuxer(bytes.NewReader([]byte("FLX\x01\x04\x00\x00\x00\x09\x00\x00\x00\x00"))); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		var buf bytes.Buffer
+		m, _ := NewMuxer(&buf, testConf)
+		m.WriteAU([]byte{1, 2, 3})
+		d, _ := NewDemuxer(bytes.NewReader(buf.Bytes()[:buf.Len()-6]))
+		d.ReadPacket()
+		if _, err := d.ReadPacket(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for a truncated tag, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package flv
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+// Muxer writes an audio only FLV file of AAC tags.
+type Muxer struct {
+	w          io.Writer
+	sampleRate int64
+	frameLen   int64
+	samples    int64
+}
+
+// NewMuxer returns a Muxer writing to w the stream described by conf, the
+// AudioSpecificConfig from EncInfo.ConfBuf. The file header and the sequence
+// header are written right away.
+func NewMuxer(w io.Writer, conf []byte) (*Muxer, error) {
+	config, err := asc.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	m := &Muxer{
+		w:          w,
+		sampleRate: int64(config.SampleRate()),
+		frameLen:   int64(config.FrameLength()),
+	}
+	if m.sampleRate == 0 || m.frameLen == 0 {
+		return nil, fmt.Errorf("%w: sample rate %d, frame length %d", ErrInvalid, m.sampleRate, m.frameLen)
+	}
+
+	// Signature, version 1, audio only, PreviousTagSize0.
+	header := []byte{'F', 'L', 'V', 1, flagAudio, 0, 0, 0, HeaderSize, 0, 0, 0, 0}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	if err = m.writeTag(0, AudioData(PacketSequenceHeader, conf)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteAU writes an access unit. Its timestamp in milliseconds is derived
+// from the number of samples written before it.
+func (m *Muxer) WriteAU(au []byte) error {
+	if len(au) == 0 {
+		return fmt.Errorf("%w: empty access unit", ErrInvalid)
+	}
+	timestamp := uint32(m.samples * 1000 / m.sampleRate)
+	m.samples += m.frameLen
+	return m.writeTag(timestamp, AudioData(PacketRaw, au))
+}
+
+// writeTag writes an audio tag followed by its PreviousTagSize.
+func (m *Muxer) writeTag(timestamp uint32, data []byte) error {
+	if len(data) > maxDataSize {
+		return fmt.Errorf("%w: tag of %d bytes", ErrInvalid, len(data))
+	}
+	n := len(data)
+	b := make([]byte, 0, TagHeaderSize+n+4)
+	b = append(b, TagAudio, byte(n>>16), byte(n>>8), byte(n),
+		byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24),
+		0, 0, 0)
+	b = append(b, data...)
+	size := TagHeaderSize + n
+	b = append(b, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	_, err := m.w.Write(b)
+	return err
+}
@@ -0,0 +1,153 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac"
+)
+
+// Number of frames encoded per EncodePackets call, bounds the buffer sizes.
+const encodeChunkFrames = 16
+
+// Writer is an io.WriteCloser that encodes raw PCM written to it and packages
+// the access units for HLS.
+type Writer struct {
+	enc      *fdkaac.Encoder
+	packager *Packager
+	closed   bool
+}
+
+// NewWriter creates a Writer encoding with encConfig, which is always set to
+// the TtMp4Raw transport type, and packaging as configured by config. If
+// config.ObjectType is 0 the CODECS attribute follows the AudioSpecificConfig
+// in EncInfo.ConfBuf.
+// PCM written to it must be interleaved 16-bit samples matching encConfig.
+// Close flushes the encoder and must be called to write the last segment.
+func NewWriter(encConfig *fdkaac.EncoderConfig, config *Config) (*Writer, error) {
+	var ec fdkaac.EncoderConfig
+	if encConfig != nil {
+		ec = *encConfig
+	}
+	ec.TransMux = fdkaac.TtMp4Raw
+	enc, err := fdkaac.NewEncoder(&ec)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if config != nil {
+		c = *config
+	}
+	if c.Delay == 0 {
+		c.Delay = enc.NDelay
+		c.SampleRate = ec.SampleRate
+	}
+	packager, err := NewPackager(enc.ConfBuf, &c)
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return &Writer{
+		enc:      enc,
+		packager: packager,
+	}, nil
+}
+
+// Codecs returns the codecs string of the stream.
+func (w *Writer) Codecs() string {
+	return w.packager.Codecs()
+}
+
+// Write encodes p and writes the segments it completes.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, errors.New("write to closed encoder")
+	}
+
+	chunkSize := encodeChunkFrames * w.enc.FrameBytes
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		packets, err := w.enc.EncodePackets(chunk)
+		if err != nil {
+			return n, err
+		}
+		if err = w.writePackets(packets); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+func (w *Writer) writePackets(packets []fdkaac.Packet) error {
+	for _, pkt := range packets {
+		if err := w.packager.WriteAU(pkt.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the encoder, writes the last segment and the final playlists
+// and releases the encoder.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.enc.Close()
+
+	packets, err := w.enc.FlushPackets()
+	if err == nil {
+		err = w.writePackets(packets)
+	}
+	if err != nil {
+		w.packager.Abort()
+		return err
+	}
+	return w.packager.Close()
+}
+
+// abort releases the encoder and stops the packager without finishing the
+// stream.
+func (w *Writer) abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.enc.Close()
+	w.packager.Abort()
+}
+
+// EncodeFromWav encodes a WAV audio stream and packages it for HLS. The
+// SampleRate and MaxChannels of encConfig are taken from the WAV header.
+func EncodeFromWav(wavStream io.Reader, encConfig *fdkaac.EncoderConfig, config *Config) error {
+	pcmSize, sampleRate, numChannels, bitsPerSample, err := fdkaac.ParseWavHeader(wavStream)
+	if err != nil {
+		return fmt.Errorf("parse WAV header failed: %w", err)
+	}
+	if bitsPerSample != fdkaac.SampleBitDepth {
+		return fmt.Errorf("unsupported bits per sample: %d (only 16-bit supported)", bitsPerSample)
+	}
+
+	var ec fdkaac.EncoderConfig
+	if encConfig != nil {
+		ec = *encConfig
+	}
+	ec.SampleRate = sampleRate
+	ec.MaxChannels = numChannels
+	w, err := NewWriter(&ec, config)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, io.LimitReader(wavStream, int64(pcmSize))); err != nil {
+		w.abort()
+		return err
+	}
+	return w.Close()
+}
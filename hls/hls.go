@@ -0,0 +1,173 @@
+// Package hls packages AAC audio for HTTP Live Streaming. It encodes a PCM or
+// WAV source, or takes the access units of an encoder configured with
+// TtMp4Raw, cuts them into packed audio, MPEG-TS or fragmented MP4 segments
+// and writes the media and multivariant playlists to a local directory.
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+// SegmentFormat selects the container of the media segments.
+type SegmentFormat int
+
+const (
+	// ADTS frames with an ID3 PRIV timestamp, the .aac packed audio format.
+	FormatPackedAudio SegmentFormat = iota
+	// MPEG-2 transport stream segments, .ts.
+	FormatTs
+	// Fragmented MP4 (CMAF) segments, .m4s, with an init segment.
+	FormatFmp4
+)
+
+const (
+	// Default target duration of a segment.
+	DefaultSegmentDuration = 6 * time.Second
+	// Default number of segments in a live playlist.
+	DefaultWindowSize = 6
+	// Default name of the media playlist.
+	DefaultPlaylistName = "index.m3u8"
+	// Default name of the multivariant playlist.
+	DefaultMasterPlaylistName = "master.m3u8"
+	// Default prefix of the segment file names.
+	DefaultSegmentPrefix = "segment"
+
+	// Owner identifier of the ID3 PRIV frame carrying the MPEG-2 timestamp
+	// of the first frame of a packed audio segment.
+	TimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+)
+
+var (
+	ErrInvalid = errors.New("hls: invalid data")
+	ErrClosed  = errors.New("hls: packager is closed")
+)
+
+// Config configures a Packager.
+type Config struct {
+	// Directory the playlists and segments are written to, created if missing.
+	Dir string
+	// Format of the media segments, FormatPackedAudio if 0.
+	Format SegmentFormat
+	// Target duration of the segments (default DefaultSegmentDuration).
+	SegmentDuration time.Duration
+	// Live selects a sliding window playlist that is rewritten with every
+	// segment. Otherwise a VOD playlist is written on Close.
+	Live bool
+	// Number of segments in a live playlist (default DefaultWindowSize).
+	// Segments are deleted once they left the window for as many segments again.
+	WindowSize int
+	// Name of the media playlist (default DefaultPlaylistName).
+	PlaylistName string
+	// Name of the multivariant playlist referencing the media playlist with
+	// its CODECS and BANDWIDTH (default DefaultMasterPlaylistName).
+	MasterPlaylistName string
+	// Prefix of the segment file names (default DefaultSegmentPrefix).
+	SegmentPrefix string
+	// Audio object type for the CODECS attribute, the values match
+	// fdkaac.AudioObjectType. If 0 it is derived from the AudioSpecificConfig,
+	// which only shows SBR and PS with explicit signaling.
+	ObjectType int
+	// Number of priming samples, e.g. EncInfo.NDelay, written as an edit list
+	// in the fMP4 init segment. A Writer uses the encoder delay if 0.
+	Delay int
+	// Sample rate Delay is counted in, see mp4.MuxerConfig.SampleRate. A Writer
+	// uses the encoder sample rate.
+	SampleRate int
+}
+
+// Codecs returns the RFC 6381 codecs string for an audio object type, e.g.
+// "mp4a.40.2" for AAC-LC, "mp4a.40.5" for HE-AAC and "mp4a.40.29" for HE-AACv2.
+func Codecs(objectType int) string {
+	return fmt.Sprintf("mp4a.40.%d", objectType)
+}
+
+// objectType returns the audio object type signaled by config.
+func objectType(config *asc.AudioSpecificConfig) int {
+	switch {
+	case config.PsPresent:
+		return asc.AotPs
+	case config.SbrPresent && config.ObjectType != asc.AotErAacEld:
+		return asc.AotSbr
+	}
+	return config.ObjectType
+}
+
+// segment is a media segment listed in the playlist.
+type segment struct {
+	name     string
+	duration float64
+	size     int
+}
+
+// playlist returns the media playlist of segments, starting with media
+// sequence number sequence.
+func playlist(segments []segment, sequence int, target time.Duration, initName string, live, end bool) string {
+	targetDuration := int(math.Round(target.Seconds()))
+	for _, s := range segments {
+		targetDuration = max(targetDuration, int(math.Round(s.duration)))
+	}
+	version := 3
+	if initName != "" {
+		version = 6
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if !live {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if initName != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", initName)
+	}
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", s.duration, s.name)
+	}
+	if end {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// masterPlaylist returns a multivariant playlist with the single media playlist.
+func masterPlaylist(name, codecs string, bandwidth, averageBandwidth int) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"\n",
+		bandwidth, averageBandwidth, codecs)
+	fmt.Fprintf(&b, "%s\n", name)
+	return b.String()
+}
+
+// timestampTag returns the ID3v2.4 tag with a PRIV frame holding the 33 bit
+// MPEG-2 timestamp pts, which starts a packed audio segment.
+func timestampTag(pts int64) []byte {
+	frame := make([]byte, 0, len(TimestampOwner)+1+8)
+	frame = append(frame, TimestampOwner...)
+	frame = append(frame, 0)
+	for i := 7; i >= 0; i-- {
+		frame = append(frame, byte(pts>>(8*i)))
+	}
+
+	b := make([]byte, 0, 20+len(frame))
+	b = append(b, 'I', 'D', '3', 4, 0, 0)
+	b = appendSyncsafe(b, 10+len(frame))
+	b = append(b, 'P', 'R', 'I', 'V')
+	b = appendSyncsafe(b, len(frame))
+	b = append(b, 0, 0)
+	return append(b, frame...)
+}
+
+func appendSyncsafe(b []byte, n int) []byte {
+	return append(b, byte(n>>21)&0x7F, byte(n>>14)&0x7F, byte(n>>7)&0x7F, byte(n)&0x7F)
+}
@@ -0,0 +1,211 @@
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac"
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+	"github.com/lizc2003/audio-fdkaac/ts"
+)
+
+// LC 44.1 kHz stereo.
+var testConf = []byte{0x12, 0x10}
+
+func packageAUs(t *testing.T, config *Config, aus [][]byte) *Packager {
+	p, err := NewPackager(testConf, config)
+	if err != nil {
+		t.Fatalf("NewPackager failed: %v", err)
+	}
+	for _, au := range aus {
+		if err := p.WriteAU(au); err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return p
+}
+
+func readFile(t *testing.T, dir, name string) []byte {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read %s failed: %v", name, err)
+	}
+	return b
+}
+
+func TestPackager(t *testing.T) {
+	t.Run("Codecs", func(t *testing.T) {
+		cases := []struct {
+			conf   []byte
+			codecs string
+		}{
+			{testConf, "mp4a.40.2"},
+			{[]byte{0x2B, 0x92, 0x08, 0x00}, "mp4a.40.5"},
+			{[]byte{0xEB, 0x8A, 0x08, 0x00}, "mp4a.40.29"},
+		}
+		for _, c := range cases {
+			p, err := NewPackager(c.conf, &Config{Dir: t.TempDir(), Format: FormatFmp4})
+			if err != nil {
+				t.Fatalf("NewPackager failed: %v", err)
+			}
+			if p.Codecs() != c.codecs {
+				t.Errorf("expected %s, got %s", c.codecs, p.Codecs())
+			}
+		}
+	})
+
+	t.Run("Packed audio VOD", func(t *testing.T) {
+		dir := t.TempDir()
+		// 100 frames of 1024 samples are 2.32 s.
+		aus := testutil.AUs(100)
+		packageAUs(t, &Config{Dir: dir, SegmentDuration: time.Second}, aus)
+
+		media := string(readFile(t, dir, DefaultPlaylistName))
+		for _, s := range []string{"#EXT-X-TARGETDURATION:1\n", "#EXT-X-PLAYLIST-TYPE:VOD\n",
+			"#EXTINF:1.021678,\nsegment0.aac\n", "#EXTINF:0.998458,\nsegment1.aac\n",
+			"#EXTINF:0.301859,\nsegment2.aac\n", "#EXT-X-ENDLIST\n"} {
+			if !strings.Contains(media, s) {
+				t.Errorf("playlist lacks %q:\n%s", s, media)
+			}
+		}
+		master := string(readFile(t, dir, DefaultMasterPlaylistName))
+		if !strings.Contains(master, `CODECS="mp4a.40.2"`) || !strings.Contains(master, "\nindex.m3u8\n") {
+			t.Errorf("unexpected multivariant playlist:\n%s", master)
+		}
+
+		seg := readFile(t, dir, "segment1.aac")
+		tag := timestampTag(ts.DefaultStartPTS + 44*1024*ts.ClockRate/44100)
+		if !bytes.HasPrefix(seg, tag) || !bytes.Contains(tag, []byte(TimestampOwner+"\x00")) {
+			t.Fatalf("segment does not start with the timestamp tag")
+		}
+		h, err := adts.Parse(seg[len(tag):])
+		if err != nil || !bytes.Equal(seg[len(tag)+h.Size():len(tag)+h.FrameLength], aus[44]) {
+			t.Errorf("unexpected first frame of segment 1 (%v)", err)
+		}
+	})
+
+	t.Run("MPEG-TS", func(t *testing.T) {
+		dir := t.TempDir()
+		packageAUs(t, &Config{Dir: dir, Format: FormatTs, SegmentDuration: time.Second}, testutil.AUs(100))
+		for _, name := range []string{"segment0.ts", "segment1.ts", "segment2.ts"} {
+			seg := readFile(t, dir, name)
+			d, err := ts.NewDemuxer(bytes.NewReader(seg), nil)
+			if err != nil {
+				t.Fatalf("%s: NewDemuxer failed: %v", name, err)
+			}
+			if f, err := d.ReadFrame(); err != nil || f.PTS < ts.DefaultStartPTS {
+				t.Errorf("%s: unexpected first frame %+v (%v)", name, f, err)
+			}
+		}
+	})
+
+	t.Run("fMP4 live", func(t *testing.T) {
+		dir := t.TempDir()
+		packageAUs(t, &Config{Dir: dir, Format: FormatFmp4, SegmentDuration: time.Second,
+			Live: true, WindowSize: 2}, testutil.AUs(300))
+
+		media := string(readFile(t, dir, DefaultPlaylistName))
+		for _, s := range []string{"#EXT-X-VERSION:6\n", "#EXT-X-MAP:URI=\"segment-init.mp4\"\n",
+			"#EXT-X-MEDIA-SEQUENCE:5\n", "segment5.m4s\n", "segment6.m4s\n", "#EXT-X-ENDLIST\n"} {
+			if !strings.Contains(media, s) {
+				t.Errorf("playlist lacks %q:\n%s", s, media)
+			}
+		}
+		if strings.Contains(media, "PLAYLIST-TYPE") || strings.Contains(media, "segment4.m4s") {
+			t.Errorf("unexpected live playlist:\n%s", media)
+		}
+		// Segments 0 to 2 are more than a window behind.
+		for i, want := range []bool{false, false, false, true, true, true, true} {
+			_, err := os.Stat(filepath.Join(dir, "segment"+string(rune('0'+i))+".m4s"))
+			if (err == nil) != want {
+				t.Errorf("segment %d exists: %v, expected %v", i, err == nil, want)
+			}
+		}
+		readFile(t, dir, "segment-init.mp4")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewPackager(testConf, nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		p, _ := NewPackager(testConf, &Config{Dir: t.TempDir()})
+		p.Close()
+		if err := p.WriteAU([]byte{1}); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+
+		// Abort leaves the VOD playlist unwritten.
+		dir := t.TempDir()
+		p, _ = NewPackager(testConf, &Config{Dir: dir})
+		for _, au := range testutil.AUs(10) {
+			p.WriteAU(au)
+		}
+		p.Abort()
+		if err := p.WriteAU([]byte{1}); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, DefaultPlaylistName)); err == nil {
+			t.Errorf("playlist written after Abort")
+		}
+	})
+}
+
+type failReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func TestEncodeFromWav(t *testing.T) {
+	// Three seconds of stereo silence.
+	pcm := make([]byte, 3*44100*4)
+	wav := append(fdkaac.GenerateWavHeader(len(pcm), 44100, 2, 16), pcm...)
+	dir := t.TempDir()
+	err := EncodeFromWav(bytes.NewReader(wav), &fdkaac.EncoderConfig{
+		AOT:     fdkaac.AotSbr,
+		Bitrate: 48000,
+	}, &Config{Dir: dir, SegmentDuration: time.Second})
+	if err != nil {
+		t.Fatalf("EncodeFromWav failed: %v", err)
+	}
+
+	master := string(readFile(t, dir, DefaultMasterPlaylistName))
+	if !strings.Contains(master, `CODECS="mp4a.40.5"`) {
+		t.Errorf("expected HE-AAC codecs:\n%s", master)
+	}
+	media := string(readFile(t, dir, DefaultPlaylistName))
+	if !strings.Contains(media, "segment2.aac\n") || !strings.HasSuffix(media, "#EXT-X-ENDLIST\n") {
+		t.Errorf("unexpected playlist:\n%s", media)
+	}
+}
+
+func TestEncodeFromWavReadError(t *testing.T) {
+	pcm := make([]byte, 44100*4)
+	wav := append(fdkaac.GenerateWavHeader(2*len(pcm), 44100, 2, 16), pcm...)
+	dir := t.TempDir()
+	rErr := errors.New("read failed")
+	err := EncodeFromWav(&failReader{r: bytes.NewReader(wav), err: rErr}, nil, &Config{Dir: dir})
+	if err != rErr {
+		t.Fatalf("expected %v, got %v", rErr, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, DefaultPlaylistName)); err == nil {
+		t.Errorf("playlist of the failed encode written")
+	}
+}
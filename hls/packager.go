@@ -0,0 +1,308 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/mp4"
+	"github.com/lizc2003/audio-fdkaac/remux"
+	"github.com/lizc2003/audio-fdkaac/ts"
+)
+
+// Packager cuts access units into segments and keeps the playlists up to
+// date. All segment formats are cut where an mp4.Splitter chooses.
+type Packager struct {
+	dir        string
+	format     SegmentFormat
+	duration   time.Duration
+	live       bool
+	window     int
+	playlist   string
+	master     string
+	prefix     string
+	initName   string
+	codecs     string
+	sampleRate int
+	frameLen   int
+
+	header    *adts.Header
+	tsMuxer   *ts.Muxer
+	segmenter *mp4.Segmenter
+	splitter  *mp4.Splitter
+
+	// Samples written, and at the start of the current segment.
+	samples      int64
+	segmentStart int64
+	buf          bytes.Buffer
+	frames       int
+
+	segments  []segment
+	sequence  int
+	deleted   int
+	totalSize int64
+	bandwidth int
+	closed    bool
+}
+
+// NewPackager returns a Packager for the stream described by conf, the
+// AudioSpecificConfig from EncInfo.ConfBuf. Packed audio and MPEG-TS
+// segments carry ADTS, so they are limited to what ADTS can carry.
+func NewPackager(conf []byte, config *Config) (*Packager, error) {
+	if config == nil || config.Dir == "" {
+		return nil, fmt.Errorf("%w: no output directory", ErrInvalid)
+	}
+	ac, err := asc.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Packager{
+		dir:        config.Dir,
+		format:     config.Format,
+		duration:   DefaultSegmentDuration,
+		live:       config.Live,
+		window:     DefaultWindowSize,
+		playlist:   DefaultPlaylistName,
+		master:     DefaultMasterPlaylistName,
+		prefix:     DefaultSegmentPrefix,
+		sampleRate: ac.SampleRate(),
+		frameLen:   ac.FrameLength(),
+	}
+	if config.SegmentDuration > 0 {
+		p.duration = config.SegmentDuration
+	}
+	if config.WindowSize > 0 {
+		p.window = config.WindowSize
+	}
+	if config.PlaylistName != "" {
+		p.playlist = config.PlaylistName
+	}
+	if config.MasterPlaylistName != "" {
+		p.master = config.MasterPlaylistName
+	}
+	if config.SegmentPrefix != "" {
+		p.prefix = config.SegmentPrefix
+	}
+	aot := config.ObjectType
+	if aot == 0 {
+		aot = objectType(ac)
+	}
+	p.codecs = Codecs(aot)
+
+	if p.sampleRate == 0 || p.frameLen == 0 {
+		return nil, fmt.Errorf("%w: sample rate %d, frame length %d", ErrInvalid, p.sampleRate, p.frameLen)
+	}
+	target := uint64(p.duration) * uint64(p.sampleRate) / uint64(time.Second)
+	if p.splitter, err = mp4.NewSplitter(target); err != nil {
+		return nil, fmt.Errorf("%w: segment duration %v", ErrInvalid, p.duration)
+	}
+
+	switch p.format {
+	case FormatPackedAudio, FormatTs:
+		if p.header, err = remux.ADTSHeader(ac); err != nil {
+			return nil, err
+		}
+		if p.format == FormatTs {
+			if p.tsMuxer, err = ts.NewMuxer(&p.buf, nil); err != nil {
+				return nil, err
+			}
+		}
+	case FormatFmp4:
+		p.segmenter, err = mp4.NewSegmenter(conf, &mp4.SegmenterConfig{
+			SegmentDuration: p.duration,
+			Delay:           config.Delay,
+			SampleRate:      config.SampleRate,
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: segment format %d", ErrInvalid, p.format)
+	}
+
+	if err = os.MkdirAll(p.dir, 0755); err != nil {
+		return nil, err
+	}
+	if p.segmenter != nil {
+		p.initName = p.prefix + "-init.mp4"
+		if err = p.writeFile(p.initName, p.segmenter.InitSegment()); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Codecs returns the codecs string of the stream.
+func (p *Packager) Codecs() string {
+	return p.codecs
+}
+
+// WriteAU adds an access unit and writes the segment it completes.
+func (p *Packager) WriteAU(au []byte) error {
+	if p.closed {
+		return ErrClosed
+	}
+	if len(au) == 0 {
+		return fmt.Errorf("%w: empty access unit", ErrInvalid)
+	}
+
+	if p.segmenter != nil {
+		p.samples += int64(p.frameLen)
+		seg, err := p.segmenter.WriteAU(au)
+		if err != nil || seg == nil {
+			return err
+		}
+		return p.writeSegment(seg.Data)
+	}
+
+	if p.frames == 0 {
+		p.startSegment()
+	}
+	h := *p.header
+	h.FrameLength = h.Size() + len(au)
+	header, err := h.Marshal()
+	if err != nil {
+		return err
+	}
+	frame := append(header, au...)
+	if p.tsMuxer != nil {
+		if _, err = p.tsMuxer.Write(frame); err != nil {
+			return err
+		}
+	} else {
+		p.buf.Write(frame)
+	}
+	p.frames++
+	p.samples += int64(p.frameLen)
+	if !p.splitter.Add(uint64(p.frameLen)) {
+		return nil
+	}
+	return p.endSegment()
+}
+
+// startSegment starts a packed audio or MPEG-TS segment.
+func (p *Packager) startSegment() {
+	p.buf.Reset()
+	if p.tsMuxer != nil {
+		p.tsMuxer.WriteTables()
+	} else {
+		pts := ts.DefaultStartPTS + p.samples*ts.ClockRate/int64(p.sampleRate)
+		p.buf.Write(timestampTag(pts & (1<<33 - 1)))
+	}
+}
+
+// endSegment writes the pending packed audio or MPEG-TS segment.
+func (p *Packager) endSegment() error {
+	if p.tsMuxer != nil {
+		if err := p.tsMuxer.Flush(); err != nil {
+			return err
+		}
+	}
+	p.frames = 0
+	return p.writeSegment(p.buf.Bytes())
+}
+
+// writeSegment writes the segment ending at the current sample and updates
+// the playlists.
+func (p *Packager) writeSegment(data []byte) error {
+	ext := ".aac"
+	switch p.format {
+	case FormatTs:
+		ext = ".ts"
+	case FormatFmp4:
+		ext = ".m4s"
+	}
+	seg := segment{
+		name:     fmt.Sprintf("%s%d%s", p.prefix, p.sequence+len(p.segments), ext),
+		duration: float64(p.samples-p.segmentStart) / float64(p.sampleRate),
+		size:     len(data),
+	}
+	p.segmentStart = p.samples
+	if err := p.writeFile(seg.name, data); err != nil {
+		return err
+	}
+
+	p.segments = append(p.segments, seg)
+	p.totalSize += int64(seg.size)
+	if seg.duration > 0 {
+		p.bandwidth = max(p.bandwidth, int(float64(seg.size*8)/seg.duration))
+	}
+	if !p.live {
+		return nil
+	}
+
+	if len(p.segments) > p.window {
+		n := len(p.segments) - p.window
+		p.segments = p.segments[n:]
+		p.sequence += n
+	}
+	// Keep the segments that left the window available to clients still
+	// holding an older playlist.
+	for ; p.deleted < p.sequence-p.window; p.deleted++ {
+		name := fmt.Sprintf("%s%d%s", p.prefix, p.deleted, ext)
+		if err := os.Remove(filepath.Join(p.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return p.writePlaylists(false)
+}
+
+// writePlaylists writes the media playlist and the multivariant playlist.
+func (p *Packager) writePlaylists(end bool) error {
+	media := playlist(p.segments, p.sequence, p.duration, p.initName, p.live, end)
+	if err := p.writeFile(p.playlist, []byte(media)); err != nil {
+		return err
+	}
+	average := p.bandwidth
+	if p.samples > 0 {
+		average = int(p.totalSize * 8 * int64(p.sampleRate) / p.samples)
+	}
+	master := masterPlaylist(p.playlist, p.codecs, p.bandwidth, average)
+	return p.writeFile(p.master, []byte(master))
+}
+
+// writeFile replaces the file name in the output directory. The data is
+// written to a temporary file first, so readers never see a partial file.
+func (p *Packager) writeFile(name string, data []byte) error {
+	path := filepath.Join(p.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Close writes the last, possibly shorter, segment and the final playlists,
+// ended with EXT-X-ENDLIST.
+func (p *Packager) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if p.segmenter != nil {
+		if seg := p.segmenter.Flush(); seg != nil {
+			if err := p.writeSegment(seg.Data); err != nil {
+				return err
+			}
+		}
+	} else if p.frames > 0 {
+		if err := p.endSegment(); err != nil {
+			return err
+		}
+	}
+	return p.writePlaylists(true)
+}
+
+// Abort stops the Packager after a failure upstream. Unlike Close it does not
+// write the pending segment or end the playlists; the files already written
+// are left in place.
+func (p *Packager) Abort() {
+	p.closed = true
+	p.buf.Reset()
+}
@@ -0,0 +1,438 @@
+package fdkaac
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/flv"
+	"github.com/lizc2003/audio-fdkaac/latm"
+	"github.com/lizc2003/audio-fdkaac/mkv"
+	"github.com/lizc2003/audio-fdkaac/mp4"
+	"github.com/lizc2003/audio-fdkaac/ts"
+)
+
+// TestIntegration feeds the encoder output to the pure Go packages.
+func TestIntegration(t *testing.T) {
+	t.Run("AudioSpecificConfig round trip", func(t *testing.T) {
+		cases := []struct {
+			aot        AudioObjectType
+			signaling  SignalingMode
+			sampleRate int
+		}{
+			{AotAacLc, SignalingModeImplicitCompatible, 44100},
+			{AotSbr, SignalingModeImplicitCompatible, 44100},
+			{AotSbr, SignalingModeExplicitCompatible, 44100},
+			{AotSbr, SignalingModeExplicitHierarchical, 44100},
+			{AotPs, SignalingModeExplicitCompatible, 44100},
+			{AotPs, SignalingModeExplicitHierarchical, 44100},
+			{AotErAacLd, SignalingModeImplicitCompatible, 48000},
+			{AotErAacEld, SignalingModeImplicitCompatible, 48000},
+		}
+		for _, c := range cases {
+			encoder, err := NewEncoder(&EncoderConfig{
+				TransMux:      TtMp4Raw,
+				AOT:           c.aot,
+				SignalingMode: c.signaling,
+				SampleRate:    c.sampleRate,
+				MaxChannels:   2,
+				Bitrate:       64000,
+			})
+			if err != nil {
+				t.Fatalf("CreateAacEncoder aot %d failed: %v", c.aot, err)
+			}
+
+			config, err := asc.Parse(encoder.ConfBuf)
+			if err != nil {
+				t.Errorf("Parse aot %d signaling %d failed: %v", c.aot, c.signaling, err)
+				encoder.Close()
+				continue
+			}
+			implicitSbr := (c.aot == AotSbr || c.aot == AotPs) && c.signaling == SignalingModeImplicitCompatible
+			if !implicitSbr {
+				if config.SampleRate() != c.sampleRate {
+					t.Errorf("aot %d: expected sample rate %d, got %d", c.aot, c.sampleRate, config.SampleRate())
+				}
+				if config.FrameLength() != encoder.FrameLength {
+					t.Errorf("aot %d: expected frame length %d, got %d", c.aot, encoder.FrameLength, config.FrameLength())
+				}
+			}
+			b, err := config.Marshal()
+			if err != nil {
+				t.Errorf("Marshal aot %d failed: %v", c.aot, err)
+			} else if !bytes.Equal(b, encoder.ConfBuf) {
+				t.Errorf("aot %d signaling %d: round trip % x, expected % x", c.aot, c.signaling, b, encoder.ConfBuf)
+			}
+			encoder.Close()
+		}
+	})
+
+	t.Run("Encode LOAS", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:        TtMp4Loas,
+			SampleRate:      44100,
+			MaxChannels:     2,
+			Bitrate:         64000,
+			AudioMuxVersion: 1,
+			HeaderPeriod:    2,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		var stream bytes.Buffer
+		output := make([]byte, 8192)
+		for i := 0; i < 8; i++ {
+			n, _, err := encoder.Encode(PCM0, output)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			stream.Write(output[:n])
+		}
+		n, _, err := encoder.Flush(output)
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		stream.Write(output[:n])
+
+		s := latm.NewScanner(&stream)
+		elements, configs := 0, 0
+		for s.Scan() {
+			e := s.Element()
+			elements++
+			if e.ConfigPresent {
+				configs++
+			}
+			if e.Config.AudioMuxVersion != 1 {
+				t.Errorf("expected AudioMuxVersion 1, got %d", e.Config.AudioMuxVersion)
+			}
+			if len(e.Payloads) != 1 || e.Payloads[0].Config.SampleRate() != 44100 {
+				t.Errorf("unexpected payloads %+v", e.Payloads)
+			}
+		}
+		if s.Err() != nil || s.Skipped() != 0 {
+			t.Errorf("Scan failed: %v, %d bytes skipped", s.Err(), s.Skipped())
+		}
+		if elements == 0 || configs != (elements+1)/2 {
+			t.Errorf("expected a config every 2 of %d elements, got %d", elements, configs)
+		}
+	})
+
+	t.Run("Encode to MP4", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Raw,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.m4a"))
+		if err != nil {
+			t.Fatalf("create file failed: %v", err)
+		}
+		defer f.Close()
+		muxer, err := mp4.NewMuxer(f, encoder.ConfBuf, &mp4.MuxerConfig{
+			FileFormat: mp4.FileFormat(FfMp4f),
+			Faststart:  true,
+			Delay:      encoder.NDelay,
+			SampleRate: 44100,
+		})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+
+		packets, err := encoder.EncodePackets(PCM0)
+		if err != nil {
+			t.Fatalf("EncodePackets failed: %v", err)
+		}
+		flushed, err := encoder.FlushPackets()
+		if err != nil {
+			t.Fatalf("FlushPackets failed: %v", err)
+		}
+		packets = append(packets, flushed...)
+		for _, pkt := range packets {
+			if err = muxer.WriteAU(pkt.Data); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+		if err = muxer.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		demuxer, err := mp4.NewDemuxer(f)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		track := demuxer.Track()
+		if !bytes.Equal(track.Config, encoder.ConfBuf) {
+			t.Errorf("expected esds config % x, got % x", encoder.ConfBuf, track.Config)
+		}
+		if track.Delay != int64(encoder.NDelay) {
+			t.Errorf("expected delay %d, got %d", encoder.NDelay, track.Delay)
+		}
+		n := 0
+		for {
+			if _, err = demuxer.ReadSample(); err != nil {
+				break
+			}
+			n++
+		}
+		if err != io.EOF || n != len(packets) {
+			t.Errorf("expected %d samples, got %d: %v", len(packets), n, err)
+		}
+	})
+
+	t.Run("Encode to MPEG-TS", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		var stream bytes.Buffer
+		muxer, err := ts.NewMuxer(&stream, &ts.MuxerConfig{FramesPerPES: 4})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		output := make([]byte, 8192)
+		for i := 0; i < 8; i++ {
+			n, _, err := encoder.Encode(PCM0, output)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if _, err = muxer.Write(output[:n]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		n, _, err := encoder.Flush(output)
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if _, err = muxer.Write(output[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err = muxer.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+
+		if stream.Len() == 0 || stream.Len()%ts.PacketSize != 0 {
+			t.Errorf("expected whole transport stream packets, got %d bytes", stream.Len())
+		}
+	})
+
+	t.Run("Encode to Matroska", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Raw,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.mka"))
+		if err != nil {
+			t.Fatalf("create file failed: %v", err)
+		}
+		defer f.Close()
+		muxer, err := mkv.NewMuxer(f, encoder.ConfBuf, &mkv.MuxerConfig{Delay: encoder.NDelay})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+
+		packets, err := encoder.EncodePackets(PCM0)
+		if err != nil {
+			t.Fatalf("EncodePackets failed: %v", err)
+		}
+		flushed, err := encoder.FlushPackets()
+		if err != nil {
+			t.Fatalf("FlushPackets failed: %v", err)
+		}
+		packets = append(packets, flushed...)
+		for _, pkt := range packets {
+			if err = muxer.WriteAU(pkt.Data); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+		if err = muxer.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		demuxer, err := mkv.NewDemuxer(f)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if !bytes.Equal(demuxer.Track().Config, encoder.ConfBuf) {
+			t.Errorf("expected CodecPrivate % x, got % x", encoder.ConfBuf, demuxer.Track().Config)
+		}
+		n := 0
+		for {
+			if _, err = demuxer.ReadSample(); err != nil {
+				break
+			}
+			n++
+		}
+		if err != io.EOF || n != len(packets) {
+			t.Errorf("expected %d samples, got %d: %v", len(packets), n, err)
+		}
+	})
+}
+
+// TestDecodeM4aToWav decodes M4A files with DecodeToWav.
+func TestDecodeM4aToWav(t *testing.T) {
+	dir := t.TempDir()
+	decode := func(name string, delay int) int {
+		m4a, err := os.Create(filepath.Join(dir, name+".m4a"))
+		if err != nil {
+			t.Fatalf("create file failed: %v", err)
+		}
+		defer m4a.Close()
+
+		muxer, err := mp4.NewMuxer(m4a, []byte{0x12, 0x10}, &mp4.MuxerConfig{Delay: delay})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, frame := range [][]byte{AAC0, AAC1, AAC2} {
+			if err = muxer.WriteAU(frame[7:]); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+		if err = muxer.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if _, err = m4a.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+
+		wav, err := os.Create(filepath.Join(dir, name+".wav"))
+		if err != nil {
+			t.Fatalf("create file failed: %v", err)
+		}
+		defer wav.Close()
+
+		totalBytes, totalSamples, sampleRate, err := DecodeToWav(m4a, wav, nil)
+		if err != nil {
+			t.Fatalf("DecodeToWav failed: %v", err)
+		}
+		if sampleRate != 44100 {
+			t.Errorf("expected sample rate 44100, got %d", sampleRate)
+		}
+		if totalBytes != totalSamples*4+WavHeaderSize {
+			t.Errorf("unexpected total bytes %d for %d samples", totalBytes, totalSamples)
+		}
+		return totalSamples
+	}
+
+	full := decode("full", 0)
+	if full < 3*1024 {
+		t.Errorf("expected at least %d samples, got %d", 3*1024, full)
+	}
+	// The samples before the edit list start are dropped.
+	if trimmed := decode("delay", 1000); trimmed != full-1000 {
+		t.Errorf("expected %d samples with delay, got %d", full-1000, trimmed)
+	}
+}
+
+// TestDecodeTs decodes a transport stream through ts.Demuxer.
+func TestDecodeTs(t *testing.T) {
+	var stream bytes.Buffer
+	muxer, err := ts.NewMuxer(&stream, nil)
+	if err != nil {
+		t.Fatalf("NewMuxer failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		for _, frame := range [][]byte{AAC0, AAC1, AAC2} {
+			if _, err = muxer.Write(frame); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+	}
+
+	// Drop a packet of the fourth frame.
+	packets := stream.Bytes()
+	var damaged []byte
+	audio := 0
+	for i := 0; i < len(packets); i += ts.PacketSize {
+		p := packets[i : i+ts.PacketSize]
+		if int(p[1]&0x1F)<<8|int(p[2]) == ts.DefaultPID && p[1]&0x40 != 0 {
+			audio++
+			if audio == 4 {
+				continue
+			}
+		}
+		damaged = append(damaged, p...)
+	}
+
+	for _, in := range [][]byte{packets, damaged} {
+		demuxer, err := ts.NewDemuxer(bytes.NewReader(in), nil)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		r, err := NewDemuxDecodeReader(demuxer, &DecoderConfig{TransportFmt: TtMp4Adts})
+		if err != nil {
+			t.Fatalf("NewDemuxDecodeReader failed: %v", err)
+		}
+		pcm, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		// The lost frame is concealed, so both give all 12 frames.
+		if len(pcm) < 12*1024*4 {
+			t.Errorf("expected at least %d PCM bytes, got %d", 12*1024*4, len(pcm))
+		}
+	}
+}
+
+// TestDecodeFlv decodes FLV audio tags through flv.Demuxer.
+func TestDecodeFlv(t *testing.T) {
+	var stream bytes.Buffer
+	muxer, err := flv.NewMuxer(&stream, []byte{0x12, 0x10})
+	if err != nil {
+		t.Fatalf("NewMuxer failed: %v", err)
+	}
+	for _, frame := range [][]byte{AAC0, AAC1, AAC2} {
+		if err = muxer.WriteAU(frame[7:]); err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+	}
+
+	demuxer, err := flv.NewDemuxer(&stream)
+	if err != nil {
+		t.Fatalf("NewDemuxer failed: %v", err)
+	}
+	r, err := NewDemuxDecodeReader(demuxer, nil)
+	if err != nil {
+		t.Fatalf("NewDemuxDecodeReader failed: %v", err)
+	}
+	defer r.Close()
+	pcm, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(pcm) < 3*1024*4 {
+		t.Errorf("expected at least %d PCM bytes, got %d", 3*1024*4, len(pcm))
+	}
+	info, err := r.StreamInfo()
+	if err != nil || info.SampleRate != 44100 || info.NumChannels != 2 {
+		t.Errorf("unexpected stream info %+v (%v)", info, err)
+	}
+}
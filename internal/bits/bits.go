@@ -0,0 +1,153 @@
+// Package bits reads and writes MSB-first bit streams.
+package bits
+
+import "errors"
+
+// ErrShort is returned when reading past the end of the data.
+var ErrShort = errors.New("bits: read past end of data")
+
+// Reader reads bits from a byte slice, most significant bit first.
+// Errors are sticky: once a read fails, all further reads return 0 and Err
+// reports the failure.
+type Reader struct {
+	data []byte
+	pos  int
+	end  int
+	err  error
+}
+
+// NewReader returns a Reader over data.
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data, end: len(data) * 8}
+}
+
+// Read reads n bits (n <= 64) as an unsigned value.
+func (r *Reader) Read(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if n < 0 || n > 64 || r.pos+n > r.end {
+		r.err = ErrShort
+		return 0
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		b := r.data[r.pos>>3] >> (7 - uint(r.pos&7)) & 1
+		v = v<<1 | uint64(b)
+		r.pos++
+	}
+	return v
+}
+
+// Peek returns the next n bits (n <= 64) without consuming them, or 0 if
+// fewer than n bits are left. Unlike Read, it does not set Err.
+func (r *Reader) Peek(n int) uint64 {
+	if r.err != nil || n < 0 || n > 64 || r.pos+n > r.end {
+		return 0
+	}
+	pos := r.pos
+	v := r.Read(n)
+	r.pos = pos
+	return v
+}
+
+// ReadInt reads n bits as an int.
+func (r *Reader) ReadInt(n int) int {
+	return int(r.Read(n))
+}
+
+// ReadBool reads one bit.
+func (r *Reader) ReadBool() bool {
+	return r.Read(1) != 0
+}
+
+// Skip skips n bits.
+func (r *Reader) Skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 || r.pos+n > r.end {
+		r.err = ErrShort
+		return
+	}
+	r.pos += n
+}
+
+// ByteAlign skips to the next byte boundary relative to the start of the data.
+func (r *Reader) ByteAlign() {
+	if r.pos&7 != 0 {
+		r.Skip(8 - r.pos&7)
+	}
+}
+
+// Pos returns the number of bits consumed.
+func (r *Reader) Pos() int {
+	return r.pos
+}
+
+// Left returns the number of bits not yet consumed.
+func (r *Reader) Left() int {
+	return r.end - r.pos
+}
+
+// Err returns the first error encountered.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Writer writes bits to a growing byte slice, most significant bit first.
+type Writer struct {
+	data []byte
+	n    int
+}
+
+// Write writes the n (n <= 64) least significant bits of v.
+func (w *Writer) Write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n&7 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			w.data[w.n>>3] |= 0x80 >> uint(w.n&7)
+		}
+		w.n++
+	}
+}
+
+// WriteInt writes the n least significant bits of v.
+func (w *Writer) WriteInt(v int, n int) {
+	w.Write(uint64(v), n)
+}
+
+// WriteBool writes one bit.
+func (w *Writer) WriteBool(b bool) {
+	if b {
+		w.Write(1, 1)
+	} else {
+		w.Write(0, 1)
+	}
+}
+
+// WriteBits writes the first n bits of data.
+func (w *Writer) WriteBits(data []byte, n int) {
+	for i := 0; i < n; i++ {
+		w.Write(uint64(data[i>>3]>>(7-uint(i&7))&1), 1)
+	}
+}
+
+// ByteAlign pads with zero bits up to the next byte boundary.
+func (w *Writer) ByteAlign() {
+	if w.n&7 != 0 {
+		w.Write(0, 8-w.n&7)
+	}
+}
+
+// Len returns the number of bits written.
+func (w *Writer) Len() int {
+	return w.n
+}
+
+// Bytes returns the written data, with the last byte padded with zero bits.
+func (w *Writer) Bytes() []byte {
+	return w.data
+}
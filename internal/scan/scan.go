@@ -0,0 +1,145 @@
+// Package scan finds frames starting with a syncword in a byte stream, for the
+// ADTS and LOAS scanners.
+package scan
+
+import "io"
+
+const readSize = 4096
+
+// Scanner buffers a stream and finds the syncwords in it. Data that does not
+// belong to a valid frame is skipped and the scanner resynchronizes on the
+// next syncword. While resynchronizing, a frame is only accepted when it is
+// followed by another syncword or the end of the stream.
+type Scanner struct {
+	r       io.Reader
+	isSync  func(b []byte) bool
+	buf     []byte
+	rerr    error
+	skipped int64
+	synced  bool
+}
+
+// New returns a Scanner reading from r. isSync reports whether b, which has
+// at least two bytes, starts with a syncword.
+func New(r io.Reader, isSync func(b []byte) bool) *Scanner {
+	return &Scanner{
+		r:      r,
+		isSync: isSync,
+		buf:    make([]byte, 0, readSize),
+	}
+}
+
+// Bytes returns the buffered data, which starts at the syncword after Sync.
+func (s *Scanner) Bytes() []byte {
+	return s.buf
+}
+
+// Sync skips to the next syncword and buffers at least n bytes from it. It
+// returns false at the end of the stream or on a read error.
+func (s *Scanner) Sync(n int) bool {
+	for {
+		if !s.Fill(n) {
+			s.discard(len(s.buf))
+			return false
+		}
+
+		idx := Next(s.buf, s.isSync)
+		if idx != 0 {
+			s.synced = false
+		}
+		s.discard(idx)
+		if len(s.buf) < 2 {
+			continue
+		}
+		if !s.Fill(n) {
+			s.discard(len(s.buf))
+			return false
+		}
+		return true
+	}
+}
+
+// Next returns the offset of the first syncword in b. If there is none, it
+// returns the offset of the last byte, or 0 if b is empty.
+func Next(b []byte, isSync func(b []byte) bool) int {
+	for i := 0; i+1 < len(b); i++ {
+		if isSync(b[i:]) {
+			return i
+		}
+	}
+	// Keep the last byte, it may be the first half of a syncword.
+	return max(len(b)-1, 0)
+}
+
+// Accept reads the frame of n bytes at the syncword and the start of the next
+// one. It reports whether the frame is complete and, while resynchronizing,
+// followed by another syncword or the end of the stream. Otherwise the
+// syncword is dropped with Resync.
+func (s *Scanner) Accept(n int) bool {
+	s.Fill(n + 2)
+	if len(s.buf) < n || !s.synced && len(s.buf) >= n+2 && !s.isSync(s.buf[n:]) {
+		s.Resync()
+		return false
+	}
+	return true
+}
+
+// Consume removes an accepted frame of n bytes.
+func (s *Scanner) Consume(n int) {
+	s.buf = s.buf[n:]
+	s.synced = true
+}
+
+// Skip removes an accepted frame of n bytes that is not returned to the
+// caller, counting it as skipped.
+func (s *Scanner) Skip(n int) {
+	s.discard(n)
+	s.synced = true
+}
+
+// Resync drops the first byte of a rejected frame.
+func (s *Scanner) Resync() {
+	s.synced = false
+	s.discard(1)
+}
+
+// Err returns the first read error other than io.EOF.
+func (s *Scanner) Err() error {
+	if s.rerr == io.EOF {
+		return nil
+	}
+	return s.rerr
+}
+
+// Skipped returns the number of bytes discarded while searching for frames.
+func (s *Scanner) Skipped() int64 {
+	return s.skipped
+}
+
+// Fill reads until at least n bytes are buffered. It reports whether n bytes are available.
+func (s *Scanner) Fill(n int) bool {
+	for len(s.buf) < n {
+		if s.rerr != nil {
+			return false
+		}
+		if cap(s.buf)-len(s.buf) < readSize {
+			buf := make([]byte, len(s.buf), 2*len(s.buf)+readSize)
+			copy(buf, s.buf)
+			s.buf = buf
+		}
+		m, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+m]
+		if err != nil {
+			s.rerr = err
+		}
+	}
+	return true
+}
+
+func (s *Scanner) discard(n int) {
+	if n <= 0 {
+		return
+	}
+	s.buf = s.buf[n:]
+	s.skipped += int64(n)
+}
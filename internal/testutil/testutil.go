@@ -0,0 +1,56 @@
+// Package testutil holds the fixtures shared by the tests of the container
+// packages.
+package testutil
+
+import (
+	"bytes"
+	"io"
+)
+
+// File is an in-memory io.WriteSeeker.
+type File struct {
+	// Bytes written so far.
+	B   []byte
+	pos int
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if end := f.pos + len(p); end > len(f.B) {
+		f.B = append(f.B, make([]byte, end-len(f.B))...)
+	}
+	copy(f.B[f.pos:], p)
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = int(offset)
+	case io.SeekCurrent:
+		f.pos += int(offset)
+	case io.SeekEnd:
+		f.pos = len(f.B) + int(offset)
+	}
+	return int64(f.pos), nil
+}
+
+// Frames returns n access units, the i-th of size+i*step bytes filled with
+// i+1, so that each one is told apart by its size and content.
+func Frames(n, size, step int) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		frames[i] = bytes.Repeat([]byte{byte(i + 1)}, size+i*step)
+	}
+	return frames
+}
+
+// AUs returns n access units of 200 to 206 bytes, about the size of
+// 128 kbit/s frames, for tests that need many of them.
+func AUs(n int) [][]byte {
+	aus := make([][]byte, n)
+	for i := range aus {
+		aus[i] = bytes.Repeat([]byte{byte(i + 1)}, 200+i%7)
+	}
+	return aus
+}
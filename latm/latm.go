@@ -0,0 +1,492 @@
+// Package latm parses and writes MPEG-4 LATM (Low-overhead Audio Transport
+// Multiplex) and LOAS (Low Overhead Audio Stream) as produced by an encoder
+// configured with TtMp4LatmMcp0, TtMp4LatmMcp1 or TtMp4Loas.
+package latm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/internal/bits"
+)
+
+// Transport selects how the AudioMuxElements are carried. The values match
+// fdkaac.TransportType.
+type Transport int
+
+const (
+	// LATM with the StreamMuxConfig in-band.
+	TransportLatmMcp1 Transport = 6
+	// LATM with the StreamMuxConfig out-of-band, e.g. in the SDP.
+	TransportLatmMcp0 Transport = 7
+	// AudioSyncStream, LATM with in-band StreamMuxConfig and a sync layer.
+	TransportLoas Transport = 10
+)
+
+const (
+	// Size of the AudioSyncStream header.
+	SyncHeaderSize = 3
+	// Maximum value of the 13 bit audioMuxLengthBytes field.
+	MaxMuxLength = 0x1FFF
+	// latmBufferFullness value signaling a variable bitrate stream.
+	BufferFullnessVBR = 0xFF
+)
+
+var (
+	ErrShort       = errors.New("latm: data is truncated")
+	ErrNoSync      = errors.New("latm: syncword not found")
+	ErrInvalid     = errors.New("latm: invalid data")
+	ErrUnsupported = errors.New("latm: unsupported data")
+	// An AudioMuxElement refers to a StreamMuxConfig that has not been received yet.
+	ErrNoConfig = errors.New("latm: no StreamMuxConfig")
+)
+
+// StreamMuxConfig describes the layout of the payloads in the AudioMuxElements.
+// Only streams with allStreamsSameTimeFraming set are supported.
+type StreamMuxConfig struct {
+	// AudioMuxVersion, 0 or 1.
+	AudioMuxVersion int
+	// taraBufferFullness, AudioMuxVersion 1 only.
+	TaraBufferFullness int
+	// numSubFrames, one less than the number of payloads per stream in an AudioMuxElement.
+	NumSubFrames int
+	// Streams ordered by program and layer.
+	Streams []Stream
+	// Other data of OtherDataLenBits bits follows the payloads.
+	OtherDataPresent bool
+	OtherDataLenBits int
+	// CRC of the config, written as is.
+	CrcCheckPresent bool
+	CrcCheckSum     uint8
+}
+
+// Stream is one layer of one program of a StreamMuxConfig.
+type Stream struct {
+	Program int
+	Layer   int
+	// Audio config, shared with the previous stream when both use the same.
+	Config *asc.AudioSpecificConfig
+	// frameLengthType, 0 (variable payload length) and 1 (fixed) are supported.
+	FrameLengthType int
+	// latmBufferFullness for frameLengthType 0, BufferFullnessVBR for variable bitrate.
+	LatmBufferFullness int
+	// frameLength for frameLengthType 1, the payload has FrameLength+20 bytes.
+	FrameLength int
+}
+
+// NewStreamMuxConfig returns a config with one stream of variable length payloads.
+func NewStreamMuxConfig(config *asc.AudioSpecificConfig, audioMuxVersion int, numSubFrames int) *StreamMuxConfig {
+	return &StreamMuxConfig{
+		AudioMuxVersion: audioMuxVersion,
+		NumSubFrames:    numSubFrames,
+		Streams: []Stream{{
+			Config:             config,
+			LatmBufferFullness: BufferFullnessVBR,
+		}},
+	}
+}
+
+// Payload is the payload of one stream in one sub frame.
+type Payload struct {
+	// Index into StreamMuxConfig.Streams.
+	Stream int
+	// Audio config of the stream.
+	Config *asc.AudioSpecificConfig
+	// The access unit.
+	Data []byte
+}
+
+// AudioMuxElement is a parsed AudioMuxElement.
+type AudioMuxElement struct {
+	// Config in effect for the element.
+	Config *StreamMuxConfig
+	// The element carried a StreamMuxConfig in-band.
+	ConfigPresent bool
+	// Payloads in bitstream order, sub frame by sub frame.
+	Payloads []Payload
+	// Other data, OtherDataLenBits bits padded to a whole byte.
+	OtherData []byte
+}
+
+// ParseStreamMuxConfig parses a byte aligned StreamMuxConfig, e.g. the config
+// parameter of an RTP MP4A-LATM stream.
+func ParseStreamMuxConfig(b []byte) (*StreamMuxConfig, error) {
+	return parseStreamMuxConfig(bits.NewReader(b), b)
+}
+
+func parseStreamMuxConfig(r *bits.Reader, b []byte) (*StreamMuxConfig, error) {
+	c := &StreamMuxConfig{}
+	c.AudioMuxVersion = r.ReadInt(1)
+	if c.AudioMuxVersion == 1 && r.ReadBool() {
+		return nil, fmt.Errorf("%w: audioMuxVersionA 1", ErrUnsupported)
+	}
+	if c.AudioMuxVersion == 1 {
+		c.TaraBufferFullness = readValue(r)
+	}
+	if !r.ReadBool() {
+		return nil, fmt.Errorf("%w: allStreamsSameTimeFraming 0", ErrUnsupported)
+	}
+	c.NumSubFrames = r.ReadInt(6)
+
+	numProgram := r.ReadInt(4)
+	for prog := 0; prog <= numProgram; prog++ {
+		numLayer := r.ReadInt(3)
+		for lay := 0; lay <= numLayer; lay++ {
+			s := Stream{Program: prog, Layer: lay}
+			if (prog == 0 && lay == 0) || !r.ReadBool() {
+				config, err := readConfig(r, b, c.AudioMuxVersion)
+				if err != nil {
+					return nil, err
+				}
+				s.Config = config
+			} else {
+				s.Config = c.Streams[len(c.Streams)-1].Config
+			}
+
+			s.FrameLengthType = r.ReadInt(3)
+			switch s.FrameLengthType {
+			case 0:
+				s.LatmBufferFullness = r.ReadInt(8)
+			case 1:
+				s.FrameLength = r.ReadInt(9)
+			default:
+				return nil, fmt.Errorf("%w: frameLengthType %d", ErrUnsupported, s.FrameLengthType)
+			}
+			c.Streams = append(c.Streams, s)
+		}
+	}
+
+	c.OtherDataPresent = r.ReadBool()
+	if c.OtherDataPresent {
+		if c.AudioMuxVersion == 1 {
+			c.OtherDataLenBits = readValue(r)
+		} else {
+			for {
+				esc := r.ReadBool()
+				c.OtherDataLenBits = c.OtherDataLenBits<<8 | r.ReadInt(8)
+				if !esc || r.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+	c.CrcCheckPresent = r.ReadBool()
+	if c.CrcCheckPresent {
+		c.CrcCheckSum = uint8(r.Read(8))
+	}
+	if r.Err() != nil {
+		return nil, ErrShort
+	}
+	return c, nil
+}
+
+// readConfig reads an AudioSpecificConfig, which is only length prefixed for AudioMuxVersion 1.
+func readConfig(r *bits.Reader, b []byte, audioMuxVersion int) (*asc.AudioSpecificConfig, error) {
+	length := -1
+	if audioMuxVersion == 1 {
+		length = readValue(r)
+	}
+	if r.Err() != nil {
+		return nil, ErrShort
+	}
+	config, n, err := asc.ParseBits(b, r.Pos(), length)
+	if err != nil {
+		return nil, err
+	}
+	if length >= 0 {
+		// Skip the fill bits.
+		n = length
+	}
+	r.Skip(n)
+	return config, nil
+}
+
+// Marshal returns the StreamMuxConfig padded to a whole byte.
+func (c *StreamMuxConfig) Marshal() ([]byte, error) {
+	w := &bits.Writer{}
+	if err := c.marshal(w); err != nil {
+		return nil, err
+	}
+	w.ByteAlign()
+	return w.Bytes(), nil
+}
+
+func (c *StreamMuxConfig) validate() error {
+	if c.AudioMuxVersion != 0 && c.AudioMuxVersion != 1 {
+		return fmt.Errorf("%w: AudioMuxVersion %d", ErrUnsupported, c.AudioMuxVersion)
+	}
+	if c.NumSubFrames < 0 || c.NumSubFrames > 63 {
+		return fmt.Errorf("%w: numSubFrames %d", ErrInvalid, c.NumSubFrames)
+	}
+	if len(c.Streams) == 0 {
+		return fmt.Errorf("%w: no streams", ErrInvalid)
+	}
+	prog, lay := 0, 0
+	for i, s := range c.Streams {
+		if i > 0 {
+			if s.Program == prog {
+				lay++
+			} else {
+				prog++
+				lay = 0
+			}
+		}
+		if s.Program != prog || s.Layer != lay || prog > 15 || lay > 7 {
+			return fmt.Errorf("%w: stream %d is program %d layer %d", ErrInvalid, i, s.Program, s.Layer)
+		}
+		if s.Config == nil {
+			return fmt.Errorf("%w: stream %d has no config", ErrInvalid, i)
+		}
+		if s.FrameLengthType != 0 && s.FrameLengthType != 1 {
+			return fmt.Errorf("%w: frameLengthType %d", ErrUnsupported, s.FrameLengthType)
+		}
+	}
+	return nil
+}
+
+func (c *StreamMuxConfig) marshal(w *bits.Writer) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	w.WriteInt(c.AudioMuxVersion, 1)
+	if c.AudioMuxVersion == 1 {
+		w.WriteBool(false) // audioMuxVersionA
+		writeValue(w, c.TaraBufferFullness)
+	}
+	w.WriteBool(true) // allStreamsSameTimeFraming
+	w.WriteInt(c.NumSubFrames, 6)
+	w.WriteInt(c.Streams[len(c.Streams)-1].Program, 4)
+
+	for i, s := range c.Streams {
+		if s.Layer == 0 {
+			numLayer := 0
+			for _, t := range c.Streams[i:] {
+				if t.Program == s.Program {
+					numLayer = t.Layer
+				}
+			}
+			w.WriteInt(numLayer, 3)
+		}
+		sameConfig := i > 0 && s.Config == c.Streams[i-1].Config
+		if i > 0 {
+			w.WriteBool(sameConfig)
+		}
+		if !sameConfig {
+			if err := writeConfig(w, s.Config, c.AudioMuxVersion); err != nil {
+				return err
+			}
+		}
+
+		w.WriteInt(s.FrameLengthType, 3)
+		if s.FrameLengthType == 0 {
+			w.WriteInt(s.LatmBufferFullness, 8)
+		} else {
+			w.WriteInt(s.FrameLength, 9)
+		}
+	}
+
+	w.WriteBool(c.OtherDataPresent)
+	if c.OtherDataPresent {
+		if c.AudioMuxVersion == 1 {
+			writeValue(w, c.OtherDataLenBits)
+		} else {
+			n := 1
+			for c.OtherDataLenBits>>(8*n) != 0 {
+				n++
+			}
+			for i := n - 1; i >= 0; i-- {
+				w.WriteBool(i > 0)
+				w.WriteInt(c.OtherDataLenBits>>(8*i), 8)
+			}
+		}
+	}
+	w.WriteBool(c.CrcCheckPresent)
+	if c.CrcCheckPresent {
+		w.WriteInt(int(c.CrcCheckSum), 8)
+	}
+	return nil
+}
+
+func writeConfig(w *bits.Writer, config *asc.AudioSpecificConfig, audioMuxVersion int) error {
+	b, n, err := config.MarshalBits()
+	if err != nil {
+		return err
+	}
+	if audioMuxVersion == 1 {
+		writeValue(w, n)
+	}
+	w.WriteBits(b, n)
+	return nil
+}
+
+// readValue reads a LatmGetValue() value.
+func readValue(r *bits.Reader) int {
+	n := r.ReadInt(2)
+	v := 0
+	for i := 0; i <= n; i++ {
+		v = v<<8 | r.ReadInt(8)
+	}
+	return v
+}
+
+func writeValue(w *bits.Writer, v int) {
+	n := 0
+	for n < 3 && v>>(8*(n+1)) != 0 {
+		n++
+	}
+	w.WriteInt(n, 2)
+	for i := n; i >= 0; i-- {
+		w.WriteInt(v>>(8*i), 8)
+	}
+}
+
+// Demuxer parses AudioMuxElements and keeps track of the StreamMuxConfig.
+type Demuxer struct {
+	config       *StreamMuxConfig
+	configInBand bool
+}
+
+// NewDemuxer returns a Demuxer. If config is nil, the StreamMuxConfig is
+// expected in-band as for TransportLatmMcp1 and TransportLoas, otherwise
+// config is used for all elements as for TransportLatmMcp0.
+func NewDemuxer(config *StreamMuxConfig) *Demuxer {
+	return &Demuxer{
+		config:       config,
+		configInBand: config == nil,
+	}
+}
+
+// Config returns the current StreamMuxConfig, nil if none has been received.
+func (d *Demuxer) Config() *StreamMuxConfig {
+	return d.config
+}
+
+// Parse parses one AudioMuxElement. The payloads refer to b.
+func (d *Demuxer) Parse(b []byte) (*AudioMuxElement, error) {
+	r := bits.NewReader(b)
+	e := &AudioMuxElement{}
+
+	if d.configInBand && !r.ReadBool() {
+		config, err := parseStreamMuxConfig(r, b)
+		if err != nil {
+			return nil, err
+		}
+		d.config = config
+		e.ConfigPresent = true
+	}
+	if d.config == nil {
+		return nil, ErrNoConfig
+	}
+	c := d.config
+	e.Config = c
+
+	lengths := make([]int, len(c.Streams))
+	for i := 0; i <= c.NumSubFrames; i++ {
+		// PayloadLengthInfo()
+		for j, s := range c.Streams {
+			if s.FrameLengthType == 1 {
+				lengths[j] = s.FrameLength + 20
+				continue
+			}
+			lengths[j] = 0
+			for {
+				tmp := r.ReadInt(8)
+				lengths[j] += tmp
+				if tmp != 255 || r.Err() != nil {
+					break
+				}
+			}
+		}
+		// PayloadMux()
+		for j, s := range c.Streams {
+			if r.Err() != nil || r.Left() < lengths[j]*8 {
+				return nil, ErrShort
+			}
+			e.Payloads = append(e.Payloads, Payload{
+				Stream: j,
+				Config: s.Config,
+				Data:   readBytes(r, b, lengths[j]),
+			})
+		}
+	}
+
+	if c.OtherDataPresent {
+		if r.Left() < c.OtherDataLenBits {
+			return nil, ErrShort
+		}
+		e.OtherData = make([]byte, (c.OtherDataLenBits+7)/8)
+		for i := 0; i < c.OtherDataLenBits; i++ {
+			if r.ReadBool() {
+				e.OtherData[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+	}
+	if r.Err() != nil {
+		return nil, ErrShort
+	}
+	return e, nil
+}
+
+// readBytes reads n bytes, referring to b when the reader is byte aligned.
+func readBytes(r *bits.Reader, b []byte, n int) []byte {
+	if r.Pos()&7 == 0 {
+		p := b[r.Pos()/8 : r.Pos()/8+n]
+		r.Skip(n * 8)
+		return p
+	}
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(r.Read(8))
+	}
+	return p
+}
+
+// marshalElement writes an AudioMuxElement with the payloads of one element,
+// ordered sub frame by sub frame.
+func (c *StreamMuxConfig) marshalElement(w *bits.Writer, payloads [][]byte, otherData []byte,
+	configInBand, withConfig bool) error {
+	if len(payloads) != (c.NumSubFrames+1)*len(c.Streams) {
+		return fmt.Errorf("%w: %d payloads for %d sub frames of %d streams", ErrInvalid,
+			len(payloads), c.NumSubFrames+1, len(c.Streams))
+	}
+
+	if configInBand {
+		w.WriteBool(!withConfig) // useSameStreamMux
+		if withConfig {
+			if err := c.marshal(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i <= c.NumSubFrames; i++ {
+		sub := payloads[i*len(c.Streams) : (i+1)*len(c.Streams)]
+		for j, s := range c.Streams {
+			n := len(sub[j])
+			if s.FrameLengthType == 1 {
+				if n != s.FrameLength+20 {
+					return fmt.Errorf("%w: payload of %d bytes for frame length %d", ErrInvalid, n, s.FrameLength+20)
+				}
+				continue
+			}
+			for ; n >= 255; n -= 255 {
+				w.WriteInt(255, 8)
+			}
+			w.WriteInt(n, 8)
+		}
+		for _, p := range sub {
+			w.WriteBits(p, len(p)*8)
+		}
+	}
+
+	if c.OtherDataPresent {
+		if len(otherData)*8 < c.OtherDataLenBits {
+			return fmt.Errorf("%w: %d bytes of other data for %d bits", ErrInvalid, len(otherData), c.OtherDataLenBits)
+		}
+		w.WriteBits(otherData, c.OtherDataLenBits)
+	}
+	w.ByteAlign()
+	return nil
+}
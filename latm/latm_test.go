@@ -0,0 +1,267 @@
+package latm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+// StreamMuxConfig of LC 44.1 kHz mono, as signaled in SDP.
+var muxConfig0 = []byte{0x40, 0x00, 0x24, 0x10, 0x3F, 0xC0}
+
+func testConfig(t *testing.T) *asc.AudioSpecificConfig {
+	config, err := asc.Parse([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatalf("asc.Parse failed: %v", err)
+	}
+	return config
+}
+
+type elementWriter struct {
+	elements [][]byte
+}
+
+func (w *elementWriter) Write(p []byte) (int, error) {
+	w.elements = append(w.elements, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestStreamMuxConfig(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		c, err := ParseStreamMuxConfig(muxConfig0)
+		if err != nil {
+			t.Fatalf("ParseStreamMuxConfig failed: %v", err)
+		}
+		if c.AudioMuxVersion != 0 || c.NumSubFrames != 0 || len(c.Streams) != 1 {
+			t.Fatalf("unexpected config %+v", c)
+		}
+		s := c.Streams[0]
+		if s.Config.ObjectType != asc.AotAacLc || s.Config.SampleRate() != 44100 || s.Config.Channels() != 1 {
+			t.Errorf("unexpected audio config %+v", s.Config)
+		}
+		if s.FrameLengthType != 0 || s.LatmBufferFullness != BufferFullnessVBR {
+			t.Errorf("unexpected stream %+v", s)
+		}
+
+		b, err := c.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !bytes.Equal(b, muxConfig0) {
+			t.Errorf("round trip % x, expected % x", b, muxConfig0)
+		}
+	})
+
+	t.Run("AudioMuxVersion 1 round trip", func(t *testing.T) {
+		config := testConfig(t)
+		c := NewStreamMuxConfig(config, 1, 3)
+		c.TaraBufferFullness = 0xFF
+		c.Streams = append(c.Streams, Stream{Layer: 1, Config: config, FrameLengthType: 1, FrameLength: 300})
+		c.OtherDataPresent = true
+		c.OtherDataLenBits = 12
+		c.CrcCheckPresent = true
+		c.CrcCheckSum = 0x5A
+
+		b, err := c.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		c2, err := ParseStreamMuxConfig(b)
+		if err != nil {
+			t.Fatalf("ParseStreamMuxConfig failed: %v", err)
+		}
+		if c2.AudioMuxVersion != 1 || c2.TaraBufferFullness != 0xFF || c2.NumSubFrames != 3 ||
+			len(c2.Streams) != 2 || c2.Streams[1].FrameLength != 300 || c2.Streams[0].Config != c2.Streams[1].Config ||
+			c2.OtherDataLenBits != 12 || c2.CrcCheckSum != 0x5A {
+			t.Errorf("unexpected config %+v", c2)
+		}
+		b2, _ := c2.Marshal()
+		if !bytes.Equal(b, b2) {
+			t.Errorf("round trip % x, expected % x", b2, b)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := ParseStreamMuxConfig(muxConfig0[:3]); err == nil {
+			t.Error("expected error for truncated config")
+		}
+		// allStreamsSameTimeFraming 0.
+		if _, err := ParseStreamMuxConfig([]byte{0x00, 0x00}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+		c := &StreamMuxConfig{Streams: []Stream{{Program: 1}}}
+		if _, err := c.Marshal(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+}
+
+func TestMuxer(t *testing.T) {
+	t.Run("LOAS round trip", func(t *testing.T) {
+		frames := testutil.Frames(9, 100, 97)
+		var buf bytes.Buffer
+		m, err := NewMuxer(&buf, testConfig(t), &MuxerConfig{
+			Transport:    TransportLoas,
+			HeaderPeriod: 3,
+			SubFrames:    2,
+		})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, f := range frames {
+			if err := m.WriteFrame(f); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+		}
+		if err := m.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+
+		s := NewScanner(bytes.NewReader(append([]byte{0x56, 0x00, 0x12}, buf.Bytes()...)))
+		var got [][]byte
+		elements, configs := 0, 0
+		for s.Scan() {
+			e := s.Element()
+			elements++
+			if e.ConfigPresent {
+				configs++
+			}
+			for _, p := range e.Payloads {
+				got = append(got, p.Data)
+				if p.Config.SampleRate() != 44100 {
+					t.Errorf("unexpected payload config %+v", p.Config)
+				}
+			}
+		}
+		if s.Err() != nil {
+			t.Fatalf("Scan failed: %v", s.Err())
+		}
+		if s.Skipped() != 3 {
+			t.Errorf("expected 3 bytes skipped, got %d", s.Skipped())
+		}
+		// Elements 0 and 3 carry the config, as does the shorter last element.
+		if elements != 5 || configs != 3 {
+			t.Errorf("expected 5 elements with 3 configs, got %d with %d", elements, configs)
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("expected %d payloads, got %d", len(frames), len(got))
+		}
+		for i := range frames {
+			if !bytes.Equal(got[i], frames[i]) {
+				t.Errorf("payload %d differs", i)
+			}
+		}
+	})
+
+	t.Run("LATM", func(t *testing.T) {
+		frames := testutil.Frames(4, 100, 97)
+		for _, tt := range []Transport{TransportLatmMcp0, TransportLatmMcp1} {
+			for _, version := range []int{0, 1} {
+				w := &elementWriter{}
+				m, err := NewMuxer(w, testConfig(t), &MuxerConfig{Transport: tt, AudioMuxVersion: version})
+				if err != nil {
+					t.Fatalf("NewMuxer failed: %v", err)
+				}
+				for _, f := range frames {
+					if err := m.WriteFrame(f); err != nil {
+						t.Fatalf("WriteFrame failed: %v", err)
+					}
+				}
+
+				var config *StreamMuxConfig
+				if tt == TransportLatmMcp0 {
+					b, err := m.StreamMuxConfig().Marshal()
+					if err != nil {
+						t.Fatalf("Marshal failed: %v", err)
+					}
+					if config, err = ParseStreamMuxConfig(b); err != nil {
+						t.Fatalf("ParseStreamMuxConfig failed: %v", err)
+					}
+				}
+				d := NewDemuxer(config)
+				if len(w.elements) != len(frames) {
+					t.Fatalf("expected %d elements, got %d", len(frames), len(w.elements))
+				}
+				for i, b := range w.elements {
+					e, err := d.Parse(b)
+					if err != nil {
+						t.Fatalf("transport %d version %d: Parse failed: %v", tt, version, err)
+					}
+					if len(e.Payloads) != 1 || !bytes.Equal(e.Payloads[0].Data, frames[i]) {
+						t.Errorf("transport %d version %d: payload %d differs", tt, version, i)
+					}
+					if d.Config().AudioMuxVersion != version {
+						t.Errorf("expected AudioMuxVersion %d, got %d", version, d.Config().AudioMuxVersion)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Join mid stream", func(t *testing.T) {
+		w := &elementWriter{}
+		m, err := NewMuxer(w, testConfig(t), &MuxerConfig{HeaderPeriod: 2})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, f := range testutil.Frames(4, 100, 97) {
+			m.WriteFrame(f)
+		}
+
+		// Drop the first element, the second has no config.
+		s := NewScanner(bytes.NewReader(bytes.Join(w.elements[1:], nil)))
+		n := 0
+		for s.Scan() {
+			n++
+		}
+		if n != 2 || s.Skipped() != int64(len(w.elements[1])) {
+			t.Errorf("expected 2 elements and %d bytes skipped, got %d and %d", len(w.elements[1]), n, s.Skipped())
+		}
+	})
+
+	t.Run("Reused buffer", func(t *testing.T) {
+		frames := testutil.Frames(6, 100, 97)
+		w := &elementWriter{}
+		m, err := NewMuxer(w, testConfig(t), &MuxerConfig{SubFrames: 3})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		// The same buffer for each access unit, as with the Encode output.
+		buf := make([]byte, 1024)
+		for _, f := range frames {
+			if err := m.WriteFrame(buf[:copy(buf, f)]); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+		}
+
+		s := NewScanner(bytes.NewReader(bytes.Join(w.elements, nil)))
+		var got [][]byte
+		for s.Scan() {
+			for _, p := range s.Element().Payloads {
+				got = append(got, p.Data)
+			}
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("expected %d payloads, got %d", len(frames), len(got))
+		}
+		for i := range frames {
+			if !bytes.Equal(got[i], frames[i]) {
+				t.Errorf("payload %d differs", i)
+			}
+		}
+	})
+
+	t.Run("Flush MCP0", func(t *testing.T) {
+		m, err := NewMuxer(&elementWriter{}, testConfig(t), &MuxerConfig{Transport: TransportLatmMcp0, SubFrames: 2})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		m.WriteFrame([]byte{1, 2, 3})
+		if err := m.Flush(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package latm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/internal/bits"
+)
+
+// Default number of AudioMuxElements between in-band StreamMuxConfigs.
+const DefaultHeaderPeriod = 10
+
+// MuxerConfig configures a Muxer, the fields match those of fdkaac.EncoderConfig.
+type MuxerConfig struct {
+	// Transport type, TransportLoas if 0.
+	Transport Transport
+	// AudioMuxVersion, 0 or 1.
+	AudioMuxVersion int
+	// Number of AudioMuxElements between in-band StreamMuxConfigs (default DefaultHeaderPeriod).
+	HeaderPeriod int
+	// Number of access units per AudioMuxElement (default 1).
+	SubFrames int
+}
+
+// Muxer writes access units as LATM AudioMuxElements or as a LOAS AudioSyncStream.
+type Muxer struct {
+	w            io.Writer
+	config       *StreamMuxConfig
+	transport    Transport
+	headerPeriod int
+	count        int
+	pending      [][]byte
+}
+
+// NewMuxer returns a Muxer writing the access units of the stream described by
+// config to w. For LATM each AudioMuxElement is passed to w in a single Write
+// call, so that w can packetize it.
+func NewMuxer(w io.Writer, config *asc.AudioSpecificConfig, mc *MuxerConfig) (*Muxer, error) {
+	m := &Muxer{
+		w:            w,
+		transport:    TransportLoas,
+		headerPeriod: DefaultHeaderPeriod,
+	}
+	subFrames := 1
+	audioMuxVersion := 0
+	if mc != nil {
+		if mc.Transport != 0 {
+			m.transport = mc.Transport
+		}
+		if mc.HeaderPeriod > 0 {
+			m.headerPeriod = mc.HeaderPeriod
+		}
+		if mc.SubFrames > 0 {
+			subFrames = mc.SubFrames
+		}
+		audioMuxVersion = mc.AudioMuxVersion
+	}
+	if m.transport != TransportLoas && m.transport != TransportLatmMcp0 && m.transport != TransportLatmMcp1 {
+		return nil, fmt.Errorf("%w: transport %d", ErrUnsupported, m.transport)
+	}
+
+	m.config = NewStreamMuxConfig(config, audioMuxVersion, subFrames-1)
+	if _, err := m.config.Marshal(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StreamMuxConfig returns the config, which must be sent out-of-band for TransportLatmMcp0.
+func (m *Muxer) StreamMuxConfig() *StreamMuxConfig {
+	return m.config
+}
+
+// WriteFrame adds an access unit. An AudioMuxElement is written once
+// SubFrames access units have been added. au is copied, so the caller may
+// reuse it.
+func (m *Muxer) WriteFrame(au []byte) error {
+	m.pending = append(m.pending, append([]byte(nil), au...))
+	if len(m.pending) <= m.config.NumSubFrames {
+		return nil
+	}
+	err := m.writeElement(m.config)
+	m.pending = m.pending[:0]
+	return err
+}
+
+// Flush writes the pending access units. With in-band StreamMuxConfig a
+// shorter last element is written with its own config; for TransportLatmMcp0
+// the number of access units must be a multiple of SubFrames.
+func (m *Muxer) Flush() error {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	defer func() { m.pending = m.pending[:0] }()
+
+	if m.transport == TransportLatmMcp0 {
+		return fmt.Errorf("%w: %d access units left for %d sub frames", ErrInvalid,
+			len(m.pending), m.config.NumSubFrames+1)
+	}
+	config := *m.config
+	config.NumSubFrames = len(m.pending) - 1
+	// Send the config with the next element, as the sub frame count changed.
+	m.count = 0
+	err := m.writeElement(&config)
+	m.count = 0
+	return err
+}
+
+func (m *Muxer) writeElement(config *StreamMuxConfig) error {
+	configInBand := m.transport != TransportLatmMcp0
+	withConfig := configInBand && m.count%m.headerPeriod == 0
+
+	w := &bits.Writer{}
+	if m.transport == TransportLoas {
+		// Placeholder for the sync header.
+		w.WriteInt(0, SyncHeaderSize*8)
+	}
+	if err := config.marshalElement(w, m.pending, nil, configInBand, withConfig); err != nil {
+		return err
+	}
+	b := w.Bytes()
+	if m.transport == TransportLoas {
+		n := len(b) - SyncHeaderSize
+		if n > MaxMuxLength {
+			return fmt.Errorf("%w: AudioMuxElement of %d bytes", ErrInvalid, n)
+		}
+		b[0] = 0x56
+		b[1] = 0xE0 | byte(n>>8)
+		b[2] = byte(n)
+	}
+	m.count++
+
+	_, err := m.w.Write(b)
+	return err
+}
@@ -0,0 +1,76 @@
+package latm
+
+import (
+	"errors"
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac/internal/scan"
+)
+
+// Scanner reads AudioMuxElements from a LOAS AudioSyncStream. Data that does
+// not belong to a valid element is skipped and the scanner resynchronizes on
+// the next syncword. While resynchronizing, an element is only accepted when
+// it is followed by another syncword or the end of the stream. Elements before
+// the first StreamMuxConfig are skipped.
+type Scanner struct {
+	s       *scan.Scanner
+	demux   *Demuxer
+	element *AudioMuxElement
+}
+
+// NewScanner returns a Scanner reading from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{
+		s:     scan.New(r, isSync),
+		demux: NewDemuxer(nil),
+	}
+}
+
+// Scan advances to the next element, which is then available through Element.
+// It returns false at the end of the stream or on a read error.
+func (s *Scanner) Scan() bool {
+	s.element = nil
+	for s.s.Sync(SyncHeaderSize) {
+		b := s.s.Bytes()
+		n := SyncHeaderSize + (int(b[1]&0x1F)<<8 | int(b[2]))
+		if !s.s.Accept(n) {
+			continue
+		}
+
+		data := append([]byte(nil), s.s.Bytes()[SyncHeaderSize:n]...)
+		e, err := s.demux.Parse(data)
+		if errors.Is(err, ErrNoConfig) {
+			s.s.Skip(n)
+			continue
+		}
+		if err != nil {
+			s.s.Resync()
+			continue
+		}
+
+		s.element = e
+		s.s.Consume(n)
+		return true
+	}
+	return false
+}
+
+// Element returns the element found by the last call to Scan.
+func (s *Scanner) Element() *AudioMuxElement {
+	return s.element
+}
+
+// Err returns the first read error other than io.EOF.
+func (s *Scanner) Err() error {
+	return s.s.Err()
+}
+
+// Skipped returns the number of bytes discarded while searching for elements.
+func (s *Scanner) Skipped() int64 {
+	return s.s.Skipped()
+}
+
+// isSync checks for the 11 bit syncword 0x2B7.
+func isSync(b []byte) bool {
+	return b[0] == 0x56 && b[1]&0xE0 == 0xE0
+}
@@ -0,0 +1,568 @@
+package mkv
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+// Maximum size of an element read into memory.
+const maxElementSize = 64 << 20
+
+// Track is an A_AAC track of a Matroska or WebM file.
+type Track struct {
+	// TrackNumber, the track of the blocks.
+	Number int
+	// AudioSpecificConfig from CodecPrivate, for Decoder.ConfigRaw.
+	Config []byte
+	// Parsed Config.
+	AudioConfig *asc.AudioSpecificConfig
+	// Duration of the decoded samples to discard at the start, usually the
+	// encoder delay.
+	CodecDelay time.Duration
+	// Duration to decode before the target of a seek.
+	SeekPreRoll time.Duration
+	// Duration of an access unit.
+	DefaultDuration time.Duration
+}
+
+// Sample is an access unit read from a track.
+type Sample struct {
+	Data []byte
+	// Presentation time, the block timestamp minus CodecDelay. It is negative
+	// for the access units of the encoder delay.
+	Timestamp time.Duration
+	// Duration of the access unit.
+	Duration time.Duration
+	// Duration of the decoded samples to discard at the end of this access
+	// unit, from the DiscardPadding of its block.
+	DiscardPadding time.Duration
+}
+
+type cue struct {
+	time time.Duration
+	pos  int64
+}
+
+// Demuxer reads the access units of an A_AAC track from a Matroska or WebM
+// file. Clusters of unknown size, as written for live streaming, are
+// supported.
+type Demuxer struct {
+	r           io.ReadSeeker
+	tracks      []*Track
+	track       *Track
+	scale       time.Duration
+	duration    time.Duration
+	segmentPos  int64
+	segmentEnd  int64
+	clusterPos  int64
+	cues        []cue
+	pos         int64
+	clusterTime int64
+	queue       []*Sample
+}
+
+// NewDemuxer reads the headers of the file read from r up to the first
+// cluster, and the Cues if the SeekHead points to them, and selects the
+// first A_AAC track.
+func NewDemuxer(r io.ReadSeeker) (*Demuxer, error) {
+	d := &Demuxer{
+		r:          r,
+		scale:      timestampScale,
+		segmentEnd: -1,
+	}
+	if err := d.readHeaders(); err != nil {
+		return nil, err
+	}
+	if len(d.tracks) == 0 {
+		return nil, ErrNoTrack
+	}
+	d.track = d.tracks[0]
+	d.pos = d.clusterPos
+	return d, nil
+}
+
+// Tracks returns the A_AAC tracks of the file.
+func (d *Demuxer) Tracks() []*Track {
+	return d.tracks
+}
+
+// Track returns the selected track.
+func (d *Demuxer) Track() *Track {
+	return d.track
+}
+
+// SelectTrack selects the track with the given TrackNumber and rewinds to
+// the first cluster.
+func (d *Demuxer) SelectTrack(number int) error {
+	for _, t := range d.tracks {
+		if t.Number == number {
+			d.track = t
+			d.pos = d.clusterPos
+			d.queue = nil
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: track %d", ErrNoTrack, number)
+}
+
+// Duration returns the duration of the segment from Info, or 0 if unknown.
+func (d *Demuxer) Duration() time.Duration {
+	return d.duration
+}
+
+// ReadSample reads the next access unit of the selected track, or returns io.EOF.
+func (d *Demuxer) ReadSample() (*Sample, error) {
+	for len(d.queue) == 0 {
+		if err := d.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	s := d.queue[0]
+	d.queue = d.queue[1:]
+	return s, nil
+}
+
+// SeekTime positions the demuxer for presentation time t, using the Cues to
+// find the cluster. As the MDCT overlaps with the previous frame, reading
+// starts one access unit early. The decoded output must be trimmed by the
+// returned number of samples per channel to start at t; it is exact up to the
+// rounding of the block timestamps.
+func (d *Demuxer) SeekTime(t time.Duration) (discard int64, err error) {
+	if t < 0 {
+		return 0, fmt.Errorf("%w: seek to %v", ErrInvalid, t)
+	}
+	tr := d.track
+	preRoll := max(tr.SeekPreRoll, tr.DefaultDuration)
+	d.pos = d.clusterPos
+	for _, c := range d.cues {
+		if c.time-tr.CodecDelay > t-preRoll {
+			break
+		}
+		d.pos = d.segmentPos + c.pos
+	}
+	d.queue = nil
+
+	var prev *Sample
+	for {
+		s, err := d.ReadSample()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if s.Timestamp+s.Duration <= t {
+			prev = s
+			continue
+		}
+		first := s
+		if prev != nil {
+			first = prev
+			d.queue = append([]*Sample{prev, s}, d.queue...)
+		} else {
+			d.queue = append([]*Sample{s}, d.queue...)
+		}
+		rate := int64(tr.AudioConfig.SampleRate())
+		return max(int64(t-first.Timestamp)*rate/int64(time.Second), 0), nil
+	}
+}
+
+// readHeader reads the ID and size of the element at pos.
+func (d *Demuxer) readHeader(pos int64) (id uint32, size uint64, unknown bool, hdrSize int64, err error) {
+	if _, err = d.r.Seek(pos, io.SeekStart); err != nil {
+		return
+	}
+	var hdr [12]byte
+	n, err := io.ReadFull(d.r, hdr[:])
+	if n == 0 {
+		return
+	}
+	id, idLen, ok := readID(hdr[:n])
+	if !ok {
+		return 0, 0, false, 0, fmt.Errorf("%w: element ID at %d", ErrInvalid, pos)
+	}
+	size, sizeLen, unknown, ok := readVint(hdr[idLen:n])
+	if !ok {
+		return 0, 0, false, 0, fmt.Errorf("%w: element size at %d", ErrInvalid, pos)
+	}
+	return id, size, unknown, int64(idLen + sizeLen), nil
+}
+
+// readPayload reads the payload of an element of known size.
+func (d *Demuxer) readPayload(pos int64, size uint64) ([]byte, error) {
+	if size > maxElementSize {
+		return nil, fmt.Errorf("%w: element of %d bytes", ErrUnsupported, size)
+	}
+	if _, err := d.r.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// readElement reads the element at pos, which must have a known size.
+func (d *Demuxer) readElement(pos int64) (uint32, []byte, error) {
+	id, size, unknown, hdrSize, err := d.readHeader(pos)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if unknown {
+		return 0, nil, fmt.Errorf("%w: element %X of unknown size", ErrUnsupported, id)
+	}
+	payload, err := d.readPayload(pos+hdrSize, size)
+	return id, payload, err
+}
+
+// readHeaders reads the EBML header and the level 1 elements in front of
+// the first cluster.
+func (d *Demuxer) readHeaders() error {
+	pos, err := d.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	id, size, unknown, hdrSize, err := d.readHeader(pos)
+	if err == io.EOF || err == nil && (id != idEBML || unknown) {
+		return fmt.Errorf("%w: no EBML header", ErrInvalid)
+	}
+	if err != nil {
+		return err
+	}
+	header, err := d.readPayload(pos+hdrSize, size)
+	if err != nil {
+		return err
+	}
+	r := &ebmlReader{b: header}
+	docType := DocTypeMatroska
+	for {
+		id, payload, ok := r.next()
+		if !ok {
+			break
+		}
+		if id == idDocType {
+			docType = string(payload)
+		}
+	}
+	if docType != DocTypeMatroska && docType != DocTypeWebM {
+		return fmt.Errorf("%w: DocType %q", ErrUnsupported, docType)
+	}
+	pos += hdrSize + int64(size)
+
+	id, size, unknown, hdrSize, err = d.readHeader(pos)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if id != idSegment {
+		return fmt.Errorf("%w: no Segment", ErrInvalid)
+	}
+	d.segmentPos = pos + hdrSize
+	if !unknown {
+		d.segmentEnd = d.segmentPos + int64(size)
+	}
+
+	seek := map[uint32]int64{}
+	seen := map[uint32]bool{}
+	pos = d.segmentPos
+	for d.segmentEnd < 0 || pos < d.segmentEnd {
+		id, size, unknown, hdrSize, err := d.readHeader(pos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if id == idCluster {
+			break
+		}
+		if unknown {
+			return fmt.Errorf("%w: element %X of unknown size", ErrUnsupported, id)
+		}
+		switch id {
+		case idSeekHead, idInfo, idTracks, idCues:
+			payload, err := d.readPayload(pos+hdrSize, size)
+			if err != nil {
+				return err
+			}
+			d.parseLevel1(id, payload, seek)
+			seen[id] = true
+		}
+		pos += hdrSize + int64(size)
+	}
+	d.clusterPos = pos
+
+	// Elements behind the clusters, usually the Cues.
+	for _, id := range []uint32{idInfo, idTracks, idCues} {
+		p, ok := seek[id]
+		if seen[id] || !ok {
+			continue
+		}
+		got, payload, err := d.readElement(d.segmentPos + p)
+		if err != nil {
+			return err
+		}
+		if got != id {
+			return fmt.Errorf("%w: SeekHead points to %X instead of %X", ErrInvalid, got, id)
+		}
+		d.parseLevel1(id, payload, seek)
+	}
+	return nil
+}
+
+// parseLevel1 parses a SeekHead, Info, Tracks or Cues element. The positions
+// of a SeekHead are added to seek.
+func (d *Demuxer) parseLevel1(id uint32, payload []byte, seek map[uint32]int64) {
+	r := &ebmlReader{b: payload}
+	switch id {
+	case idSeekHead:
+		for {
+			id, entry, ok := r.next()
+			if !ok {
+				return
+			}
+			if id != idSeek {
+				continue
+			}
+			var seekID uint32
+			var seekPos int64 = -1
+			er := &ebmlReader{b: entry}
+			for {
+				id, v, ok := er.next()
+				if !ok {
+					break
+				}
+				switch id {
+				case idSeekID:
+					seekID = uint32(readUint(v))
+				case idSeekPosition:
+					seekPos = int64(readUint(v))
+				}
+			}
+			if _, ok := seek[seekID]; !ok && seekPos >= 0 {
+				seek[seekID] = seekPos
+			}
+		}
+	case idInfo:
+		var duration float64
+		for {
+			id, v, ok := r.next()
+			if !ok {
+				break
+			}
+			switch id {
+			case idTimestampScale:
+				if scale := readUint(v); scale > 0 && scale <= math.MaxInt32 {
+					d.scale = time.Duration(scale)
+				}
+			case idDuration:
+				duration = readFloat(v)
+			}
+		}
+		d.duration = time.Duration(duration * float64(d.scale))
+	case idTracks:
+		for {
+			id, entry, ok := r.next()
+			if !ok {
+				return
+			}
+			if id != idTrackEntry {
+				continue
+			}
+			if t := parseTrackEntry(entry); t != nil {
+				d.tracks = append(d.tracks, t)
+			}
+		}
+	case idCues:
+		d.cues = d.cues[:0]
+		for {
+			id, point, ok := r.next()
+			if !ok {
+				return
+			}
+			if id != idCuePoint {
+				continue
+			}
+			c := cue{pos: -1}
+			pr := &ebmlReader{b: point}
+			for {
+				id, v, ok := pr.next()
+				if !ok {
+					break
+				}
+				switch id {
+				case idCueTime:
+					c.time = time.Duration(readUint(v)) * d.scale
+				case idCueTrackPositions:
+					tr := &ebmlReader{b: v}
+					for {
+						id, v, ok := tr.next()
+						if !ok {
+							break
+						}
+						if id == idCueClusterPosition {
+							c.pos = int64(readUint(v))
+						}
+					}
+				}
+			}
+			if c.pos >= 0 && (len(d.cues) == 0 || c.time >= d.cues[len(d.cues)-1].time) {
+				d.cues = append(d.cues, c)
+			}
+		}
+	}
+}
+
+// parseTrackEntry returns nil for tracks other than A_AAC.
+func parseTrackEntry(entry []byte) *Track {
+	t := &Track{}
+	var codecID string
+	var trackType uint64
+	r := &ebmlReader{b: entry}
+	for {
+		id, v, ok := r.next()
+		if !ok {
+			break
+		}
+		switch id {
+		case idTrackNumber:
+			t.Number = int(readUint(v))
+		case idTrackType:
+			trackType = readUint(v)
+		case idCodecID:
+			codecID = string(v)
+		case idCodecPrivate:
+			t.Config = append([]byte(nil), v...)
+		case idCodecDelay:
+			t.CodecDelay = time.Duration(readUint(v))
+		case idSeekPreRoll:
+			t.SeekPreRoll = time.Duration(readUint(v))
+		case idDefaultDuration:
+			t.DefaultDuration = time.Duration(readUint(v))
+		}
+	}
+	if trackType != trackTypeAudio || codecID != CodecIDAac || t.Number == 0 {
+		return nil
+	}
+	var err error
+	if t.AudioConfig, err = asc.Parse(t.Config); err != nil {
+		// Not decodable by this package, or the old profile-specific CodecIDs
+		// without CodecPrivate.
+		return nil
+	}
+	if t.DefaultDuration == 0 {
+		t.DefaultDuration = time.Duration(int64(t.AudioConfig.FrameLength()) * int64(time.Second) / int64(t.AudioConfig.SampleRate()))
+	}
+	return t
+}
+
+// readBlock reads the level 1 and cluster elements up to the next block of the
+// selected track and queues its access units. Clusters are entered rather than
+// read as a whole, so their size may be unknown.
+func (d *Demuxer) readBlock() error {
+	for {
+		if d.segmentEnd >= 0 && d.pos >= d.segmentEnd {
+			return io.EOF
+		}
+		id, size, unknown, hdrSize, err := d.readHeader(d.pos)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idCluster:
+			d.pos += hdrSize
+			continue
+		case idEBML, idSegment:
+			// A chained segment.
+			return io.EOF
+		}
+		if unknown {
+			return fmt.Errorf("%w: element %X of unknown size", ErrUnsupported, id)
+		}
+		start := d.pos + hdrSize
+		d.pos = start + int64(size)
+
+		switch id {
+		case idTimestamp:
+			v, err := d.readPayload(start, size)
+			if err != nil {
+				return err
+			}
+			d.clusterTime = int64(readUint(v))
+		case idSimpleBlock:
+			v, err := d.readPayload(start, size)
+			if err != nil {
+				return err
+			}
+			if err = d.queueBlock(v, 0); err != nil {
+				return err
+			}
+		case idBlockGroup:
+			v, err := d.readPayload(start, size)
+			if err != nil {
+				return err
+			}
+			var blk []byte
+			var padding time.Duration
+			r := &ebmlReader{b: v}
+			for {
+				id, v, ok := r.next()
+				if !ok {
+					break
+				}
+				switch id {
+				case idBlock:
+					blk = v
+				case idDiscardPadding:
+					padding = time.Duration(readInt(v))
+				}
+			}
+			if blk != nil {
+				if err = d.queueBlock(blk, padding); err != nil {
+					return err
+				}
+			}
+		}
+		if len(d.queue) > 0 {
+			return nil
+		}
+	}
+}
+
+// queueBlock queues the access units of a block of the selected track.
+func (d *Demuxer) queueBlock(b []byte, padding time.Duration) error {
+	blk, err := parseBlock(b)
+	if err != nil {
+		return err
+	}
+	if blk.track != d.track.Number {
+		return nil
+	}
+	t := d.track
+	ts := time.Duration(d.clusterTime+int64(blk.timestamp))*d.scale - t.CodecDelay
+	for i, f := range blk.frames {
+		s := &Sample{
+			Data:      f,
+			Timestamp: ts + time.Duration(i)*t.DefaultDuration,
+			Duration:  t.DefaultDuration,
+		}
+		if i == len(blk.frames)-1 {
+			s.DiscardPadding = padding
+		}
+		d.queue = append(d.queue, s)
+	}
+	return nil
+}
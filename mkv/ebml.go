@@ -0,0 +1,265 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Element IDs, with the length marker.
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment = 0x18538067
+
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekID       = 0x53AB
+	idSeekPosition = 0x53AC
+
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idMuxingApp      = 0x4D80
+	idWritingApp     = 0x5741
+
+	idTracks                  = 0x1654AE6B
+	idTrackEntry              = 0xAE
+	idTrackNumber             = 0xD7
+	idTrackUID                = 0x73C5
+	idTrackType               = 0x83
+	idFlagLacing              = 0x9C
+	idDefaultDuration         = 0x23E383
+	idCodecID                 = 0x86
+	idCodecPrivate            = 0x63A2
+	idCodecDelay              = 0x56AA
+	idSeekPreRoll             = 0x56BB
+	idAudio                   = 0xE1
+	idSamplingFrequency       = 0xB5
+	idOutputSamplingFrequency = 0x78B5
+	idChannels                = 0x9F
+
+	idCluster        = 0x1F43B675
+	idTimestamp      = 0xE7
+	idSimpleBlock    = 0xA3
+	idBlockGroup     = 0xA0
+	idBlock          = 0xA1
+	idDiscardPadding = 0x75A2
+
+	idCues                = 0x1C53BB6B
+	idCuePoint            = 0xBB
+	idCueTime             = 0xB3
+	idCueTrackPositions   = 0xB7
+	idCueTrack            = 0xF7
+	idCueClusterPosition  = 0xF1
+	idCueRelativePosition = 0xF0
+
+	idVoid = 0xEC
+)
+
+const (
+	// Size of the element size written by ebmlBuffer.start.
+	sizeLength = 8
+	// Element size of unknown length, as a sizeLength byte VINT.
+	unknownSize = 1<<56 - 1
+)
+
+// ebmlBuffer builds EBML elements in memory.
+type ebmlBuffer struct {
+	b []byte
+}
+
+func (w *ebmlBuffer) id(id uint32) {
+	switch {
+	case id >= 1<<24:
+		w.b = binary.BigEndian.AppendUint32(w.b, id)
+	case id >= 1<<16:
+		w.b = append(w.b, byte(id>>16), byte(id>>8), byte(id))
+	case id >= 1<<8:
+		w.b = append(w.b, byte(id>>8), byte(id))
+	default:
+		w.b = append(w.b, byte(id))
+	}
+}
+
+// start begins a master element and returns its offset for end.
+func (w *ebmlBuffer) start(id uint32) int {
+	w.id(id)
+	pos := len(w.b)
+	w.b = append(w.b, make([]byte, sizeLength)...)
+	return pos
+}
+
+// end patches the size of the element started at pos.
+func (w *ebmlBuffer) end(pos int) {
+	putSize(w.b[pos:pos+sizeLength], uint64(len(w.b)-pos-sizeLength))
+}
+
+// putSize writes v as a VINT filling b.
+func putSize(b []byte, v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	b[0] |= 0x80 >> (len(b) - 1)
+}
+
+// appendVint appends v as a VINT of the shortest length that does not read
+// as an unknown size.
+func appendVint(b []byte, v uint64) []byte {
+	n := 1
+	for n < 8 && v >= 1<<(7*n)-1 {
+		n++
+	}
+	p := make([]byte, n)
+	putSize(p, v)
+	return append(b, p...)
+}
+
+func (w *ebmlBuffer) uint(id uint32, v uint64) {
+	n := 1
+	for n < 8 && v >= 1<<(8*n) {
+		n++
+	}
+	w.id(id)
+	w.b = appendVint(w.b, uint64(n))
+	for i := n - 1; i >= 0; i-- {
+		w.b = append(w.b, byte(v>>(8*i)))
+	}
+}
+
+func (w *ebmlBuffer) int(id uint32, v int64) {
+	n := 1
+	for n < 8 && (v < -1<<(8*n-1) || v >= 1<<(8*n-1)) {
+		n++
+	}
+	w.id(id)
+	w.b = appendVint(w.b, uint64(n))
+	for i := n - 1; i >= 0; i-- {
+		w.b = append(w.b, byte(v>>(8*i)))
+	}
+}
+
+func (w *ebmlBuffer) float(id uint32, v float64) {
+	w.id(id)
+	w.b = append(w.b, 0x88)
+	w.b = binary.BigEndian.AppendUint64(w.b, math.Float64bits(v))
+}
+
+func (w *ebmlBuffer) bytes(id uint32, p []byte) {
+	w.id(id)
+	w.b = appendVint(w.b, uint64(len(p)))
+	w.b = append(w.b, p...)
+}
+
+func (w *ebmlBuffer) str(id uint32, s string) {
+	w.bytes(id, []byte(s))
+}
+
+// void appends a Void element of exactly n bytes, n >= 2.
+func (w *ebmlBuffer) void(n int) {
+	w.id(idVoid)
+	if n-2 < 0x7F {
+		w.b = append(w.b, 0x80|byte(n-2))
+		n -= 2
+	} else {
+		p := make([]byte, sizeLength)
+		putSize(p, uint64(n-1-sizeLength))
+		w.b = append(w.b, p...)
+		n -= 1 + sizeLength
+	}
+	w.b = append(w.b, make([]byte, n)...)
+}
+
+// readID reads an element ID at the start of b, returning it with its marker.
+func readID(b []byte) (uint32, int, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false
+	}
+	n := 1
+	for b[0]&(0x80>>(n-1)) == 0 {
+		n++
+	}
+	if n > 4 || len(b) < n {
+		return 0, 0, false
+	}
+	var id uint32
+	for _, v := range b[:n] {
+		id = id<<8 | uint32(v)
+	}
+	return id, n, true
+}
+
+// readVint reads a VINT at the start of b. unknown is set for the reserved
+// all ones value.
+func readVint(b []byte) (v uint64, n int, unknown, ok bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false, false
+	}
+	n = 1
+	for b[0]&(0x80>>(n-1)) == 0 {
+		n++
+	}
+	if len(b) < n {
+		return 0, 0, false, false
+	}
+	v = uint64(b[0] & (0xFF >> n))
+	for _, x := range b[1:n] {
+		v = v<<8 | uint64(x)
+	}
+	return v, n, v == 1<<(7*n)-1, true
+}
+
+// ebmlReader iterates over the child elements of a master element in memory.
+type ebmlReader struct {
+	b []byte
+}
+
+func (r *ebmlReader) next() (id uint32, payload []byte, ok bool) {
+	id, n, ok := readID(r.b)
+	if !ok {
+		return 0, nil, false
+	}
+	size, m, unknown, ok := readVint(r.b[n:])
+	if !ok || unknown || size > uint64(len(r.b)-n-m) {
+		return 0, nil, false
+	}
+	payload = r.b[n+m : n+m+int(size)]
+	r.b = r.b[n+m+int(size):]
+	return id, payload, true
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+func readInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int64(int8(b[0]))
+	for _, x := range b[1:] {
+		v = v<<8 | int64(x)
+	}
+	return v
+}
+
+func readFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+// Package mkv writes A_AAC audio tracks to Matroska (MKA/MKV) files and reads
+// them from Matroska and WebM files; WebM itself does not allow AAC, so it is
+// not written. CodecPrivate holds the AudioSpecificConfig from EncInfo.ConfBuf
+// of an encoder configured with TtMp4Raw, the encoder delay is signaled with
+// CodecDelay and the padding of the last frame with DiscardPadding.
+package mkv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DocTypeMatroska = "matroska"
+	DocTypeWebM     = "webm"
+
+	// CodecID of AAC tracks.
+	CodecIDAac = "A_AAC"
+
+	// Timestamps are written in milliseconds.
+	timestampScale = time.Millisecond
+	// TrackType of audio tracks.
+	trackTypeAudio = 2
+
+	// Lacing modes of the block flags.
+	lacingNone  = 0
+	lacingXiph  = 1
+	lacingFixed = 2
+	lacingEbml  = 3
+
+	// SimpleBlock flag of a keyframe.
+	flagKeyframe = 0x80
+)
+
+var (
+	ErrInvalid     = errors.New("mkv: invalid data")
+	ErrUnsupported = errors.New("mkv: unsupported data")
+	ErrNoTrack     = errors.New("mkv: no AAC track")
+	ErrClosed      = errors.New("mkv: muxer is closed")
+)
+
+// appendBlock appends the payload of a SimpleBlock or Block holding frames,
+// with EBML lacing for more than one frame.
+func appendBlock(b []byte, track int, timestamp int16, flags byte, frames [][]byte) []byte {
+	b = appendVint(b, uint64(track))
+	b = append(b, byte(uint16(timestamp)>>8), byte(timestamp))
+	if len(frames) == 1 {
+		b = append(b, flags)
+		return append(b, frames[0]...)
+	}
+
+	b = append(b, flags|lacingEbml<<1, byte(len(frames)-1))
+	b = appendVint(b, uint64(len(frames[0])))
+	for i := 1; i < len(frames)-1; i++ {
+		b = appendSignedVint(b, int64(len(frames[i])-len(frames[i-1])))
+	}
+	for _, f := range frames {
+		b = append(b, f...)
+	}
+	return b
+}
+
+// appendSignedVint appends v as the signed VINT of EBML lacing.
+func appendSignedVint(b []byte, v int64) []byte {
+	n := 1
+	for n < 8 && (v < -(1<<(7*n-1)-1) || v > 1<<(7*n-1)-1) {
+		n++
+	}
+	p := make([]byte, n)
+	putSize(p, uint64(v+1<<(7*n-1)-1))
+	return append(b, p...)
+}
+
+// block is a parsed SimpleBlock or Block.
+type block struct {
+	track     int
+	timestamp int16
+	flags     byte
+	frames    [][]byte
+}
+
+func parseBlock(b []byte) (*block, error) {
+	track, n, _, ok := readVint(b)
+	if !ok || len(b) < n+3 {
+		return nil, fmt.Errorf("%w: truncated block header", ErrInvalid)
+	}
+	blk := &block{
+		track:     int(track),
+		timestamp: int16(uint16(b[n])<<8 | uint16(b[n+1])),
+		flags:     b[n+2],
+	}
+	data := b[n+3:]
+	lacing := blk.flags >> 1 & 0x03
+	if lacing == lacingNone {
+		blk.frames = [][]byte{data}
+		return blk, nil
+	}
+
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w: truncated lace count", ErrInvalid)
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+	sizes := make([]int, count)
+	switch lacing {
+	case lacingXiph:
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(data) == 0 {
+					return nil, fmt.Errorf("%w: truncated Xiph lacing", ErrInvalid)
+				}
+				v := int(data[0])
+				data = data[1:]
+				sizes[i] += v
+				if v < 255 {
+					break
+				}
+			}
+		}
+	case lacingEbml:
+		for i := 0; i < count-1; i++ {
+			v, n, _, ok := readVint(data)
+			if !ok {
+				return nil, fmt.Errorf("%w: truncated EBML lacing", ErrInvalid)
+			}
+			data = data[n:]
+			if i == 0 {
+				sizes[0] = int(v)
+			} else {
+				sizes[i] = sizes[i-1] + int(int64(v)-(1<<(7*n-1)-1))
+			}
+		}
+	case lacingFixed:
+		if len(data)%count != 0 {
+			return nil, fmt.Errorf("%w: %d bytes in %d fixed size laces", ErrInvalid, len(data), count)
+		}
+		for i := range sizes {
+			sizes[i] = len(data) / count
+		}
+	}
+
+	total := 0
+	for _, sz := range sizes[:count-1] {
+		if sz < 0 {
+			return nil, fmt.Errorf("%w: negative lace size", ErrInvalid)
+		}
+		total += sz
+	}
+	if lacing != lacingFixed {
+		if total > len(data) {
+			return nil, fmt.Errorf("%w: laces exceed the block", ErrInvalid)
+		}
+		sizes[count-1] = len(data) - total
+	}
+	for _, sz := range sizes {
+		blk.frames = append(blk.frames, data[:sz])
+		data = data[sz:]
+	}
+	return blk, nil
+}
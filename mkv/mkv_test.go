@@ -0,0 +1,232 @@
+package mkv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+func muxTestFile(t *testing.T, w io.Writer, aus [][]byte, padding int, config *MuxerConfig) {
+	m, err := NewMuxer(w, []byte{0x12, 0x10}, config)
+	if err != nil {
+		t.Fatalf("NewMuxer failed: %v", err)
+	}
+	for _, au := range aus {
+		if err := m.WriteAU(au); err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+	}
+	m.SetPadding(padding)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// frameTime returns the presentation time of access unit i of 1024 samples
+// at 44.1 kHz.
+func frameTime(i, delay int) time.Duration {
+	return time.Duration(int64(i*1024-delay) * int64(time.Second) / 44100)
+}
+
+func TestMkv(t *testing.T) {
+	aus := testutil.AUs(100)
+
+	t.Run("Round trip", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			live   bool
+			config *MuxerConfig
+		}{
+			{"Default", false, nil},
+			{"Laced", false, &MuxerConfig{FramesPerBlock: 3, ClusterDuration: 300 * time.Millisecond}},
+			{"Delay", false, &MuxerConfig{Delay: 2048}},
+			{"Live", true, &MuxerConfig{Delay: 2048, FramesPerBlock: 4}},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				var file []byte
+				if c.live {
+					buf := &bytes.Buffer{}
+					muxTestFile(t, buf, aus, 500, c.config)
+					file = buf.Bytes()
+				} else {
+					f := &testutil.File{}
+					muxTestFile(t, f, aus, 500, c.config)
+					file = f.B
+				}
+				delay := 0
+				if c.config != nil {
+					delay = c.config.Delay
+				}
+
+				d, err := NewDemuxer(bytes.NewReader(file))
+				if err != nil {
+					t.Fatalf("NewDemuxer failed: %v", err)
+				}
+				tr := d.Track()
+				if len(d.Tracks()) != 1 || tr.Number != 1 || !bytes.Equal(tr.Config, []byte{0x12, 0x10}) {
+					t.Errorf("unexpected track %+v", tr)
+				}
+				if tr.CodecDelay != frameTime(0, -delay) || tr.DefaultDuration != frameTime(1, 0) {
+					t.Errorf("unexpected CodecDelay %v and DefaultDuration %v", tr.CodecDelay, tr.DefaultDuration)
+				}
+				// The duration excludes the delay and the padding of 500 samples.
+				if want := frameTime(100, delay+500); !c.live && (d.Duration() < want-time.Millisecond || d.Duration() > want) {
+					t.Errorf("expected duration %v, got %v", want, d.Duration())
+				}
+				if c.live && d.Duration() != 0 {
+					t.Errorf("expected unknown duration, got %v", d.Duration())
+				}
+
+				for i := 0; ; i++ {
+					s, err := d.ReadSample()
+					if err == io.EOF {
+						if i != len(aus) {
+							t.Errorf("expected %d samples, got %d", len(aus), i)
+						}
+						break
+					}
+					if err != nil {
+						t.Fatalf("ReadSample failed: %v", err)
+					}
+					if !bytes.Equal(s.Data, aus[i]) {
+						t.Errorf("sample %d differs", i)
+					}
+					// Block timestamps are rounded down to milliseconds.
+					if want := frameTime(i, delay); s.Timestamp > want || s.Timestamp < want-time.Millisecond {
+						t.Errorf("sample %d: expected timestamp %v, got %v", i, want, s.Timestamp)
+					}
+					padding := time.Duration(0)
+					if i == len(aus)-1 {
+						padding = frameTime(500, 0) / 1024
+					}
+					if s.DiscardPadding != padding {
+						t.Errorf("sample %d: expected DiscardPadding %v, got %v", i, padding, s.DiscardPadding)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		f := &testutil.File{}
+		muxTestFile(t, f, aus, 0, &MuxerConfig{Delay: 2048, ClusterDuration: 200 * time.Millisecond})
+		d, err := NewDemuxer(bytes.NewReader(f.B))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if len(d.cues) != 12 {
+			t.Errorf("expected 12 cue points, got %d", len(d.cues))
+		}
+		cases := []struct {
+			t      time.Duration
+			sample int
+		}{
+			{0, 1},
+			{frameTime(3, 2048) + 10*time.Millisecond, 2},
+			{time.Second, 44},
+			{2 * time.Second, 87},
+		}
+		for _, c := range cases {
+			discard, err := d.SeekTime(c.t)
+			if err != nil {
+				t.Fatalf("SeekTime failed: %v", err)
+			}
+			s, err := d.ReadSample()
+			if err != nil {
+				t.Fatalf("ReadSample failed: %v", err)
+			}
+			if !bytes.Equal(s.Data, aus[c.sample]) {
+				t.Errorf("seek %v: expected sample %d, got %v", c.t, c.sample, s.Timestamp)
+			}
+			if want := int64(c.t-s.Timestamp) * 44100 / int64(time.Second); discard != want {
+				t.Errorf("seek %v: expected discard %d, got %d", c.t, want, discard)
+			}
+		}
+
+		if _, err = d.SeekTime(time.Minute); err != nil {
+			t.Fatalf("SeekTime failed: %v", err)
+		}
+		if _, err = d.ReadSample(); err != io.EOF {
+			t.Errorf("expected io.EOF after seeking past the end, got %v", err)
+		}
+		if _, err = d.SeekTime(-1); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Lacing", func(t *testing.T) {
+		frames := [][]byte{make([]byte, 300), make([]byte, 20), make([]byte, 200), make([]byte, 7)}
+		blk, err := parseBlock(appendBlock(nil, 1, -5, flagKeyframe, frames))
+		if err != nil {
+			t.Fatalf("parseBlock failed: %v", err)
+		}
+		if blk.track != 1 || blk.timestamp != -5 || blk.flags&flagKeyframe == 0 || len(blk.frames) != len(frames) {
+			t.Fatalf("unexpected block %d/%d/%x with %d frames", blk.track, blk.timestamp, blk.flags, len(blk.frames))
+		}
+		for i, f := range blk.frames {
+			if len(f) != len(frames[i]) {
+				t.Errorf("EBML lace %d: expected %d bytes, got %d", i, len(frames[i]), len(f))
+			}
+		}
+
+		// Xiph lacing of 300 and 20 bytes, then a 5 byte frame.
+		xiph := append([]byte{0x81, 0, 0, lacingXiph << 1, 2, 255, 45, 20}, make([]byte, 325)...)
+		if blk, err = parseBlock(xiph); err != nil || len(blk.frames) != 3 ||
+			len(blk.frames[0]) != 300 || len(blk.frames[1]) != 20 || len(blk.frames[2]) != 5 {
+			t.Errorf("unexpected Xiph lacing: %v", err)
+		}
+		fixed := append([]byte{0x81, 0, 0, lacingFixed << 1, 1}, make([]byte, 10)...)
+		if blk, err = parseBlock(fixed); err != nil || len(blk.frames) != 2 || len(blk.frames[1]) != 5 {
+			t.Errorf("unexpected fixed lacing: %v", err)
+		}
+		if _, err = parseBlock(fixed[:len(fixed)-1]); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, docType := range []string{"mkv", DocTypeWebM} {
+			if _, err := NewMuxer(&bytes.Buffer{}, []byte{0x12, 0x10}, &MuxerConfig{DocType: docType}); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("%s: expected ErrUnsupported, got %v", docType, err)
+			}
+		}
+		m, err := NewMuxer(&bytes.Buffer{}, []byte{0x12, 0x10}, nil)
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		if err = m.WriteAU(nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		m.Close()
+		if err = m.WriteAU([]byte{1}); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+
+		if _, err = NewDemuxer(bytes.NewReader([]byte("not a matroska file"))); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		b := &ebmlBuffer{}
+		header := b.start(idEBML)
+		b.str(idDocType, "matroska")
+		b.end(header)
+		segment := b.start(idSegment)
+		tracks := b.start(idTracks)
+		b.end(tracks)
+		b.end(segment)
+		if _, err = NewDemuxer(bytes.NewReader(b.b)); !errors.Is(err, ErrNoTrack) {
+			t.Errorf("expected ErrNoTrack, got %v", err)
+		}
+
+		f := &testutil.File{}
+		muxTestFile(t, f, aus, 0, nil)
+		d, _ := NewDemuxer(bytes.NewReader(f.B))
+		if err = d.SelectTrack(5); !errors.Is(err, ErrNoTrack) {
+			t.Errorf("expected ErrNoTrack, got %v", err)
+		}
+	})
+}
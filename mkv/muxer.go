@@ -0,0 +1,373 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+const (
+	// Default duration of a cluster.
+	DefaultClusterDuration = time.Second
+	// Longest cluster, block timestamps are 16 bit offsets from the cluster.
+	maxClusterDuration = math.MaxInt16 * timestampScale
+
+	// Size reserved for the SeekHead, written on Close.
+	seekHeadSize = 128
+	// Track number and TrackUID of the AAC track.
+	trackNumber = 1
+	appName     = "audio-fdkaac"
+)
+
+// MuxerConfig configures a Muxer.
+type MuxerConfig struct {
+	// DocType, DocTypeMatroska if empty. WebM does not allow A_AAC, so
+	// DocTypeWebM is rejected.
+	DocType string
+	// Number of priming samples to be skipped by the player, e.g. EncInfo.NDelay.
+	// Written as CodecDelay.
+	Delay int
+	// Number of access units per block (default 1), laced with EBML lacing.
+	FramesPerBlock int
+	// Duration of a cluster (default DefaultClusterDuration).
+	ClusterDuration time.Duration
+}
+
+// heldBlock is a block kept back until it is known whether it is the last one.
+type heldBlock struct {
+	frames  [][]byte
+	samples int64
+}
+
+type cuePoint struct {
+	time int64
+	pos  int64
+}
+
+// Muxer writes an AAC track to a Matroska or WebM file. Each cluster gets a
+// cue point. If w is also an io.Seeker, the segment size, the duration and
+// the SeekHead are filled in on Close; otherwise they are left unknown, as
+// for live streaming.
+type Muxer struct {
+	w              io.Writer
+	seeker         io.Seeker
+	sampleRate     int64
+	frameLen       int64
+	framesPerBlock int
+	clusterDur     int64
+
+	base        int64
+	pos         int64
+	segmentPos  int64
+	seekHeadPos int64
+	durationPos int64
+	infoPos     int64
+	tracksPos   int64
+
+	cluster     ebmlBuffer
+	clusterOpen bool
+	clusterTime int64
+	cues        []cuePoint
+
+	pending        [][]byte
+	pendingSamples int64
+	held           *heldBlock
+	samples        int64
+	delay          int64
+	padding        int64
+	closed         bool
+}
+
+// NewMuxer returns a Muxer writing to w, which should be positioned at the
+// start of the file. conf is the AudioSpecificConfig, e.g. EncInfo.ConfBuf.
+// The headers up to the Tracks are written right away.
+func NewMuxer(w io.Writer, conf []byte, config *MuxerConfig) (*Muxer, error) {
+	ac, err := asc.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	m := &Muxer{
+		w:              w,
+		sampleRate:     int64(ac.SampleRate()),
+		frameLen:       int64(ac.FrameLength()),
+		framesPerBlock: 1,
+		clusterDur:     int64(DefaultClusterDuration / timestampScale),
+	}
+	if m.sampleRate == 0 || m.frameLen == 0 {
+		return nil, fmt.Errorf("%w: sample rate %d, frame length %d", ErrInvalid, m.sampleRate, m.frameLen)
+	}
+	docType := DocTypeMatroska
+	delay := 0
+	if config != nil {
+		if config.DocType != "" {
+			docType = config.DocType
+		}
+		if config.FramesPerBlock > 0 {
+			m.framesPerBlock = min(config.FramesPerBlock, 256)
+		}
+		if config.ClusterDuration > 0 {
+			m.clusterDur = int64(min(config.ClusterDuration, maxClusterDuration) / timestampScale)
+		}
+		delay = config.Delay
+	}
+	if docType != DocTypeMatroska {
+		return nil, fmt.Errorf("%w: DocType %q for %s", ErrUnsupported, docType, CodecIDAac)
+	}
+	m.delay = int64(max(delay, 0))
+	if s, ok := w.(io.Seeker); ok {
+		if m.base, err = s.Seek(0, io.SeekCurrent); err == nil {
+			m.seeker = s
+		}
+	}
+
+	b := &ebmlBuffer{}
+	header := b.start(idEBML)
+	b.uint(idEBMLVersion, 1)
+	b.uint(idEBMLReadVersion, 1)
+	b.uint(idEBMLMaxIDLength, 4)
+	b.uint(idEBMLMaxSizeLength, 8)
+	b.str(idDocType, docType)
+	// CodecDelay and DiscardPadding need version 4.
+	b.uint(idDocTypeVersion, 4)
+	b.uint(idDocTypeReadVersion, 2)
+	b.end(header)
+
+	b.id(idSegment)
+	b.b = binary.BigEndian.AppendUint64(b.b, 0x01<<56|unknownSize)
+	m.segmentPos = int64(len(b.b))
+	m.seekHeadPos = int64(len(b.b))
+	b.void(seekHeadSize)
+
+	m.infoPos = int64(len(b.b)) - m.segmentPos
+	info := b.start(idInfo)
+	b.uint(idTimestampScale, uint64(timestampScale))
+	b.str(idMuxingApp, appName)
+	b.str(idWritingApp, appName)
+	if m.seeker != nil {
+		b.float(idDuration, 0)
+		m.durationPos = int64(len(b.b)) - 8
+	}
+	b.end(info)
+
+	m.tracksPos = int64(len(b.b)) - m.segmentPos
+	tracks := b.start(idTracks)
+	entry := b.start(idTrackEntry)
+	b.uint(idTrackNumber, trackNumber)
+	b.uint(idTrackUID, trackNumber)
+	b.uint(idTrackType, trackTypeAudio)
+	lacing := uint64(0)
+	if m.framesPerBlock > 1 {
+		lacing = 1
+	}
+	b.uint(idFlagLacing, lacing)
+	b.uint(idDefaultDuration, uint64(m.nanoseconds(m.frameLen)))
+	b.str(idCodecID, CodecIDAac)
+	b.bytes(idCodecPrivate, conf)
+	if delay > 0 {
+		b.uint(idCodecDelay, uint64(m.nanoseconds(int64(delay))))
+	}
+	// The MDCT of a frame overlaps with the previous one.
+	b.uint(idSeekPreRoll, uint64(m.nanoseconds(m.frameLen)))
+	audio := b.start(idAudio)
+	b.float(idSamplingFrequency, float64(ac.SamplingFrequency))
+	if ac.SampleRate() != ac.SamplingFrequency {
+		b.float(idOutputSamplingFrequency, float64(ac.SampleRate()))
+	}
+	channels := ac.Channels()
+	if channels == 0 {
+		channels = 2
+	}
+	b.uint(idChannels, uint64(channels))
+	b.end(audio)
+	b.end(entry)
+	b.end(tracks)
+
+	if err = m.write(b.b); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// nanoseconds converts a number of samples to nanoseconds.
+func (m *Muxer) nanoseconds(samples int64) int64 {
+	return samples * int64(time.Second) / m.sampleRate
+}
+
+func (m *Muxer) write(b []byte) error {
+	n, err := m.w.Write(b)
+	m.pos += int64(n)
+	return err
+}
+
+// SetPadding sets the number of samples at the end of the stream that the
+// player should discard, written as DiscardPadding of the last block. The
+// padding is limited to the duration of the last block.
+func (m *Muxer) SetPadding(samples int) {
+	m.padding = int64(samples)
+}
+
+// WriteAU adds an access unit.
+func (m *Muxer) WriteAU(au []byte) error {
+	if m.closed {
+		return ErrClosed
+	}
+	if len(au) == 0 {
+		return fmt.Errorf("%w: empty access unit", ErrInvalid)
+	}
+	if len(m.pending) == 0 {
+		m.pendingSamples = m.samples
+	}
+	m.pending = append(m.pending, append([]byte(nil), au...))
+	m.samples += m.frameLen
+	if len(m.pending) < m.framesPerBlock {
+		return nil
+	}
+	return m.holdBlock()
+}
+
+// holdBlock writes the held block and holds the pending one instead.
+func (m *Muxer) holdBlock() error {
+	if m.held != nil {
+		if err := m.writeBlock(m.held, 0); err != nil {
+			return err
+		}
+	}
+	m.held = &heldBlock{frames: m.pending, samples: m.pendingSamples}
+	m.pending = nil
+	return nil
+}
+
+// writeBlock adds a block to the cluster, starting a new cluster as needed.
+// A block with padding is written as a BlockGroup with DiscardPadding.
+func (m *Muxer) writeBlock(blk *heldBlock, padding int64) error {
+	timestamp := blk.samples * int64(time.Second/timestampScale) / m.sampleRate
+	if m.clusterOpen && timestamp-m.clusterTime >= m.clusterDur {
+		if err := m.writeCluster(); err != nil {
+			return err
+		}
+	}
+	if !m.clusterOpen {
+		m.clusterOpen = true
+		m.clusterTime = timestamp
+		m.cluster.b = m.cluster.b[:0]
+	}
+
+	rel := int16(timestamp - m.clusterTime)
+	if padding == 0 {
+		m.cluster.bytes(idSimpleBlock, appendBlock(nil, trackNumber, rel, flagKeyframe, blk.frames))
+		return nil
+	}
+	group := m.cluster.start(idBlockGroup)
+	m.cluster.bytes(idBlock, appendBlock(nil, trackNumber, rel, 0, blk.frames))
+	m.cluster.int(idDiscardPadding, m.nanoseconds(padding))
+	m.cluster.end(group)
+	return nil
+}
+
+// writeCluster writes the current cluster and adds its cue point.
+func (m *Muxer) writeCluster() error {
+	if !m.clusterOpen {
+		return nil
+	}
+	m.clusterOpen = false
+	m.cues = append(m.cues, cuePoint{time: m.clusterTime, pos: m.pos - m.segmentPos})
+
+	b := &ebmlBuffer{}
+	cluster := b.start(idCluster)
+	b.uint(idTimestamp, uint64(m.clusterTime))
+	b.b = append(b.b, m.cluster.b...)
+	b.end(cluster)
+	return m.write(b.b)
+}
+
+// Close writes the pending access units, the Cues and, if the writer is
+// seekable, the segment size, the duration and the SeekHead. The duration
+// excludes the delay and the padding.
+// It does not close the underlying writer.
+func (m *Muxer) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	if len(m.pending) > 0 {
+		if err := m.holdBlock(); err != nil {
+			return err
+		}
+	}
+	padding := int64(0)
+	if m.held != nil {
+		padding = max(min(m.padding, int64(len(m.held.frames))*m.frameLen), 0)
+		if err := m.writeBlock(m.held, padding); err != nil {
+			return err
+		}
+	}
+	if err := m.writeCluster(); err != nil {
+		return err
+	}
+
+	cuesPos := m.pos - m.segmentPos
+	b := &ebmlBuffer{}
+	cues := b.start(idCues)
+	for _, c := range m.cues {
+		point := b.start(idCuePoint)
+		b.uint(idCueTime, uint64(c.time))
+		positions := b.start(idCueTrackPositions)
+		b.uint(idCueTrack, trackNumber)
+		b.uint(idCueClusterPosition, uint64(c.pos))
+		b.end(positions)
+		b.end(point)
+	}
+	b.end(cues)
+	if err := m.write(b.b); err != nil {
+		return err
+	}
+	if m.seeker == nil {
+		return nil
+	}
+
+	end := m.pos
+	size := make([]byte, sizeLength)
+	putSize(size, uint64(end-m.segmentPos))
+	if err := m.writeAt(m.segmentPos-sizeLength, size); err != nil {
+		return err
+	}
+	samples := max(m.samples-m.delay-padding, 0)
+	duration := float64(samples) * float64(time.Second/timestampScale) / float64(m.sampleRate)
+	if err := m.writeAt(m.durationPos, binary.BigEndian.AppendUint64(nil, math.Float64bits(duration))); err != nil {
+		return err
+	}
+
+	b = &ebmlBuffer{}
+	seekHead := b.start(idSeekHead)
+	for _, s := range []struct {
+		id  uint32
+		pos int64
+	}{{idInfo, m.infoPos}, {idTracks, m.tracksPos}, {idCues, cuesPos}} {
+		seek := b.start(idSeek)
+		b.bytes(idSeekID, binary.BigEndian.AppendUint32(nil, s.id))
+		b.uint(idSeekPosition, uint64(s.pos))
+		b.end(seek)
+	}
+	b.end(seekHead)
+	b.void(seekHeadSize - len(b.b))
+	if err := m.writeAt(m.seekHeadPos, b.b); err != nil {
+		return err
+	}
+
+	_, err := m.seeker.Seek(m.base+end, io.SeekStart)
+	return err
+}
+
+// writeAt overwrites the bytes at file offset pos.
+func (m *Muxer) writeAt(pos int64, b []byte) error {
+	if _, err := m.seeker.Seek(m.base+pos, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := m.w.Write(b)
+	return err
+}
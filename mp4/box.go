@@ -0,0 +1,83 @@
+package mp4
+
+import "encoding/binary"
+
+// boxBuffer builds ISO-BMFF boxes in memory.
+type boxBuffer struct {
+	b []byte
+}
+
+// start begins a box and returns its offset for end.
+func (w *boxBuffer) start(typ string) int {
+	pos := len(w.b)
+	w.u32(0)
+	w.str(typ)
+	return pos
+}
+
+// startFull begins a full box with version and flags.
+func (w *boxBuffer) startFull(typ string, version uint8, flags uint32) int {
+	pos := w.start(typ)
+	w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+	return pos
+}
+
+// end patches the size of the box started at pos.
+func (w *boxBuffer) end(pos int) {
+	binary.BigEndian.PutUint32(w.b[pos:], uint32(len(w.b)-pos))
+}
+
+func (w *boxBuffer) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *boxBuffer) u16(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+func (w *boxBuffer) u32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *boxBuffer) u64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+func (w *boxBuffer) str(s string) {
+	w.b = append(w.b, s...)
+}
+
+func (w *boxBuffer) bytes(p []byte) {
+	w.b = append(w.b, p...)
+}
+
+func (w *boxBuffer) zeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+// matrix writes the unity transformation matrix.
+func (w *boxBuffer) matrix() {
+	for _, v := range [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// descriptor begins an MPEG-4 descriptor, the size is patched by endDescriptor.
+func (w *boxBuffer) descriptor(tag uint8) int {
+	w.u8(tag)
+	pos := len(w.b)
+	// Four byte size, patched by endDescriptor.
+	w.bytes([]byte{0x80, 0x80, 0x80, 0x00})
+	return pos
+}
+
+// endDescriptor patches the expandable size of the descriptor started at pos.
+func (w *boxBuffer) endDescriptor(pos int) {
+	n := len(w.b) - pos - 4
+	w.b[pos] = 0x80 | byte(n>>21)&0x7F
+	w.b[pos+1] = 0x80 | byte(n>>14)&0x7F
+	w.b[pos+2] = 0x80 | byte(n>>7)&0x7F
+	w.b[pos+3] = byte(n) & 0x7F
+}
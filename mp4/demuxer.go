@@ -0,0 +1,611 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+// ErrNoTrack is returned when a file has no AAC track.
+var ErrNoTrack = errors.New("mp4: no AAC track")
+
+const (
+	// Maximum size of a moov box read into memory.
+	maxMoovSize = 256 << 20
+	// Maximum number of samples of a track, bounds the sample table.
+	maxSamples = 1 << 26
+)
+
+// Track is an AAC track of an MP4 file.
+type Track struct {
+	// track_ID of the track header.
+	ID int
+	// Media timescale, the unit of all times of the track.
+	Timescale uint32
+	// Media duration.
+	Duration uint64
+	// AudioSpecificConfig from esds, for Decoder.ConfigRaw.
+	Config []byte
+	// Parsed Config.
+	AudioConfig *asc.AudioSpecificConfig
+	// Media time of the first sample to present according to the edit list,
+	// usually the encoder delay.
+	Delay int64
+
+	samples []sampleEntry
+}
+
+// NumSamples returns the number of access units of the track.
+func (t *Track) NumSamples() int {
+	return len(t.samples)
+}
+
+type sampleEntry struct {
+	offset   int64
+	size     uint32
+	dts      int64
+	pts      int64
+	duration uint32
+}
+
+// Sample is an access unit read from a track.
+type Sample struct {
+	Data []byte
+	// Decode and presentation time in Track.Timescale units.
+	DTS int64
+	PTS int64
+	// Duration in Track.Timescale units.
+	Duration uint32
+}
+
+// Demuxer reads the access units of an AAC track from an MP4 file.
+type Demuxer struct {
+	r      io.ReadSeeker
+	tracks []*Track
+	track  *Track
+	next   int
+}
+
+// NewDemuxer reads the moov box of the file read from r and selects the first AAC track.
+func NewDemuxer(r io.ReadSeeker) (*Demuxer, error) {
+	d := &Demuxer{r: r}
+	moov, err := d.readMoov()
+	if err != nil {
+		return nil, err
+	}
+	if err = d.parseMoov(moov); err != nil {
+		return nil, err
+	}
+	if len(d.tracks) == 0 {
+		return nil, ErrNoTrack
+	}
+	d.track = d.tracks[0]
+	return d, nil
+}
+
+// Tracks returns the AAC tracks of the file.
+func (d *Demuxer) Tracks() []*Track {
+	return d.tracks
+}
+
+// Track returns the selected track.
+func (d *Demuxer) Track() *Track {
+	return d.track
+}
+
+// SelectTrack selects the track with the given track_ID and rewinds to its first sample.
+func (d *Demuxer) SelectTrack(id int) error {
+	for _, t := range d.tracks {
+		if t.ID == id {
+			d.track = t
+			d.next = 0
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: track %d", ErrNoTrack, id)
+}
+
+// ReadSample reads the next access unit of the selected track, or returns io.EOF.
+func (d *Demuxer) ReadSample() (*Sample, error) {
+	if d.next >= len(d.track.samples) {
+		return nil, io.EOF
+	}
+	s := d.track.samples[d.next]
+	if _, err := d.r.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, s.size)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.next++
+	return &Sample{
+		Data:     data,
+		DTS:      s.dts,
+		PTS:      s.pts,
+		Duration: s.duration,
+	}, nil
+}
+
+// Config returns the AudioSpecificConfig of the selected track.
+func (d *Demuxer) Config() []byte {
+	return d.track.Config
+}
+
+// Delay returns the duration of the samples before the start of the edit list.
+func (d *Demuxer) Delay() time.Duration {
+	return time.Duration(d.track.Delay) * time.Second / time.Duration(max(d.track.Timescale, 1))
+}
+
+// ReadAU reads the next access unit of the selected track, or returns io.EOF.
+// With Config and Delay, it lets fdkaac.NewDemuxDecodeReader decode the track.
+func (d *Demuxer) ReadAU() ([]byte, int, error) {
+	s, err := d.ReadSample()
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.Data, 0, nil
+}
+
+// SeekTime positions the demuxer for presentation time pts, where 0 is the
+// start of the edit list at Track.Delay. As the MDCT overlaps with the
+// previous frame, reading starts one access unit early. The decoded output
+// must be trimmed by the returned number of samples per channel, in Timescale
+// units, to start exactly at pts.
+func (d *Demuxer) SeekTime(pts int64) (discard int64, err error) {
+	samples := d.track.samples
+	if pts < 0 {
+		return 0, fmt.Errorf("%w: seek to %d", ErrInvalid, pts)
+	}
+	pts += d.track.Delay
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].pts+int64(samples[i].duration) > pts
+	})
+	if i == len(samples) {
+		d.next = i
+		return 0, nil
+	}
+	if i > 0 {
+		i--
+	}
+	d.next = i
+	return max(pts-samples[i].pts, 0), nil
+}
+
+// readMoov finds the moov box among the top level boxes and returns its payload.
+func (d *Demuxer) readMoov() ([]byte, error) {
+	pos, err := d.r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 16)
+	for {
+		if _, err = io.ReadFull(d.r, hdr[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("%w: no moov box", ErrInvalid)
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr))
+		hdrSize := int64(8)
+		switch size {
+		case 0:
+			end, err := d.r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			size = end - pos
+			if _, err = d.r.Seek(pos+hdrSize, io.SeekStart); err != nil {
+				return nil, err
+			}
+		case 1:
+			if _, err = io.ReadFull(d.r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:]))
+			hdrSize = 16
+		}
+		if size < hdrSize {
+			return nil, fmt.Errorf("%w: box size %d", ErrInvalid, size)
+		}
+
+		if string(hdr[4:8]) == "moov" {
+			if size-hdrSize > maxMoovSize {
+				return nil, fmt.Errorf("%w: moov of %d bytes", ErrUnsupported, size)
+			}
+			moov := make([]byte, size-hdrSize)
+			if _, err = io.ReadFull(d.r, moov); err != nil {
+				return nil, err
+			}
+			return moov, nil
+		}
+		if pos, err = d.r.Seek(pos+size, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// boxReader iterates over the boxes of a payload.
+type boxReader struct {
+	b []byte
+}
+
+// next returns the type and payload of the next box.
+func (r *boxReader) next() (typ string, payload []byte, ok bool) {
+	if len(r.b) < 8 {
+		return "", nil, false
+	}
+	size := uint64(binary.BigEndian.Uint32(r.b))
+	hdrSize := uint64(8)
+	switch size {
+	case 0:
+		size = uint64(len(r.b))
+	case 1:
+		if len(r.b) < 16 {
+			return "", nil, false
+		}
+		size = binary.BigEndian.Uint64(r.b[8:])
+		hdrSize = 16
+	}
+	if size < hdrSize || size > uint64(len(r.b)) {
+		return "", nil, false
+	}
+	typ = string(r.b[4:8])
+	payload = r.b[hdrSize:size]
+	r.b = r.b[size:]
+	return typ, payload, true
+}
+
+// child returns the payload of the first child box of the given type.
+func child(b []byte, typ string) []byte {
+	r := &boxReader{b: b}
+	for {
+		t, payload, ok := r.next()
+		if !ok {
+			return nil
+		}
+		if t == typ {
+			return payload
+		}
+	}
+}
+
+func (d *Demuxer) parseMoov(moov []byte) error {
+	r := &boxReader{b: moov}
+	for {
+		typ, payload, ok := r.next()
+		if !ok {
+			return nil
+		}
+		if typ != "trak" {
+			continue
+		}
+		t, err := parseTrak(payload)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			d.tracks = append(d.tracks, t)
+		}
+	}
+}
+
+// parseTrak returns nil for tracks other than AAC.
+func parseTrak(trak []byte) (*Track, error) {
+	mdia := child(trak, "mdia")
+	hdlr := child(mdia, "hdlr")
+	if len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return nil, nil
+	}
+	stbl := child(child(mdia, "minf"), "stbl")
+	config := esdsConfig(child(stbl, "stsd"))
+	if config == nil {
+		return nil, nil
+	}
+	audioConfig, err := asc.Parse(config)
+	if err != nil {
+		// Not decodable by this package, e.g. USAC.
+		return nil, nil
+	}
+
+	t := &Track{
+		Config:      config,
+		AudioConfig: audioConfig,
+	}
+	tkhd := child(trak, "tkhd")
+	if len(tkhd) >= 4 {
+		if tkhd[0] == 1 && len(tkhd) >= 24 {
+			t.ID = int(binary.BigEndian.Uint32(tkhd[20:]))
+		} else if len(tkhd) >= 16 {
+			t.ID = int(binary.BigEndian.Uint32(tkhd[12:]))
+		}
+	}
+	mdhd := child(mdia, "mdhd")
+	switch {
+	case len(mdhd) >= 32 && mdhd[0] == 1:
+		t.Timescale = binary.BigEndian.Uint32(mdhd[20:])
+		t.Duration = binary.BigEndian.Uint64(mdhd[24:])
+	case len(mdhd) >= 20:
+		t.Timescale = binary.BigEndian.Uint32(mdhd[12:])
+		t.Duration = uint64(binary.BigEndian.Uint32(mdhd[16:]))
+	}
+	if t.Timescale == 0 {
+		return nil, fmt.Errorf("%w: track %d has no timescale", ErrInvalid, t.ID)
+	}
+	t.Delay = editDelay(child(child(trak, "edts"), "elst"))
+
+	if err = t.buildSamples(stbl); err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	return t, nil
+}
+
+// esdsConfig returns the DecoderSpecificInfo of the first mp4a sample entry.
+func esdsConfig(stsd []byte) []byte {
+	if len(stsd) < 8 {
+		return nil
+	}
+	r := &boxReader{b: stsd[8:]}
+	for {
+		typ, entry, ok := r.next()
+		if !ok {
+			return nil
+		}
+		if typ != "mp4a" || len(entry) < 28 {
+			continue
+		}
+		// Skip the QuickTime sound description version 1 and 2 fields.
+		skip := 28
+		switch binary.BigEndian.Uint16(entry[8:]) {
+		case 1:
+			skip += 16
+		case 2:
+			skip += 36
+		}
+		if len(entry) < skip {
+			continue
+		}
+		esds := child(entry[skip:], "esds")
+		if esds == nil {
+			esds = child(child(entry[skip:], "wave"), "esds")
+		}
+		if len(esds) > 4 {
+			if config := decoderSpecificInfo(esds[4:]); config != nil {
+				return config
+			}
+		}
+	}
+}
+
+// decoderSpecificInfo walks the ES_Descriptor to the DecoderSpecificInfo of an MPEG-4 audio stream.
+func decoderSpecificInfo(b []byte) []byte {
+	tag, es, ok := readDescriptor(&b)
+	if !ok || tag != 0x03 || len(es) < 3 {
+		return nil
+	}
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 && len(es) >= 2 {
+		es = es[2:]
+	}
+	if flags&0x40 != 0 && len(es) >= 1 && len(es) >= 1+int(es[0]) {
+		es = es[1+int(es[0]):]
+	}
+	if flags&0x20 != 0 && len(es) >= 2 {
+		es = es[2:]
+	}
+
+	tag, dc, ok := readDescriptor(&es)
+	if !ok || tag != 0x04 || len(dc) < 13 {
+		return nil
+	}
+	// MPEG-4 audio, or MPEG-2 AAC Main, LC and SSR.
+	if dc[0] != objectTypeAudio && (dc[0] < 0x66 || dc[0] > 0x68) {
+		return nil
+	}
+	dc = dc[13:]
+	tag, dsi, ok := readDescriptor(&dc)
+	if !ok || tag != 0x05 || len(dsi) == 0 {
+		return nil
+	}
+	return append([]byte(nil), dsi...)
+}
+
+// readDescriptor reads a descriptor with an expandable size from b.
+func readDescriptor(b *[]byte) (tag uint8, payload []byte, ok bool) {
+	p := *b
+	if len(p) < 2 {
+		return 0, nil, false
+	}
+	tag = p[0]
+	p = p[1:]
+	size := 0
+	for i := 0; i < 4; i++ {
+		if len(p) == 0 {
+			return 0, nil, false
+		}
+		v := p[0]
+		p = p[1:]
+		size = size<<7 | int(v&0x7F)
+		if v&0x80 == 0 {
+			break
+		}
+	}
+	if size > len(p) {
+		return 0, nil, false
+	}
+	*b = p[size:]
+	return tag, p[:size], true
+}
+
+// editDelay returns the media time of the first edit that is not empty.
+func editDelay(elst []byte) int64 {
+	if len(elst) < 8 {
+		return 0
+	}
+	version := elst[0]
+	n := int(binary.BigEndian.Uint32(elst[4:]))
+	p := elst[8:]
+	for i := 0; i < n; i++ {
+		var mediaTime int64
+		if version == 1 {
+			if len(p) < 20 {
+				return 0
+			}
+			mediaTime = int64(binary.BigEndian.Uint64(p[8:]))
+			p = p[20:]
+		} else {
+			if len(p) < 12 {
+				return 0
+			}
+			mediaTime = int64(int32(binary.BigEndian.Uint32(p[4:])))
+			p = p[12:]
+		}
+		if mediaTime >= 0 {
+			return mediaTime
+		}
+	}
+	return 0
+}
+
+// fullBoxEntries returns the entry count and entries of a full box with a
+// 32-bit entry count, checking that n entries of entrySize bytes are present.
+func fullBoxEntries(b []byte, entrySize int, name string) (int, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, fmt.Errorf("%w: missing %s", ErrInvalid, name)
+	}
+	n := int(binary.BigEndian.Uint32(b[4:]))
+	if n < 0 || n > (len(b)-8)/entrySize {
+		return 0, nil, fmt.Errorf("%w: %s with %d entries", ErrInvalid, name, n)
+	}
+	return n, b[8:], nil
+}
+
+// buildSamples builds the sample offsets and times from the sample tables.
+func (t *Track) buildSamples(stbl []byte) error {
+	// Sample sizes.
+	stsz := child(stbl, "stsz")
+	if len(stsz) < 12 {
+		if child(stbl, "stz2") != nil {
+			return fmt.Errorf("%w: stz2", ErrUnsupported)
+		}
+		return fmt.Errorf("%w: missing stsz", ErrInvalid)
+	}
+	sampleSize := binary.BigEndian.Uint32(stsz[4:])
+	numSamples := int(binary.BigEndian.Uint32(stsz[8:]))
+	if sampleSize == 0 && (numSamples < 0 || numSamples > (len(stsz)-12)/4) {
+		return fmt.Errorf("%w: stsz with %d samples", ErrInvalid, numSamples)
+	}
+	if numSamples < 0 || numSamples > maxSamples {
+		return fmt.Errorf("%w: %d samples", ErrUnsupported, numSamples)
+	}
+	samples := make([]sampleEntry, numSamples)
+	for i := range samples {
+		samples[i].size = sampleSize
+		if sampleSize == 0 {
+			samples[i].size = binary.BigEndian.Uint32(stsz[12+4*i:])
+		}
+	}
+
+	// Chunk offsets.
+	var offsets []int64
+	if stco := child(stbl, "stco"); stco != nil {
+		n, p, err := fullBoxEntries(stco, 4, "stco")
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(p[4*i:])))
+		}
+	} else {
+		n, p, err := fullBoxEntries(child(stbl, "co64"), 8, "stco")
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(p[8*i:])))
+		}
+	}
+
+	// Sample to chunk.
+	n, stsc, err := fullBoxEntries(child(stbl, "stsc"), 12, "stsc")
+	if err != nil {
+		return err
+	}
+	s := 0
+	for i := 0; i < n && s < numSamples; i++ {
+		first := int(binary.BigEndian.Uint32(stsc[12*i:])) - 1
+		perChunk := int(binary.BigEndian.Uint32(stsc[12*i+4:]))
+		last := len(offsets)
+		if i+1 < n {
+			last = int(binary.BigEndian.Uint32(stsc[12*(i+1):])) - 1
+		}
+		if first < 0 || last > len(offsets) || first > last {
+			return fmt.Errorf("%w: stsc chunk %d", ErrInvalid, first+1)
+		}
+		for c := first; c < last && s < numSamples; c++ {
+			off := offsets[c]
+			for k := 0; k < perChunk && s < numSamples; k++ {
+				samples[s].offset = off
+				off += int64(samples[s].size)
+				s++
+			}
+		}
+	}
+	if s != numSamples {
+		return fmt.Errorf("%w: %d of %d samples in chunks", ErrInvalid, s, numSamples)
+	}
+
+	// Decode times.
+	n, stts, err := fullBoxEntries(child(stbl, "stts"), 8, "stts")
+	if err != nil {
+		return err
+	}
+	s = 0
+	var dts int64
+	for i := 0; i < n && s < numSamples; i++ {
+		count := int(binary.BigEndian.Uint32(stts[8*i:]))
+		delta := binary.BigEndian.Uint32(stts[8*i+4:])
+		for k := 0; k < count && s < numSamples; k++ {
+			samples[s].dts = dts
+			samples[s].pts = dts
+			samples[s].duration = delta
+			dts += int64(delta)
+			s++
+		}
+	}
+	if s != numSamples {
+		return fmt.Errorf("%w: %d of %d samples in stts", ErrInvalid, s, numSamples)
+	}
+
+	// Composition offsets.
+	if ctts := child(stbl, "ctts"); ctts != nil {
+		n, p, err := fullBoxEntries(ctts, 8, "ctts")
+		if err != nil {
+			return err
+		}
+		s = 0
+		for i := 0; i < n && s < numSamples; i++ {
+			count := int(binary.BigEndian.Uint32(p[8*i:]))
+			offset := int64(binary.BigEndian.Uint32(p[8*i+4:]))
+			if ctts[0] == 1 {
+				offset = int64(int32(offset))
+			}
+			for k := 0; k < count && s < numSamples; k++ {
+				samples[s].pts = samples[s].dts + offset
+				s++
+			}
+		}
+	}
+
+	t.samples = samples
+	return nil
+}
@@ -0,0 +1,168 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+func muxTestFile(t *testing.T, aus [][]byte, config *MuxerConfig) []byte {
+	f := &testutil.File{}
+	m, err := NewMuxer(f, []byte{0x12, 0x10}, config)
+	if err != nil {
+		t.Fatalf("NewMuxer failed: %v", err)
+	}
+	for _, au := range aus {
+		if err := m.WriteAU(au); err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return f.B
+}
+
+func TestDemuxer(t *testing.T) {
+	aus := testutil.AUs(100)
+
+	t.Run("Read samples", func(t *testing.T) {
+		for _, faststart := range []bool{false, true} {
+			file := muxTestFile(t, aus, &MuxerConfig{Faststart: faststart, Delay: 2048})
+			d, err := NewDemuxer(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("NewDemuxer failed: %v", err)
+			}
+			tr := d.Track()
+			if len(d.Tracks()) != 1 || tr.ID != 1 || tr.Timescale != 44100 || tr.Duration != 100*1024 {
+				t.Errorf("unexpected track %+v", tr)
+			}
+			if !bytes.Equal(tr.Config, []byte{0x12, 0x10}) || tr.AudioConfig.Channels() != 2 {
+				t.Errorf("unexpected config % x", tr.Config)
+			}
+			if tr.Delay != 2048 || tr.NumSamples() != len(aus) {
+				t.Errorf("expected delay 2048 and %d samples, got %d and %d", len(aus), tr.Delay, tr.NumSamples())
+			}
+
+			for i := 0; ; i++ {
+				s, err := d.ReadSample()
+				if err == io.EOF {
+					if i != len(aus) {
+						t.Errorf("expected %d samples, got %d", len(aus), i)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadSample failed: %v", err)
+				}
+				if !bytes.Equal(s.Data, aus[i]) {
+					t.Errorf("faststart %v: sample %d differs", faststart, i)
+				}
+				if s.DTS != int64(i*1024) || s.PTS != s.DTS || s.Duration != 1024 {
+					t.Errorf("sample %d: unexpected times %d/%d/%d", i, s.DTS, s.PTS, s.Duration)
+				}
+			}
+		}
+	})
+
+	t.Run("Access units", func(t *testing.T) {
+		d, err := NewDemuxer(bytes.NewReader(muxTestFile(t, aus, &MuxerConfig{Delay: 2048})))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if !bytes.Equal(d.Config(), []byte{0x12, 0x10}) {
+			t.Errorf("unexpected config % x", d.Config())
+		}
+		if want := 2048 * time.Second / 44100; d.Delay() != want {
+			t.Errorf("expected delay %v, got %v", want, d.Delay())
+		}
+		for i := range aus {
+			au, lost, err := d.ReadAU()
+			if err != nil {
+				t.Fatalf("ReadAU failed: %v", err)
+			}
+			if !bytes.Equal(au, aus[i]) || lost != 0 {
+				t.Errorf("access unit %d differs", i)
+			}
+		}
+		if _, _, err := d.ReadAU(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		d, err := NewDemuxer(bytes.NewReader(muxTestFile(t, aus, nil)))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		cases := []struct {
+			pts     int64
+			sample  int
+			discard int64
+		}{
+			{0, 0, 0},
+			{100, 0, 100},
+			{1024, 0, 1024},
+			{5000, 3, 5000 - 3*1024},
+		}
+		for _, c := range cases {
+			discard, err := d.SeekTime(c.pts)
+			if err != nil {
+				t.Fatalf("SeekTime failed: %v", err)
+			}
+			s, err := d.ReadSample()
+			if err != nil {
+				t.Fatalf("ReadSample failed: %v", err)
+			}
+			if discard != c.discard || !bytes.Equal(s.Data, aus[c.sample]) {
+				t.Errorf("seek %d: expected sample %d discard %d, got pts %d discard %d",
+					c.pts, c.sample, c.discard, s.PTS, discard)
+			}
+		}
+
+		if _, err = d.SeekTime(200 * 1024); err != nil {
+			t.Fatalf("SeekTime failed: %v", err)
+		}
+		if _, err = d.ReadSample(); err != io.EOF {
+			t.Errorf("expected io.EOF after seeking past the end, got %v", err)
+		}
+		if _, err = d.SeekTime(-1); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+
+		// With an edit list, pts 0 is the first sample after the delay.
+		d, err = NewDemuxer(bytes.NewReader(muxTestFile(t, aus, &MuxerConfig{Delay: 2000})))
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		discard, err := d.SeekTime(3000)
+		if err != nil {
+			t.Fatalf("SeekTime failed: %v", err)
+		}
+		if s, err := d.ReadSample(); err != nil || !bytes.Equal(s.Data, aus[3]) || discard != 5000-3*1024 {
+			t.Errorf("expected sample 3 discard %d, got discard %d (%v)", 5000-3*1024, discard, err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewDemuxer(bytes.NewReader([]byte("not an mp4 file"))); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		w := &boxBuffer{}
+		writeFtyp(w, FileFormatMp4)
+		moov := w.start("moov")
+		w.end(moov)
+		if _, err := NewDemuxer(bytes.NewReader(w.b)); !errors.Is(err, ErrNoTrack) {
+			t.Errorf("expected ErrNoTrack, got %v", err)
+		}
+
+		d, _ := NewDemuxer(bytes.NewReader(muxTestFile(t, aus, nil)))
+		if err := d.SelectTrack(5); !errors.Is(err, ErrNoTrack) {
+			t.Errorf("expected ErrNoTrack, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+// Package mp4 writes and reads AAC audio in ISO base media files (MP4, M4A, 3GP)
+// and cuts it into fragmented MP4/CMAF segments, using the AudioSpecificConfig
+// from EncInfo.ConfBuf of an encoder configured with TtMp4Raw.
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lizc2003/audio-fdkaac/asc"
+)
+
+// FileFormat selects the file brand. The values match fdkaac.FileFormat.
+type FileFormat int
+
+const (
+	FileFormat3gpp FileFormat = 3
+	FileFormatMp4  FileFormat = 4
+)
+
+const (
+	// MPEG-4 audio objectTypeIndication of the DecoderConfigDescriptor.
+	objectTypeAudio = 0x40
+	// AudioStream streamType with the reserved bit set.
+	streamTypeAudio = 0x05<<2 | 1
+	// ISO-639-2 "und" packed into 15 bits.
+	languageUnd = 0x55C4
+)
+
+var (
+	ErrInvalid     = errors.New("mp4: invalid data")
+	ErrUnsupported = errors.New("mp4: unsupported data")
+	ErrClosed      = errors.New("mp4: muxer is closed")
+)
+
+// track holds the sample description of an AAC track.
+type track struct {
+	config      *asc.AudioSpecificConfig
+	ascBuf      []byte
+	timescale   uint32
+	frameLength int
+	channels    int
+}
+
+// mediaTime converts samples at rate to the track timescale, rounding to the
+// nearest unit. Samples are already in the timescale if rate is 0.
+func (t *track) mediaTime(samples, rate int) int {
+	if rate <= 0 || rate == int(t.timescale) {
+		return samples
+	}
+	return int((int64(samples)*int64(t.timescale) + int64(rate)/2) / int64(rate))
+}
+
+func newTrack(conf []byte) (*track, error) {
+	config, err := asc.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	t := &track{
+		config:      config,
+		ascBuf:      append([]byte(nil), conf...),
+		timescale:   uint32(config.SampleRate()),
+		frameLength: config.FrameLength(),
+		channels:    config.Channels(),
+	}
+	if t.timescale == 0 || t.frameLength == 0 {
+		return nil, fmt.Errorf("%w: sample rate %d, frame length %d", ErrInvalid, t.timescale, t.frameLength)
+	}
+	if t.channels == 0 {
+		t.channels = 2
+	}
+	return t, nil
+}
+
+// bitrates returns the buffer size, the maximum bitrate over any one second
+// window and the average bitrate of the samples.
+func (t *track) bitrates(sizes []uint32) (bufferSize, maxBitrate, avgBitrate uint32) {
+	if len(sizes) == 0 {
+		return 0, 0, 0
+	}
+	window := int(math.Ceil(float64(t.timescale) / float64(t.frameLength)))
+	var total, sum, maxSum uint64
+	for i, sz := range sizes {
+		bufferSize = max(bufferSize, sz)
+		total += uint64(sz)
+		sum += uint64(sz)
+		if i >= window {
+			sum -= uint64(sizes[i-window])
+		}
+		maxSum = max(maxSum, sum)
+	}
+	seconds := float64(len(sizes)*t.frameLength) / float64(t.timescale)
+	maxBitrate = uint32(min(float64(maxSum*8)/min(seconds, 1), math.MaxUint32))
+	avgBitrate = uint32(min(float64(total*8)/seconds, math.MaxUint32))
+	return bufferSize, maxBitrate, avgBitrate
+}
+
+// writeStsd writes the sample description box with the mp4a entry.
+func (t *track) writeStsd(w *boxBuffer, bufferSize, maxBitrate, avgBitrate uint32) {
+	stsd := w.startFull("stsd", 0, 0)
+	w.u32(1)
+
+	mp4a := w.start("mp4a")
+	w.zeros(6)
+	w.u16(1) // data_reference_index
+	w.zeros(8)
+	w.u16(uint16(t.channels))
+	w.u16(16) // samplesize
+	w.zeros(4)
+	if t.timescale <= math.MaxUint16 {
+		w.u32(t.timescale << 16)
+	} else {
+		w.u32(0)
+	}
+
+	esds := w.startFull("esds", 0, 0)
+	es := w.descriptor(0x03)
+	w.u16(0) // ES_ID
+	w.u8(0)  // flags
+	dc := w.descriptor(0x04)
+	w.u8(objectTypeAudio)
+	w.u8(streamTypeAudio)
+	w.u8(uint8(bufferSize >> 16))
+	w.u16(uint16(bufferSize))
+	w.u32(maxBitrate)
+	w.u32(avgBitrate)
+	dsi := w.descriptor(0x05)
+	w.bytes(t.ascBuf)
+	w.endDescriptor(dsi)
+	w.endDescriptor(dc)
+	sl := w.descriptor(0x06)
+	w.u8(0x02) // predefined MP4
+	w.endDescriptor(sl)
+	w.endDescriptor(es)
+	w.end(esds)
+
+	w.end(mp4a)
+	w.end(stsd)
+}
+
+// writeFtyp writes the file type box.
+func writeFtyp(w *boxBuffer, format FileFormat) {
+	ftyp := w.start("ftyp")
+	if format == FileFormat3gpp {
+		w.str("3gp6")
+		w.u32(0)
+		w.str("3gp6isomiso2")
+	} else {
+		w.str("M4A ")
+		w.u32(0x200)
+		w.str("M4A mp42isom")
+	}
+	w.end(ftyp)
+}
+
+// writeHdlr writes the sound handler box.
+func writeHdlr(w *boxBuffer) {
+	hdlr := w.startFull("hdlr", 0, 0)
+	w.u32(0)
+	w.str("soun")
+	w.zeros(12)
+	w.str("SoundHandler\x00")
+	w.end(hdlr)
+}
+
+// writeDinf writes a data information box referring to the file itself.
+func writeDinf(w *boxBuffer) {
+	dinf := w.start("dinf")
+	dref := w.startFull("dref", 0, 0)
+	w.u32(1)
+	url := w.startFull("url ", 0, 1)
+	w.end(url)
+	w.end(dref)
+	w.end(dinf)
+}
+
+// writeMvhd writes the movie header box.
+func writeMvhd(w *boxBuffer, version uint8, timescale uint32, duration uint64, lastTrackID uint32) {
+	mvhd := w.startFull("mvhd", version, 0)
+	writeTimes(w, version, timescale, duration)
+	w.u32(0x00010000) // rate
+	w.u16(0x0100)     // volume
+	w.zeros(10)
+	w.matrix()
+	w.zeros(24)
+	w.u32(lastTrackID + 1) // next_track_ID
+	w.end(mvhd)
+}
+
+// writeTkhd writes the header box of an enabled audio track.
+func writeTkhd(w *boxBuffer, version uint8, trackID uint32, duration uint64) {
+	tkhd := w.startFull("tkhd", version, 3) // enabled, in movie
+	if version == 1 {
+		w.u64(0)
+		w.u64(0)
+	} else {
+		w.u32(0)
+		w.u32(0)
+	}
+	w.u32(trackID)
+	w.u32(0)
+	if version == 1 {
+		w.u64(duration)
+	} else {
+		w.u32(uint32(duration))
+	}
+	w.zeros(8)
+	w.u16(0)      // layer
+	w.u16(1)      // alternate_group
+	w.u16(0x0100) // volume
+	w.u16(0)
+	w.matrix()
+	w.u32(0) // width
+	w.u32(0) // height
+	w.end(tkhd)
+}
+
+// writeMdhd writes the media header box.
+func writeMdhd(w *boxBuffer, version uint8, timescale uint32, duration uint64) {
+	mdhd := w.startFull("mdhd", version, 0)
+	writeTimes(w, version, timescale, duration)
+	w.u16(languageUnd)
+	w.u16(0)
+	w.end(mdhd)
+}
+
+// writeSmhd writes the sound media header box.
+func writeSmhd(w *boxBuffer) {
+	smhd := w.startFull("smhd", 0, 0)
+	w.u32(0) // balance
+	w.end(smhd)
+}
+
+// writeTimes writes creation and modification time, timescale and duration of mvhd and mdhd.
+func writeTimes(w *boxBuffer, version uint8, timescale uint32, duration uint64) {
+	if version == 1 {
+		w.u64(0)
+		w.u64(0)
+		w.u32(timescale)
+		w.u64(duration)
+	} else {
+		w.u32(0)
+		w.u32(0)
+		w.u32(timescale)
+		w.u32(uint32(duration))
+	}
+}
@@ -0,0 +1,334 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Size of the free box reserved in front of mdat for a 64-bit mdat size.
+const wideBoxSize = 8
+
+// MuxerConfig configures a Muxer.
+type MuxerConfig struct {
+	// File brand, FileFormatMp4 if 0.
+	FileFormat FileFormat
+	// Write moov in front of mdat, so that playback can start before the whole
+	// file is downloaded. All access units are kept in memory until Close, as
+	// much as the size of the encoded stream; write a regular file and move
+	// moov afterwards for long recordings.
+	Faststart bool
+	// Number of priming samples to be skipped by the player, e.g. EncInfo.NDelay.
+	// Written as an edit list.
+	Delay int
+	// Sample rate Delay is counted in, the decoder output rate, e.g.
+	// EncoderConfig.SampleRate. With implicitly signaled SBR it is twice the
+	// track timescale. The track timescale if 0.
+	SampleRate int
+}
+
+// Muxer writes an AAC track to an MP4 file.
+type Muxer struct {
+	w         io.WriteSeeker
+	track     *track
+	format    FileFormat
+	faststart bool
+	delay     int
+
+	base         int64
+	pos          int64
+	mdatPos      int64
+	chunkSamples int
+	sizes        []uint32
+	chunkOffsets []uint64
+	pending      [][]byte
+	closed       bool
+}
+
+// NewMuxer returns a Muxer writing to w, which should be positioned at the
+// start of the file. conf is the AudioSpecificConfig, e.g. EncInfo.ConfBuf.
+// The frame length, 1024, 960, 512 or 480 samples, and the sample rate are
+// taken from conf.
+func NewMuxer(w io.WriteSeeker, conf []byte, config *MuxerConfig) (*Muxer, error) {
+	t, err := newTrack(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Muxer{
+		w:      w,
+		track:  t,
+		format: FileFormatMp4,
+	}
+	if config != nil {
+		if config.FileFormat != 0 {
+			m.format = config.FileFormat
+		}
+		m.faststart = config.Faststart
+		m.delay = config.Delay
+	}
+	if m.format != FileFormatMp4 && m.format != FileFormat3gpp {
+		return nil, fmt.Errorf("%w: file format %d", ErrUnsupported, m.format)
+	}
+	if m.delay < 0 {
+		return nil, fmt.Errorf("%w: delay %d", ErrInvalid, m.delay)
+	}
+	if config != nil {
+		m.delay = t.mediaTime(m.delay, config.SampleRate)
+	}
+	// About one second of samples per chunk.
+	m.chunkSamples = int(math.Ceil(float64(t.timescale) / float64(t.frameLength)))
+
+	if m.base, err = w.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if !m.faststart {
+		buf := &boxBuffer{}
+		writeFtyp(buf, m.format)
+		// Room for a 64-bit mdat size, see Close.
+		free := buf.start("free")
+		buf.end(free)
+		m.mdatPos = int64(len(buf.b))
+		mdat := buf.start("mdat")
+		buf.end(mdat)
+		if err = m.write(buf.b); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WriteAU adds one access unit of FrameLength samples.
+func (m *Muxer) WriteAU(au []byte) error {
+	if m.closed {
+		return ErrClosed
+	}
+	if len(au) == 0 {
+		return fmt.Errorf("%w: empty access unit", ErrInvalid)
+	}
+	if len(m.sizes) == math.MaxUint32 {
+		return fmt.Errorf("%w: too many samples", ErrUnsupported)
+	}
+
+	if m.faststart {
+		m.pending = append(m.pending, append([]byte(nil), au...))
+	} else {
+		if len(m.sizes)%m.chunkSamples == 0 {
+			m.chunkOffsets = append(m.chunkOffsets, uint64(m.pos))
+		}
+		if err := m.write(au); err != nil {
+			return err
+		}
+	}
+	m.sizes = append(m.sizes, uint32(len(au)))
+	return nil
+}
+
+// Close writes the moov box and completes the file. It does not close the
+// underlying writer.
+func (m *Muxer) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	if m.faststart {
+		return m.writeFaststart()
+	}
+
+	// Patch the mdat size, using the free box for a 64-bit size if needed.
+	mdatSize := uint64(m.pos - m.mdatPos)
+	var hdr []byte
+	hdrPos := m.mdatPos
+	if mdatSize > math.MaxUint32 {
+		hdrPos -= wideBoxSize
+		hdr = binary.BigEndian.AppendUint32(hdr, 1)
+		hdr = append(hdr, "mdat"...)
+		hdr = binary.BigEndian.AppendUint64(hdr, mdatSize+wideBoxSize)
+	} else {
+		hdr = binary.BigEndian.AppendUint32(hdr, uint32(mdatSize))
+	}
+	if _, err := m.w.Seek(m.base+hdrPos, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := m.w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := m.w.Seek(m.base+m.pos, io.SeekStart); err != nil {
+		return err
+	}
+
+	return m.write(m.moov(m.chunkOffsets))
+}
+
+func (m *Muxer) writeFaststart() error {
+	buf := &boxBuffer{}
+	writeFtyp(buf, m.format)
+
+	var mdatSize uint64 = 8
+	for _, sz := range m.sizes {
+		mdatSize += uint64(sz)
+	}
+	mdatHdrSize := 8
+	if mdatSize > math.MaxUint32 {
+		mdatHdrSize = 16
+	}
+
+	// The chunk offsets depend on the size of moov, which does not depend on
+	// the offset values but on whether co64 is needed.
+	offsets := make([]uint64, (len(m.sizes)+m.chunkSamples-1)/m.chunkSamples)
+	var moov []byte
+	for _, large := range []bool{false, true} {
+		if large {
+			offsets[len(offsets)-1] = math.MaxUint32 + 1
+		}
+		moov = m.moov(offsets)
+		pos := uint64(len(buf.b) + len(moov) + mdatHdrSize)
+		for i, sz := range m.sizes {
+			if i%m.chunkSamples == 0 {
+				offsets[i/m.chunkSamples] = pos
+			}
+			pos += uint64(sz)
+		}
+		if len(offsets) == 0 || offsets[len(offsets)-1] <= math.MaxUint32 {
+			break
+		}
+	}
+	moov = m.moov(offsets)
+	buf.bytes(moov)
+
+	if mdatHdrSize == 16 {
+		buf.u32(1)
+		buf.str("mdat")
+		buf.u64(mdatSize + 8)
+	} else {
+		buf.u32(uint32(mdatSize))
+		buf.str("mdat")
+	}
+	if err := m.write(buf.b); err != nil {
+		return err
+	}
+	for _, au := range m.pending {
+		if err := m.write(au); err != nil {
+			return err
+		}
+	}
+	m.pending = nil
+	return nil
+}
+
+func (m *Muxer) write(p []byte) error {
+	n, err := m.w.Write(p)
+	m.pos += int64(n)
+	return err
+}
+
+// moov builds the movie box.
+func (m *Muxer) moov(chunkOffsets []uint64) []byte {
+	t := m.track
+	w := &boxBuffer{}
+	duration := uint64(len(m.sizes)) * uint64(t.frameLength)
+	movieDuration := duration
+	if m.delay > 0 {
+		movieDuration -= min(uint64(m.delay), duration)
+	}
+	var version uint8
+	if duration > math.MaxUint32 {
+		version = 1
+	}
+
+	moov := w.start("moov")
+
+	writeMvhd(w, version, t.timescale, movieDuration, 1)
+	trak := w.start("trak")
+	writeTkhd(w, version, 1, movieDuration)
+
+	if m.delay > 0 {
+		edts := w.start("edts")
+		elst := w.startFull("elst", version, 0)
+		w.u32(1)
+		if version == 1 {
+			w.u64(movieDuration)
+			w.u64(uint64(m.delay))
+		} else {
+			w.u32(uint32(movieDuration))
+			w.u32(uint32(m.delay))
+		}
+		w.u32(0x00010000) // media_rate
+		w.end(elst)
+		w.end(edts)
+	}
+
+	mdia := w.start("mdia")
+	writeMdhd(w, version, t.timescale, duration)
+	writeHdlr(w)
+
+	minf := w.start("minf")
+	writeSmhd(w)
+	writeDinf(w)
+
+	stbl := w.start("stbl")
+	bufferSize, maxBitrate, avgBitrate := t.bitrates(m.sizes)
+	t.writeStsd(w, bufferSize, maxBitrate, avgBitrate)
+
+	stts := w.startFull("stts", 0, 0)
+	if len(m.sizes) > 0 {
+		w.u32(1)
+		w.u32(uint32(len(m.sizes)))
+		w.u32(uint32(t.frameLength))
+	} else {
+		w.u32(0)
+	}
+	w.end(stts)
+
+	stsc := w.startFull("stsc", 0, 0)
+	full := len(m.sizes) / m.chunkSamples
+	last := len(m.sizes) % m.chunkSamples
+	var entries [][2]int
+	if full > 0 {
+		entries = append(entries, [2]int{1, m.chunkSamples})
+	}
+	if last > 0 {
+		entries = append(entries, [2]int{full + 1, last})
+	}
+	w.u32(uint32(len(entries)))
+	for _, e := range entries {
+		w.u32(uint32(e[0]))
+		w.u32(uint32(e[1]))
+		w.u32(1) // sample_description_index
+	}
+	w.end(stsc)
+
+	stsz := w.startFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(uint32(len(m.sizes)))
+	for _, sz := range m.sizes {
+		w.u32(sz)
+	}
+	w.end(stsz)
+
+	co64 := len(chunkOffsets) > 0 && chunkOffsets[len(chunkOffsets)-1] > math.MaxUint32
+	if co64 {
+		stco := w.startFull("co64", 0, 0)
+		w.u32(uint32(len(chunkOffsets)))
+		for _, off := range chunkOffsets {
+			w.u64(off)
+		}
+		w.end(stco)
+	} else {
+		stco := w.startFull("stco", 0, 0)
+		w.u32(uint32(len(chunkOffsets)))
+		for _, off := range chunkOffsets {
+			w.u32(uint32(off))
+		}
+		w.end(stco)
+	}
+
+	w.end(stbl)
+	w.end(minf)
+	w.end(mdia)
+	w.end(trak)
+	w.end(moov)
+	return w.b
+}
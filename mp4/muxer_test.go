@@ -0,0 +1,169 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+// findBox returns the payload of the first box with the given path.
+func findBox(b []byte, path ...string) []byte {
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			return nil
+		}
+		if string(b[4:8]) == path[0] {
+			if len(path) == 1 {
+				return b[8:size]
+			}
+			payload := b[8:size]
+			switch path[0] {
+			case "stsd":
+				payload = payload[8:]
+			case "mp4a":
+				payload = payload[28:]
+			}
+			return findBox(payload, path[1:]...)
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+func topLevelBoxes(b []byte) []string {
+	var types []string
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		types = append(types, string(b[4:8]))
+		if size < 8 || size > len(b) {
+			break
+		}
+		b = b[size:]
+	}
+	return types
+}
+
+func TestMuxer(t *testing.T) {
+	for _, faststart := range []bool{false, true} {
+		f := &testutil.File{}
+		m, err := NewMuxer(f, []byte{0x12, 0x10}, &MuxerConfig{Faststart: faststart, Delay: 2048})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		aus := testutil.AUs(100)
+		for _, au := range aus {
+			if err := m.WriteAU(au); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if err := m.WriteAU(aus[0]); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+
+		want := []string{"ftyp", "free", "mdat", "moov"}
+		if faststart {
+			want = []string{"ftyp", "moov", "mdat"}
+		}
+		if got := topLevelBoxes(f.B); len(got) != len(want) || got[len(got)-1] != want[len(want)-1] || got[1] != want[1] {
+			t.Errorf("faststart %v: expected boxes %v, got %v", faststart, want, got)
+		}
+
+		stbl := []string{"moov", "trak", "mdia", "minf", "stbl"}
+		mdhd := findBox(f.B, "moov", "trak", "mdia", "mdhd")
+		if len(mdhd) < 20 || binary.BigEndian.Uint32(mdhd[12:]) != 44100 ||
+			binary.BigEndian.Uint32(mdhd[16:]) != 100*1024 {
+			t.Errorf("unexpected mdhd % x", mdhd)
+		}
+		elst := findBox(f.B, "moov", "trak", "edts", "elst")
+		if len(elst) < 16 || binary.BigEndian.Uint32(elst[8:]) != 100*1024-2048 ||
+			binary.BigEndian.Uint32(elst[12:]) != 2048 {
+			t.Errorf("unexpected elst % x", elst)
+		}
+		stts := findBox(f.B, append(stbl, "stts")...)
+		if !bytes.Equal(stts, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 100, 0, 0, 4, 0}) {
+			t.Errorf("unexpected stts % x", stts)
+		}
+		esds := findBox(f.B, append(stbl, "stsd", "mp4a", "esds")...)
+		if !bytes.Contains(esds, []byte{0x05, 0x80, 0x80, 0x80, 0x02, 0x12, 0x10}) {
+			t.Errorf("esds without AudioSpecificConfig % x", esds)
+		}
+
+		// Check that the chunk offsets point at the access units.
+		stsz := findBox(f.B, append(stbl, "stsz")...)
+		stco := findBox(f.B, append(stbl, "stco")...)
+		if binary.BigEndian.Uint32(stsz[8:]) != 100 || binary.BigEndian.Uint32(stco[4:]) != 3 {
+			t.Fatalf("expected 100 samples in 3 chunks, got % x / % x", stsz[:12], stco[:8])
+		}
+		chunkSamples := m.chunkSamples
+		for c := 0; c < 3; c++ {
+			off := int(binary.BigEndian.Uint32(stco[8+4*c:]))
+			i := c * chunkSamples
+			if !bytes.Equal(f.B[off:off+len(aus[i])], aus[i]) {
+				t.Errorf("faststart %v: chunk %d does not start with sample %d", faststart, c, i)
+			}
+		}
+	}
+
+	t.Run("Delay at the output rate", func(t *testing.T) {
+		// Implicitly signaled SBR, the timescale is the core rate of 22.05 kHz.
+		f := &testutil.File{}
+		m, err := NewMuxer(f, []byte{0x13, 0x90}, &MuxerConfig{Delay: 2048, SampleRate: 44100})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		for _, au := range testutil.AUs(10) {
+			m.WriteAU(au)
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		elst := findBox(f.B, "moov", "trak", "edts", "elst")
+		if len(elst) < 16 || binary.BigEndian.Uint32(elst[8:]) != 10*1024-1024 ||
+			binary.BigEndian.Uint32(elst[12:]) != 1024 {
+			t.Errorf("unexpected elst % x", elst)
+		}
+	})
+
+	t.Run("co64", func(t *testing.T) {
+		m, err := NewMuxer(&testutil.File{}, []byte{0x12, 0x10}, nil)
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		m.sizes = []uint32{100, 100}
+		moov := m.moov([]uint64{math.MaxUint32 + 10})
+		co64 := findBox(moov, "moov", "trak", "mdia", "minf", "stbl", "co64")
+		if len(co64) != 16 || binary.BigEndian.Uint64(co64[8:]) != math.MaxUint32+10 {
+			t.Errorf("unexpected co64 % x", co64)
+		}
+	})
+
+	t.Run("Frame lengths", func(t *testing.T) {
+		confs := map[int][]byte{
+			1024: {0x12, 0x10},
+			960:  {0x12, 0x14},
+			512:  {0xB9, 0x91, 0x00}, // ER AAC LD 48 kHz stereo
+			480:  {0xB9, 0x95, 0x00},
+		}
+		for frameLength, conf := range confs {
+			f := &testutil.File{}
+			m, err := NewMuxer(f, conf, nil)
+			if err != nil {
+				t.Fatalf("NewMuxer %d failed: %v", frameLength, err)
+			}
+			m.WriteAU([]byte{1})
+			m.Close()
+			stts := findBox(f.B, "moov", "trak", "mdia", "minf", "stbl", "stts")
+			if len(stts) != 16 || int(binary.BigEndian.Uint32(stts[12:])) != frameLength {
+				t.Errorf("expected sample delta %d, got % x", frameLength, stts)
+			}
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// Default target duration of a media segment.
+	DefaultSegmentDuration = 2 * time.Second
+
+	// tfhd flags.
+	tfhdDefaultSampleDuration = 0x000008
+	tfhdDefaultSampleFlags    = 0x000020
+	tfhdDefaultBaseIsMoof     = 0x020000
+	// trun flags.
+	trunDataOffset = 0x000001
+	trunSampleSize = 0x000200
+	// Sample flags of a sync sample that does not depend on other samples.
+	sampleFlagsSync = 0x02000000
+)
+
+// SegmenterConfig configures a Segmenter.
+type SegmenterConfig struct {
+	// Target duration of the media segments (default DefaultSegmentDuration).
+	SegmentDuration time.Duration
+	// track_ID of the track (default 1).
+	TrackID int
+	// Bitrate written to the esds of the init segment, if known.
+	Bitrate int
+	// Number of priming samples to be skipped by the player, e.g. EncInfo.NDelay.
+	// Written as an edit list in the init segment.
+	Delay int
+	// Sample rate Delay is counted in, see MuxerConfig.SampleRate.
+	SampleRate int
+}
+
+// Segment is a CMAF media segment.
+type Segment struct {
+	// styp, moof and mdat boxes.
+	Data []byte
+	// Sequence number of moof, starting at 1.
+	Sequence uint32
+	// Decode time of the first sample in Timescale units, the tfdt value.
+	DecodeTime uint64
+	// Duration in Timescale units.
+	Duration uint64
+	// Number of access units.
+	Samples int
+}
+
+// Segmenter cuts an AAC stream into a CMAF init segment and media segments for
+// DASH and HLS, at the access units chosen by a Splitter.
+type Segmenter struct {
+	track      *track
+	trackID    uint32
+	bitrate    uint32
+	delay      int
+	splitter   *Splitter
+	sequence   uint32
+	decodeTime uint64
+	sizes      []uint32
+	data       []byte
+}
+
+// Splitter chooses the access units that end the segments of a stream.
+// Segments end on the first access unit boundary at or after a multiple of
+// the segment duration, so the segment boundaries do not drift.
+type Splitter struct {
+	target   uint64
+	boundary uint64
+	end      uint64
+}
+
+// NewSplitter returns a Splitter for segments of the target duration, given in
+// the unit of the access unit durations passed to Add.
+func NewSplitter(target uint64) (*Splitter, error) {
+	if target == 0 {
+		return nil, fmt.Errorf("%w: segment duration 0", ErrInvalid)
+	}
+	return &Splitter{target: target, boundary: target}, nil
+}
+
+// Add adds an access unit of the given duration and reports whether it ends a
+// segment.
+func (s *Splitter) Add(duration uint64) bool {
+	s.end += duration
+	if s.end < s.boundary {
+		return false
+	}
+	for s.boundary <= s.end {
+		s.boundary += s.target
+	}
+	return true
+}
+
+// NewSegmenter returns a Segmenter for the stream described by conf, the
+// AudioSpecificConfig from EncInfo.ConfBuf.
+func NewSegmenter(conf []byte, config *SegmenterConfig) (*Segmenter, error) {
+	t, err := newTrack(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Segmenter{
+		track:   t,
+		trackID: 1,
+	}
+	duration := DefaultSegmentDuration
+	if config != nil {
+		if config.SegmentDuration > 0 {
+			duration = config.SegmentDuration
+		}
+		if config.TrackID > 0 {
+			s.trackID = uint32(config.TrackID)
+		}
+		if config.Bitrate > 0 {
+			s.bitrate = uint32(config.Bitrate)
+		}
+		if config.Delay > 0 {
+			s.delay = t.mediaTime(config.Delay, config.SampleRate)
+		}
+	}
+	target := uint64(duration) * uint64(t.timescale) / uint64(time.Second)
+	if s.splitter, err = NewSplitter(target); err != nil {
+		return nil, fmt.Errorf("%w: segment duration %v", ErrInvalid, duration)
+	}
+	return s, nil
+}
+
+// Timescale returns the timescale of the track, the sample rate.
+func (s *Segmenter) Timescale() uint32 {
+	return s.track.timescale
+}
+
+// FrameLength returns the duration of an access unit in Timescale units.
+func (s *Segmenter) FrameLength() int {
+	return s.track.frameLength
+}
+
+// InitSegment returns the init segment with ftyp and moov.
+func (s *Segmenter) InitSegment() []byte {
+	t := s.track
+	w := &boxBuffer{}
+
+	ftyp := w.start("ftyp")
+	w.str("iso6")
+	w.u32(0)
+	w.str("iso6cmfcdash")
+	w.end(ftyp)
+
+	moov := w.start("moov")
+	writeMvhd(w, 0, t.timescale, 0, s.trackID)
+	trak := w.start("trak")
+	writeTkhd(w, 0, s.trackID, 0)
+	if s.delay > 0 {
+		// The duration of the fragments is not known, an edit of duration 0
+		// spans the whole track.
+		edts := w.start("edts")
+		elst := w.startFull("elst", 0, 0)
+		w.u32(1)
+		w.u32(0)
+		w.u32(uint32(s.delay))
+		w.u32(0x00010000) // media_rate
+		w.end(elst)
+		w.end(edts)
+	}
+	mdia := w.start("mdia")
+	writeMdhd(w, 0, t.timescale, 0)
+	writeHdlr(w)
+	minf := w.start("minf")
+	writeSmhd(w)
+	writeDinf(w)
+
+	stbl := w.start("stbl")
+	t.writeStsd(w, 0, s.bitrate, s.bitrate)
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		box := w.startFull(typ, 0, 0)
+		w.u32(0)
+		w.end(box)
+	}
+	stsz := w.startFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.end(stsz)
+	w.end(stbl)
+
+	w.end(minf)
+	w.end(mdia)
+	w.end(trak)
+
+	mvex := w.start("mvex")
+	trex := w.startFull("trex", 0, 0)
+	w.u32(s.trackID)
+	w.u32(1) // default_sample_description_index
+	w.u32(uint32(t.frameLength))
+	w.u32(0) // default_sample_size
+	w.u32(sampleFlagsSync)
+	w.end(trex)
+	w.end(mvex)
+
+	w.end(moov)
+	return w.b
+}
+
+// WriteAU adds an access unit. It returns the completed segment when the
+// access unit reaches the segment boundary, otherwise nil.
+func (s *Segmenter) WriteAU(au []byte) (*Segment, error) {
+	if len(au) == 0 {
+		return nil, fmt.Errorf("%w: empty access unit", ErrInvalid)
+	}
+	s.sizes = append(s.sizes, uint32(len(au)))
+	s.data = append(s.data, au...)
+	if !s.splitter.Add(uint64(s.track.frameLength)) {
+		return nil, nil
+	}
+	return s.segment(), nil
+}
+
+// Flush returns the last, possibly shorter, segment, or nil if there are no
+// pending access units.
+func (s *Segmenter) Flush() *Segment {
+	if len(s.sizes) == 0 {
+		return nil
+	}
+	return s.segment()
+}
+
+// segment builds the media segment of the pending access units.
+func (s *Segmenter) segment() *Segment {
+	s.sequence++
+	w := &boxBuffer{}
+
+	styp := w.start("styp")
+	w.str("msdh")
+	w.u32(0)
+	w.str("msdhcmfs")
+	w.end(styp)
+
+	moofPos := len(w.b)
+	moof := w.start("moof")
+	mfhd := w.startFull("mfhd", 0, 0)
+	w.u32(s.sequence)
+	w.end(mfhd)
+
+	traf := w.start("traf")
+	tfhd := w.startFull("tfhd", 0, tfhdDefaultBaseIsMoof|tfhdDefaultSampleDuration|tfhdDefaultSampleFlags)
+	w.u32(s.trackID)
+	w.u32(uint32(s.track.frameLength))
+	w.u32(sampleFlagsSync)
+	w.end(tfhd)
+
+	tfdt := w.startFull("tfdt", 1, 0)
+	w.u64(s.decodeTime)
+	w.end(tfdt)
+
+	trun := w.startFull("trun", 0, trunDataOffset|trunSampleSize)
+	w.u32(uint32(len(s.sizes)))
+	dataOffsetPos := len(w.b)
+	w.u32(0)
+	for _, sz := range s.sizes {
+		w.u32(sz)
+	}
+	w.end(trun)
+	w.end(traf)
+	w.end(moof)
+
+	// The data offset is relative to the start of moof and points past the mdat header.
+	binary.BigEndian.PutUint32(w.b[dataOffsetPos:], uint32(len(w.b)-moofPos+8))
+	w.u32(uint32(8 + len(s.data)))
+	w.str("mdat")
+	w.bytes(s.data)
+
+	seg := &Segment{
+		Data:       w.b,
+		Sequence:   s.sequence,
+		DecodeTime: s.decodeTime,
+		Duration:   uint64(len(s.sizes) * s.track.frameLength),
+		Samples:    len(s.sizes),
+	}
+	s.decodeTime += seg.Duration
+	s.sizes = s.sizes[:0]
+	s.data = nil
+	return seg
+}
@@ -0,0 +1,138 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+func TestSegmenter(t *testing.T) {
+	t.Run("Splitter", func(t *testing.T) {
+		s, err := NewSplitter(10)
+		if err != nil {
+			t.Fatalf("NewSplitter failed: %v", err)
+		}
+		// Access units of 3 end at 12, 21 and 30, the first ends at or after 10, 20 and 30.
+		var ends []int
+		for i := 1; i <= 10; i++ {
+			if s.Add(3) {
+				ends = append(ends, 3*i)
+			}
+		}
+		if len(ends) != 3 || ends[0] != 12 || ends[1] != 21 || ends[2] != 30 {
+			t.Errorf("expected segments ending at 12, 21 and 30, got %v", ends)
+		}
+		if _, err := NewSplitter(0); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Init segment", func(t *testing.T) {
+		s, err := NewSegmenter([]byte{0x12, 0x10}, &SegmenterConfig{TrackID: 2, Bitrate: 128000, Delay: 2048})
+		if err != nil {
+			t.Fatalf("NewSegmenter failed: %v", err)
+		}
+		init := s.InitSegment()
+		if got := topLevelBoxes(init); len(got) != 2 || got[0] != "ftyp" || got[1] != "moov" {
+			t.Errorf("expected ftyp and moov, got %v", got)
+		}
+		trex := findBox(init, "moov", "mvex", "trex")
+		if len(trex) != 24 || binary.BigEndian.Uint32(trex[4:]) != 2 || binary.BigEndian.Uint32(trex[12:]) != 1024 {
+			t.Errorf("unexpected trex % x", trex)
+		}
+		elst := findBox(init, "moov", "trak", "edts", "elst")
+		if len(elst) != 20 || binary.BigEndian.Uint32(elst[8:]) != 0 || binary.BigEndian.Uint32(elst[12:]) != 2048 {
+			t.Errorf("unexpected elst % x", elst)
+		}
+		esds := findBox(init, "moov", "trak", "mdia", "minf", "stbl", "stsd", "mp4a", "esds")
+		if !bytes.Contains(esds, []byte{0x00, 0x01, 0xF4, 0x00, 0x00, 0x01, 0xF4, 0x00}) {
+			t.Errorf("esds without bitrate % x", esds)
+		}
+	})
+
+	cases := []struct {
+		name      string
+		conf      []byte
+		timescale uint64
+		frameLen  int
+		counts    []int
+	}{
+		// 2 s at 44.1 kHz is 86.13 frames, the boundaries must not drift.
+		{"1024", []byte{0x12, 0x10}, 44100, 1024, []int{87, 86, 86, 86, 14}},
+		{"960", []byte{0x11, 0x94}, 48000, 960, []int{100, 100, 100, 100, 10}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := NewSegmenter(c.conf, &SegmenterConfig{SegmentDuration: 2 * time.Second})
+			if err != nil {
+				t.Fatalf("NewSegmenter failed: %v", err)
+			}
+			if uint64(s.Timescale()) != c.timescale || s.FrameLength() != c.frameLen {
+				t.Fatalf("unexpected timescale %d, frame length %d", s.Timescale(), s.FrameLength())
+			}
+
+			total := 0
+			for _, n := range c.counts {
+				total += n
+			}
+			aus := testutil.AUs(total)
+			var segs []*Segment
+			for _, au := range aus {
+				seg, err := s.WriteAU(au)
+				if err != nil {
+					t.Fatalf("WriteAU failed: %v", err)
+				}
+				if seg != nil {
+					segs = append(segs, seg)
+				}
+			}
+			if seg := s.Flush(); seg != nil {
+				segs = append(segs, seg)
+			}
+			if len(segs) != len(c.counts) {
+				t.Fatalf("expected %d segments, got %d", len(c.counts), len(segs))
+			}
+
+			var decodeTime uint64
+			i := 0
+			for k, seg := range segs {
+				if seg.Samples != c.counts[k] || seg.Sequence != uint32(k+1) || seg.DecodeTime != decodeTime {
+					t.Errorf("segment %d: expected %d samples at %d, got %d at %d",
+						k, c.counts[k], decodeTime, seg.Samples, seg.DecodeTime)
+				}
+				if seg.Duration != uint64(seg.Samples*c.frameLen) {
+					t.Errorf("segment %d: unexpected duration %d", k, seg.Duration)
+				}
+				decodeTime += seg.Duration
+
+				// No sidx is written, so the brands do not include msix.
+				if styp := findBox(seg.Data, "styp"); !bytes.Equal(styp, []byte("msdh\x00\x00\x00\x00msdhcmfs")) {
+					t.Errorf("segment %d: unexpected styp %q", k, styp)
+				}
+				tfdt := findBox(seg.Data, "moof", "traf", "tfdt")
+				if len(tfdt) != 12 || binary.BigEndian.Uint64(tfdt[4:]) != seg.DecodeTime {
+					t.Errorf("segment %d: unexpected tfdt % x", k, tfdt)
+				}
+				trun := findBox(seg.Data, "moof", "traf", "trun")
+				if int(binary.BigEndian.Uint32(trun[4:])) != seg.Samples {
+					t.Errorf("segment %d: unexpected trun sample count", k)
+				}
+				// The data offset is relative to moof, which follows styp.
+				moofPos := int(binary.BigEndian.Uint32(seg.Data))
+				off := moofPos + int(binary.BigEndian.Uint32(trun[8:]))
+				for n := 0; n < seg.Samples; n++ {
+					sz := int(binary.BigEndian.Uint32(trun[12+4*n:]))
+					if !bytes.Equal(seg.Data[off:off+sz], aus[i]) {
+						t.Errorf("segment %d: sample %d differs", k, n)
+					}
+					off += sz
+					i++
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package fdkaac
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// EncodeFloat32 encodes interleaved float32 samples in the range [-1, 1].
+// Samples outside that range are clipped. If the encoder was created with
+// InputDither, TPDF dither is added before the samples are quantized to 16 bits.
+// The results are the same as for Encode.
+func (enc *Encoder) EncodeFloat32(in []float32, out []byte) (n int, nFrames int, err error) {
+	if len(in) == 0 {
+		return 0, 0, errors.New("input buffer is empty")
+	}
+
+	buf := enc.pcmBuffer(len(in))
+	for i, v := range in {
+		x := float64(v) * 32768
+		if enc.dither {
+			x += enc.tpdf()
+		}
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(clip16(math.Round(x))))
+	}
+	return enc.Encode(buf, out)
+}
+
+// EncodeInt32 encodes interleaved int32 samples using the full 32-bit range.
+// If the encoder was created with InputDither, TPDF dither is added before the
+// samples are quantized to 16 bits. The results are the same as for Encode.
+func (enc *Encoder) EncodeInt32(in []int32, out []byte) (n int, nFrames int, err error) {
+	if len(in) == 0 {
+		return 0, 0, errors.New("input buffer is empty")
+	}
+
+	buf := enc.pcmBuffer(len(in))
+	for i, v := range in {
+		var s int16
+		if enc.dither {
+			s = clip16(math.Round(float64(v)/65536 + enc.tpdf()))
+		} else {
+			// Round to nearest by adding half an LSB before the shift.
+			s = clip16(float64((int64(v) + 0x8000) >> 16))
+		}
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(s))
+	}
+	return enc.Encode(buf, out)
+}
+
+// pcmBuffer returns a reusable buffer for nSamples 16-bit samples.
+func (enc *Encoder) pcmBuffer(nSamples int) []byte {
+	sz := nSamples * SampleBitDepth / 8
+	if cap(enc.pcmBuf) < sz {
+		enc.pcmBuf = make([]byte, sz)
+	}
+	return enc.pcmBuf[:sz]
+}
+
+// tpdf returns triangular dither noise with an amplitude of +/- 1 LSB.
+func (enc *Encoder) tpdf() float64 {
+	return enc.rand() - enc.rand()
+}
+
+// rand returns a uniformly distributed value in [0, 1) from a xorshift generator.
+func (enc *Encoder) rand() float64 {
+	x := enc.ditherState
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	enc.ditherState = x
+	return float64(x>>11) / (1 << 53)
+}
+
+// DecodeFloat32 works like Decode but writes interleaved float32 samples in the
+// range [-1, 1) to out. out must hold at least EstimateOutBufBytes(EstimateFrames)/2
+// samples. Returns the number of samples written, including all channels.
+func (dec *Decoder) DecodeFloat32(in []byte, out []float32) (n int, err error) {
+	sz := dec.EstimateOutBufBytes(EstimateFrames)
+	if len(out) < sz/2 {
+		return 0, errors.New("output buffer size is not enough")
+	}
+	if cap(dec.pcmBuf) < sz {
+		dec.pcmBuf = make([]byte, sz)
+	}
+	buf := dec.pcmBuf[:sz]
+
+	nBytes, err := dec.Decode(in, buf)
+	if err != nil {
+		return 0, err
+	}
+
+	n = nBytes / 2
+	for i := 0; i < n; i++ {
+		out[i] = float32(int16(binary.LittleEndian.Uint16(buf[i*2:]))) / 32768
+	}
+	return n, nil
+}
+
+// clip16 saturates x to the 16-bit sample range.
+func clip16(x float64) int16 {
+	if math.IsNaN(x) {
+		return 0
+	}
+	if x > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if x < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(x)
+}
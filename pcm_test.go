@@ -0,0 +1,84 @@
+package fdkaac
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestPcmConvert(t *testing.T) {
+	t.Run("Clip", func(t *testing.T) {
+		cases := []struct {
+			in   float64
+			want int16
+		}{
+			{0, 0},
+			{32767, 32767},
+			{40000, 32767},
+			{-32768, -32768},
+			{-40000, -32768},
+			{math.NaN(), 0},
+		}
+		for _, c := range cases {
+			if got := clip16(c.in); got != c.want {
+				t.Errorf("clip16(%v): expected %d, got %d", c.in, c.want, got)
+			}
+		}
+	})
+
+	t.Run("Encode float32 and int32", func(t *testing.T) {
+		encoder, err := NewEncoder(&EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     64000,
+		})
+		if err != nil {
+			t.Fatalf("CreateAacEncoder failed: %v", err)
+		}
+		defer encoder.Close()
+
+		f32 := make([]float32, len(PCM0)/2)
+		i32 := make([]int32, len(PCM0)/2)
+		for i := range f32 {
+			s := int16(binary.LittleEndian.Uint16(PCM0[i*2:]))
+			f32[i] = float32(s) / 32768
+			i32[i] = int32(s) << 16
+		}
+
+		output := make([]byte, 8192)
+		if _, _, err = encoder.EncodeFloat32(f32, output); err != nil {
+			t.Errorf("EncodeFloat32 failed: %v", err)
+		}
+		if _, _, err = encoder.EncodeInt32(i32, output); err != nil {
+			t.Errorf("EncodeInt32 failed: %v", err)
+		}
+		if got := binary.LittleEndian.Uint16(encoder.pcmBuf); got != binary.LittleEndian.Uint16(PCM0) {
+			t.Errorf("expected first converted sample 0x%x, got 0x%x", binary.LittleEndian.Uint16(PCM0), got)
+		}
+	})
+
+	t.Run("Decode float32", func(t *testing.T) {
+		decoder, err := NewDecoder(&DecoderConfig{
+			TransportFmt: TtMp4Adts,
+		})
+		if err != nil {
+			t.Fatalf("CreateAccDecoder failed: %v", err)
+		}
+		defer decoder.Close()
+
+		out := make([]float32, decoder.EstimateOutBufBytes(EstimateFrames)/2)
+		n, err := decoder.DecodeFloat32(AAC0, out)
+		if err != nil {
+			t.Errorf("DecodeFloat32 failed: %v", err)
+		}
+		if n != 2048 {
+			t.Errorf("expected 2048 samples, got %d", n)
+		}
+		for i, v := range out[:n] {
+			if v < -1 || v >= 1 {
+				t.Fatalf("sample %d out of range: %v", i, v)
+			}
+		}
+	})
+}
@@ -0,0 +1,302 @@
+// Package remux rewraps AAC access units between ADTS, raw access units with
+// an AudioSpecificConfig, and LATM/LOAS without touching the audio data.
+package remux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/latm"
+)
+
+// Format is the transport format of a stream. The values match
+// fdkaac.TransportType, those of LATM and LOAS are the latm.Transport values.
+type Format int
+
+const (
+	// Raw access units, the AudioSpecificConfig is carried out-of-band. Raw
+	// access units are not delimited in a byte stream, so they cannot be
+	// read or written by this package; use a container such as mp4, mkv or flv.
+	FormatRaw  Format = 0
+	FormatAdts Format = 2
+	// LATM with in-band StreamMuxConfig, one AudioMuxElement per Write.
+	FormatLatmMcp1 = Format(latm.TransportLatmMcp1)
+	// LATM with out-of-band StreamMuxConfig, one AudioMuxElement per Write.
+	FormatLatmMcp0 = Format(latm.TransportLatmMcp0)
+	FormatLoas     = Format(latm.TransportLoas)
+)
+
+var (
+	ErrUnsupported = errors.New("remux: unsupported format")
+	// The audio config of the source changed, which the destination cannot signal.
+	ErrConfigChanged = errors.New("remux: audio config changed")
+
+	errRaw = fmt.Errorf("%w: raw access units are not delimited in a byte stream", ErrUnsupported)
+)
+
+// ADTSHeader returns the ADTS header template for the access units of config.
+// FrameLength is set per frame. ADTS can only carry AAC Main, LC, SSR and LTP
+// (object types 1 to 4) with 1024 samples per frame, a channel configuration
+// and a standard sampling frequency. SBR and PS are signaled implicitly.
+func ADTSHeader(config *asc.AudioSpecificConfig) (*adts.Header, error) {
+	if config.ObjectType < asc.AotAacMain || config.ObjectType > asc.AotAacLtp {
+		return nil, fmt.Errorf("%w: audio object type %d cannot be carried in ADTS, only 1 to 4",
+			ErrUnsupported, config.ObjectType)
+	}
+	if config.FrameLengthFlag {
+		return nil, fmt.Errorf("%w: frame length 960 cannot be carried in ADTS", ErrUnsupported)
+	}
+	if config.ChannelConfiguration < 1 || config.ChannelConfiguration > 7 {
+		return nil, fmt.Errorf("%w: channel configuration %d cannot be carried in ADTS",
+			ErrUnsupported, config.ChannelConfiguration)
+	}
+	if config.DependsOnCoreCoder {
+		return nil, fmt.Errorf("%w: core coder dependency cannot be carried in ADTS", ErrUnsupported)
+	}
+	index, ok := adts.SampleRateIndex(config.SamplingFrequency)
+	if !ok {
+		return nil, fmt.Errorf("%w: sampling frequency %d has no index", ErrUnsupported, config.SamplingFrequency)
+	}
+
+	return &adts.Header{
+		ProtectionAbsent:       true,
+		Profile:                uint8(config.ObjectType - 1),
+		SamplingFrequencyIndex: index,
+		ChannelConfiguration:   uint8(config.ChannelConfiguration),
+		BufferFullness:         adts.BufferFullnessVBR,
+	}, nil
+}
+
+// ConfigFromADTS builds the AudioSpecificConfig of an ADTS header. A channel
+// configuration of 0 is not supported, as the PCE is carried in the raw data block.
+func ConfigFromADTS(h *adts.Header) (*asc.AudioSpecificConfig, error) {
+	if h.ChannelConfiguration == 0 {
+		return nil, fmt.Errorf("%w: ADTS with channel configuration 0", ErrUnsupported)
+	}
+	return &asc.AudioSpecificConfig{
+		ObjectType:           h.AudioObjectType(),
+		SamplingFrequency:    h.SampleRate(),
+		ChannelConfiguration: int(h.ChannelConfiguration),
+	}, nil
+}
+
+// Reader reads the access units of an ADTS or LOAS stream.
+type Reader struct {
+	format  Format
+	adts    *adts.Scanner
+	loas    *latm.Scanner
+	config  *asc.AudioSpecificConfig
+	ascBuf  []byte
+	pending [][]byte
+}
+
+// NewReader returns a Reader of the stream of the given format read from src.
+func NewReader(src io.Reader, format Format) (*Reader, error) {
+	r := &Reader{format: format}
+	switch format {
+	case FormatAdts:
+		r.adts = adts.NewScanner(src)
+	case FormatLoas:
+		r.loas = latm.NewScanner(src)
+	case FormatRaw:
+		return nil, errRaw
+	default:
+		return nil, fmt.Errorf("%w: cannot read format %d from a byte stream", ErrUnsupported, format)
+	}
+	return r, nil
+}
+
+// Config returns the audio config of the access unit last read. The same
+// pointer is returned until the config changes.
+func (r *Reader) Config() *asc.AudioSpecificConfig {
+	return r.config
+}
+
+// ReadAU returns the next access unit, or io.EOF at the end of the stream.
+func (r *Reader) ReadAU() ([]byte, error) {
+	for len(r.pending) == 0 {
+		var err error
+		switch r.format {
+		case FormatAdts:
+			err = r.readAdts()
+		case FormatLoas:
+			err = r.readLoas()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	au := r.pending[0]
+	r.pending = r.pending[1:]
+	return au, nil
+}
+
+func (r *Reader) readAdts() error {
+	if !r.adts.Scan() {
+		if err := r.adts.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	f := r.adts.Frame()
+	if f.Header.NumRawDataBlocks > 0 && f.Header.ProtectionAbsent {
+		return fmt.Errorf("%w: unprotected ADTS frame with %d raw data blocks, whose boundaries are not signaled",
+			ErrUnsupported, f.Header.NumRawDataBlocks+1)
+	}
+	config, err := ConfigFromADTS(f.Header)
+	if err != nil {
+		return err
+	}
+	blocks, err := f.RawDataBlocks()
+	if err != nil {
+		return err
+	}
+	if err = r.setConfig(config); err != nil {
+		return err
+	}
+	r.pending = blocks
+	return nil
+}
+
+func (r *Reader) readLoas() error {
+	if !r.loas.Scan() {
+		if err := r.loas.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	e := r.loas.Element()
+	if len(e.Config.Streams) != 1 {
+		return fmt.Errorf("%w: LOAS with %d streams", ErrUnsupported, len(e.Config.Streams))
+	}
+	if err := r.setConfig(e.Config.Streams[0].Config); err != nil {
+		return err
+	}
+	for _, p := range e.Payloads {
+		r.pending = append(r.pending, p.Data)
+	}
+	return nil
+}
+
+// setConfig keeps the current config unless the new one differs.
+func (r *Reader) setConfig(config *asc.AudioSpecificConfig) error {
+	b, err := config.Marshal()
+	if err != nil {
+		return err
+	}
+	if r.config == nil || !bytes.Equal(b, r.ascBuf) {
+		r.config = config
+		r.ascBuf = b
+	}
+	return nil
+}
+
+// Writer writes access units as ADTS, LATM or LOAS.
+type Writer struct {
+	dst    io.Writer
+	header *adts.Header
+	mux    *latm.Muxer
+}
+
+// NewWriter returns a Writer writing the access units of the stream described
+// by config to dst in the given format.
+func NewWriter(dst io.Writer, format Format, config *asc.AudioSpecificConfig) (*Writer, error) {
+	w := &Writer{dst: dst}
+	var err error
+	switch format {
+	case FormatAdts:
+		w.header, err = ADTSHeader(config)
+	case FormatLatmMcp1, FormatLatmMcp0, FormatLoas:
+		w.mux, err = latm.NewMuxer(dst, config, &latm.MuxerConfig{Transport: latm.Transport(format)})
+	case FormatRaw:
+		err = errRaw
+	default:
+		err = fmt.Errorf("%w: cannot write format %d to a byte stream", ErrUnsupported, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// StreamMuxConfig returns the LATM config, which must be sent out-of-band for
+// FormatLatmMcp0. It returns nil for other formats.
+func (w *Writer) StreamMuxConfig() *latm.StreamMuxConfig {
+	if w.mux == nil {
+		return nil
+	}
+	return w.mux.StreamMuxConfig()
+}
+
+// WriteAU writes one access unit.
+func (w *Writer) WriteAU(au []byte) error {
+	if w.mux != nil {
+		return w.mux.WriteFrame(au)
+	}
+
+	f, err := adts.NewFrame(*w.header, au)
+	if err != nil {
+		return err
+	}
+	_, err = w.dst.Write(f.Data)
+	return err
+}
+
+// Close writes pending data. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.mux != nil {
+		return w.mux.Flush()
+	}
+	return nil
+}
+
+// Remux copies the access units of the src stream to dst, converting the
+// transport format. It returns ErrConfigChanged if the audio config of src
+// changes, unless both are ADTS. src may be ADTS or LOAS and dst ADTS, LATM or
+// LOAS; FormatRaw is rejected with ErrUnsupported on either side, as are ADTS
+// frames with several raw data blocks but no error protection, whose block
+// boundaries cannot be found without decoding.
+func Remux(dst io.Writer, dstFormat Format, src io.Reader, srcFormat Format) error {
+	if dstFormat == FormatRaw {
+		return errRaw
+	}
+	r, err := NewReader(src, srcFormat)
+	if err != nil {
+		return err
+	}
+
+	var w *Writer
+	var config *asc.AudioSpecificConfig
+	for {
+		au, err := r.ReadAU()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if r.Config() != config {
+			if w != nil && !(srcFormat == FormatAdts && dstFormat == FormatAdts) {
+				return ErrConfigChanged
+			}
+			if w, err = NewWriter(dst, dstFormat, r.Config()); err != nil {
+				return err
+			}
+			config = r.Config()
+		}
+		if err = w.WriteAU(au); err != nil {
+			return err
+		}
+	}
+
+	if w != nil {
+		return w.Close()
+	}
+	return nil
+}
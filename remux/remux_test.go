@@ -0,0 +1,154 @@
+package remux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+)
+
+// First frame of the root package test data, LC 44.1 kHz stereo.
+var adtsHeader0 = []byte{0xff, 0xf1, 0x50, 0x80, 0x0e, 0x60, 0xfc}
+
+func readAll(t *testing.T, src io.Reader, format Format) ([][]byte, *asc.AudioSpecificConfig) {
+	r, err := NewReader(src, format)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	var aus [][]byte
+	for {
+		au, err := r.ReadAU()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadAU failed: %v", err)
+		}
+		aus = append(aus, au)
+	}
+	return aus, r.Config()
+}
+
+func TestRemux(t *testing.T) {
+	t.Run("Header conversion", func(t *testing.T) {
+		h, err := adts.Parse(adtsHeader0)
+		if err != nil {
+			t.Fatalf("adts.Parse failed: %v", err)
+		}
+		config, err := ConfigFromADTS(h)
+		if err != nil {
+			t.Fatalf("ConfigFromADTS failed: %v", err)
+		}
+		b, _ := config.Marshal()
+		if !bytes.Equal(b, []byte{0x12, 0x10}) {
+			t.Errorf("expected ASC 12 10, got % x", b)
+		}
+
+		h2, err := ADTSHeader(config)
+		if err != nil {
+			t.Fatalf("ADTSHeader failed: %v", err)
+		}
+		h2.FrameLength = h.FrameLength
+		h2.BufferFullness = h.BufferFullness
+		b, _ = h2.Marshal()
+		if !bytes.Equal(b, adtsHeader0) {
+			t.Errorf("expected header % x, got % x", adtsHeader0, b)
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		config := &asc.AudioSpecificConfig{ObjectType: asc.AotAacLc, SamplingFrequency: 48000, ChannelConfiguration: 2}
+		frames := testutil.Frames(7, 50, 31)
+
+		var adtsStream bytes.Buffer
+		w, err := NewWriter(&adtsStream, FormatAdts, config)
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		for _, f := range frames {
+			if err := w.WriteAU(f); err != nil {
+				t.Fatalf("WriteAU failed: %v", err)
+			}
+		}
+		w.Close()
+
+		var loasStream bytes.Buffer
+		if err := Remux(&loasStream, FormatLoas, bytes.NewReader(adtsStream.Bytes()), FormatAdts); err != nil {
+			t.Fatalf("Remux to LOAS failed: %v", err)
+		}
+		aus, got := readAll(t, bytes.NewReader(loasStream.Bytes()), FormatLoas)
+		if len(aus) != len(frames) {
+			t.Fatalf("expected %d access units, got %d", len(frames), len(aus))
+		}
+		for i := range frames {
+			if !bytes.Equal(aus[i], frames[i]) {
+				t.Errorf("access unit %d differs", i)
+			}
+		}
+		if got.SampleRate() != 48000 || got.Channels() != 2 {
+			t.Errorf("unexpected config %+v", got)
+		}
+
+		var adtsStream2 bytes.Buffer
+		if err := Remux(&adtsStream2, FormatAdts, bytes.NewReader(loasStream.Bytes()), FormatLoas); err != nil {
+			t.Fatalf("Remux to ADTS failed: %v", err)
+		}
+		if !bytes.Equal(adtsStream.Bytes(), adtsStream2.Bytes()) {
+			t.Error("ADTS stream differs after round trip")
+		}
+	})
+
+	t.Run("Config change", func(t *testing.T) {
+		var stream bytes.Buffer
+		for _, rate := range []int{44100, 48000} {
+			config := &asc.AudioSpecificConfig{ObjectType: asc.AotAacLc, SamplingFrequency: rate, ChannelConfiguration: 2}
+			w, _ := NewWriter(&stream, FormatAdts, config)
+			w.WriteAU([]byte{1, 2, 3})
+		}
+		if err := Remux(io.Discard, FormatLoas, bytes.NewReader(stream.Bytes()), FormatAdts); !errors.Is(err, ErrConfigChanged) {
+			t.Errorf("expected ErrConfigChanged, got %v", err)
+		}
+		if err := Remux(io.Discard, FormatAdts, bytes.NewReader(stream.Bytes()), FormatAdts); err != nil {
+			t.Errorf("ADTS to ADTS failed: %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		configs := []*asc.AudioSpecificConfig{
+			{ObjectType: asc.AotErAacLd, SamplingFrequency: 48000, ChannelConfiguration: 2},
+			{ObjectType: asc.AotAacLc, SamplingFrequency: 48000, ChannelConfiguration: 2, FrameLengthFlag: true},
+			{ObjectType: asc.AotAacLc, SamplingFrequency: 44000, ChannelConfiguration: 2},
+			{ObjectType: asc.AotAacLc, SamplingFrequency: 48000, ProgramConfig: &asc.ProgramConfigElement{}},
+		}
+		for _, config := range configs {
+			if _, err := NewWriter(io.Discard, FormatAdts, config); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("expected ErrUnsupported for %+v, got %v", config, err)
+			}
+		}
+		if _, err := NewReader(bytes.NewReader(nil), FormatRaw); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for raw reader, got %v", err)
+		}
+		if err := Remux(io.Discard, FormatRaw, bytes.NewReader(nil), FormatAdts); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for raw output, got %v", err)
+		}
+		if _, err := NewWriter(io.Discard, FormatRaw, &asc.AudioSpecificConfig{}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for raw writer, got %v", err)
+		}
+
+		// Two raw data blocks without the positions of protected frames.
+		h := adts.Header{ProtectionAbsent: true, Profile: 1, SamplingFrequencyIndex: 4,
+			ChannelConfiguration: 2, BufferFullness: adts.BufferFullnessVBR, NumRawDataBlocks: 1, FrameLength: 7 + 20}
+		b, err := h.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		b = append(b, make([]byte, 20)...)
+		if err := Remux(io.Discard, FormatLoas, bytes.NewReader(b), FormatAdts); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for unprotected multi-block frames, got %v", err)
+		}
+	})
+}
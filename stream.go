@@ -0,0 +1,278 @@
+package fdkaac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// Number of frames encoded per internal Encode call, bounds the buffer sizes.
+const streamChunkFrames = 16
+
+// EncodeWriter is an io.WriteCloser that encodes raw PCM written to it
+// and writes the encoded AAC data to the underlying writer.
+type EncodeWriter struct {
+	dst    io.Writer
+	enc    *Encoder
+	outBuf []byte
+	closed bool
+}
+
+// NewEncodeWriter creates an EncodeWriter that writes the encoded stream to dst.
+// PCM written to it must be interleaved 16-bit samples matching config.
+// Close flushes the encoder and must be called to get the end of the stream.
+func NewEncodeWriter(dst io.Writer, config *EncoderConfig) (*EncodeWriter, error) {
+	enc, err := NewEncoder(config)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &EncodeWriter{
+		dst: dst,
+		enc: enc,
+	}
+	w.outBuf = make([]byte, enc.EstimateOutBufBytes(streamChunkFrames*enc.FrameBytes))
+	return w, nil
+}
+
+// EncInfo returns info about the encoder configuration, e.g. ConfBuf.
+func (w *EncodeWriter) EncInfo() EncInfo {
+	return w.enc.EncInfo
+}
+
+// Write encodes p. Errors from the underlying writer are returned unchanged.
+func (w *EncodeWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, errors.New("write to closed encoder")
+	}
+
+	chunkSize := streamChunkFrames * w.enc.FrameBytes
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		encoded, _, err := w.enc.Encode(chunk, w.outBuf)
+		if err != nil {
+			return n, err
+		}
+		if encoded > 0 {
+			if _, err := w.dst.Write(w.outBuf[:encoded]); err != nil {
+				return n, err
+			}
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Close flushes the remaining data to the underlying writer and releases the encoder.
+// It does not close the underlying writer.
+func (w *EncodeWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.enc.Close()
+
+	encoded, _, err := w.enc.Flush(w.outBuf)
+	if err != nil {
+		return err
+	}
+	if encoded > 0 {
+		if _, err := w.dst.Write(w.outBuf[:encoded]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeReader is an io.Reader of PCM decoded from an AAC stream.
+type DecodeReader struct {
+	src     io.Reader
+	demux   Demuxer
+	dec     *Decoder
+	chunk   []byte
+	pcmBuf  []byte
+	pending []byte
+	eof     bool
+	err     error
+
+	// State of a Demuxer: the AudioSpecificConfig the decoder was configured
+	// with, the access unit waiting for the lost ones to be concealed, and the
+	// PCM bytes still to drop for the delay, -1 until the output format is known.
+	config  []byte
+	au      []byte
+	conceal int
+	skip    int
+}
+
+// Demuxer is a container of access units to decode with NewDemuxDecodeReader.
+// The demuxers of the mp4, ts and flv packages implement it.
+type Demuxer interface {
+	// Config returns the AudioSpecificConfig of the access units read so far,
+	// or nil if they carry their own configuration as ADTS and LOAS frames do.
+	// The decoder is configured with it whenever it changes.
+	Config() []byte
+	// Delay returns the duration of the priming samples at the start of the
+	// stream, which are dropped from the output.
+	Delay() time.Duration
+	// ReadAU returns the next access unit and the number of access units lost
+	// right before it, which are concealed, or io.EOF at the end of the input.
+	ReadAU() (au []byte, lost int, err error)
+}
+
+// NewDecodeReader creates a DecodeReader that decodes the AAC stream read from src.
+// Reads return interleaved 16-bit samples; PCM not consumed by one Read is kept
+// for the next, so p may have any size. The delayed samples are flushed at the
+// end of src. Close must be called to release the decoder.
+func NewDecodeReader(src io.Reader, config *DecoderConfig) (*DecodeReader, error) {
+	dec, err := NewDecoder(config)
+	if err != nil {
+		return nil, err
+	}
+	return &DecodeReader{
+		src:    src,
+		dec:    dec,
+		chunk:  make([]byte, 2048),
+		pcmBuf: make([]byte, dec.EstimateOutBufBytes(EstimateFrames)),
+	}, nil
+}
+
+// NewDemuxDecodeReader creates a DecodeReader that decodes the access units
+// read from demux, see NewDecodeReader. The transport type of config must
+// match the access units: TtMp4Raw, the zero value, for demuxers that return
+// a Config, otherwise TtMp4Adts or TtMp4Loas. Damaged access units give
+// concealed PCM instead of failing the Read.
+func NewDemuxDecodeReader(demux Demuxer, config *DecoderConfig) (*DecodeReader, error) {
+	dec, err := NewDecoder(config)
+	if err != nil {
+		return nil, err
+	}
+	return &DecodeReader{
+		demux:  demux,
+		dec:    dec,
+		pcmBuf: make([]byte, dec.EstimateOutBufBytes(EstimateFrames)),
+		skip:   -1,
+	}, nil
+}
+
+// StreamInfo returns stream information once the first frame has been decoded.
+func (r *DecodeReader) StreamInfo() (*StreamInfo, error) {
+	return r.dec.GetStreamInfo()
+}
+
+// Read reads decoded PCM into p.
+func (r *DecodeReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.eof {
+			flushed, flushErr := r.dec.Flush(r.pcmBuf)
+			r.pending = r.pcmBuf[:flushed]
+			r.err = io.EOF
+			if flushErr != nil {
+				r.err = flushErr
+			}
+			continue
+		}
+
+		var decoded int
+		if r.demux != nil {
+			decoded, err = r.decodeAU()
+		} else {
+			decoded, err = r.decodeChunk()
+		}
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			r.err = err
+			continue
+		}
+		r.pending = r.pcmBuf[:decoded]
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// decodeChunk decodes the next chunk of the stream.
+func (r *DecodeReader) decodeChunk() (int, error) {
+	n, err := r.src.Read(r.chunk)
+	if n == 0 {
+		return 0, err
+	}
+	decoded, decErr := r.dec.Decode(r.chunk[:n], r.pcmBuf)
+	if decErr != nil {
+		return 0, decErr
+	}
+	return decoded, err
+}
+
+// decodeAU decodes the next access unit of the Demuxer, after concealing the
+// ones lost before it one by one.
+func (r *DecodeReader) decodeAU() (int, error) {
+	if r.au == nil {
+		au, lost, err := r.demux.ReadAU()
+		if err != nil {
+			return 0, err
+		}
+		r.au = au
+		// There is nothing to conceal before the first decoded frame.
+		if r.dec.info != nil {
+			r.conceal = lost
+		}
+	}
+
+	var in []byte
+	flags := DecodeFlagConceal
+	if r.conceal > 0 {
+		r.conceal--
+	} else {
+		if config := r.demux.Config(); config != nil && !bytes.Equal(config, r.config) {
+			if err := r.dec.ConfigRaw(config); err != nil {
+				return 0, err
+			}
+			r.config = append(r.config[:0], config...)
+		}
+		in, flags = r.au, 0
+		r.au = nil
+	}
+
+	n, _, err := r.dec.DecodeFrame(in, r.pcmBuf, flags)
+	if err != nil && !IsDecodeError(err) {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if r.skip < 0 {
+		// Count the delay in output samples, which differ from the media
+		// samples with implicit SBR.
+		info := r.dec.info
+		samples := (int64(r.demux.Delay())*int64(info.SampleRate) + int64(time.Second)/2) / int64(time.Second)
+		r.skip = int(samples) * info.NumChannels * SampleBitDepth / 8
+	}
+	k := min(r.skip, n)
+	r.skip -= k
+	return copy(r.pcmBuf, r.pcmBuf[k:n]), nil
+}
+
+// Close releases the decoder. It does not close the underlying reader.
+func (r *DecodeReader) Close() error {
+	r.dec.Close()
+	r.pending = nil
+	if r.err == nil {
+		r.err = errors.New("read from closed decoder")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package fdkaac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+type failWriter struct {
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestEncodeWriter(t *testing.T) {
+	t.Run("Encode with io.Copy", func(t *testing.T) {
+		inBuf, err := os.ReadFile("samples/sample.pcm")
+		if err != nil {
+			t.Fatalf("open samples/sample.pcm failed: %v", err)
+		}
+
+		var out bytes.Buffer
+		w, err := NewEncodeWriter(&out, &EncoderConfig{
+			TransMux:    TtMp4Adts,
+			SampleRate:  44100,
+			MaxChannels: 2,
+			Bitrate:     128000,
+		})
+		if err != nil {
+			t.Fatalf("NewEncodeWriter failed: %v", err)
+		}
+
+		if _, err = io.Copy(w, bytes.NewReader(inBuf)); err != nil {
+			t.Fatalf("copy failed: %v", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+
+		diff := out.Len() - 224919
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > 10 {
+			t.Errorf("expected %d bytes, got %d", 224919, out.Len())
+		}
+
+		if _, err = w.Write(PCM0); err == nil {
+			t.Error("expected error writing to closed encoder")
+		}
+	})
+
+	t.Run("Write error is passed through", func(t *testing.T) {
+		wErr := errors.New("broken pipe")
+		w, err := NewEncodeWriter(&failWriter{err: wErr}, nil)
+		if err != nil {
+			t.Fatalf("NewEncodeWriter failed: %v", err)
+		}
+		defer w.Close()
+
+		if _, err = w.Write(PCM0); err != wErr {
+			t.Errorf("expected %v, got %v", wErr, err)
+		}
+	})
+}
+
+func TestDecodeReader(t *testing.T) {
+	in := append(append(append([]byte{}, AAC0...), AAC1...), AAC2...)
+	r, err := NewDecodeReader(bytes.NewReader(in), &DecoderConfig{
+		TransportFmt: TtMp4Adts,
+	})
+	if err != nil {
+		t.Fatalf("NewDecodeReader failed: %v", err)
+	}
+	defer r.Close()
+
+	if _, err = r.StreamInfo(); err == nil {
+		t.Error("expected error before the first frame is decoded")
+	}
+
+	// Odd sized reads must not lose any PCM.
+	buf := make([]byte, 1001)
+	total := 0
+	for {
+		n, err := r.Read(buf)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+	}
+	if total < 3*4096 {
+		t.Errorf("expected at least %d bytes, got %d", 3*4096, total)
+	}
+
+	info, err := r.StreamInfo()
+	if err != nil {
+		t.Fatalf("StreamInfo failed: %v", err)
+	}
+	if info.SampleRate != 44100 || info.NumChannels != 2 {
+		t.Errorf("unexpected stream info: %+v", info)
+	}
+}
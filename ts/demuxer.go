@@ -0,0 +1,479 @@
+package ts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/internal/scan"
+	"github.com/lizc2003/audio-fdkaac/latm"
+)
+
+var ErrNoStream = errors.New("ts: no AAC stream found")
+
+// Stream is an AAC elementary stream listed in a PMT.
+type Stream struct {
+	// program_number of the PMT.
+	Program int
+	// PID of the elementary stream.
+	PID int
+	// StreamTypeAdts or StreamTypeLatm.
+	StreamType StreamType
+}
+
+// DemuxerConfig configures a Demuxer.
+type DemuxerConfig struct {
+	// PID of the stream to read, the first AAC stream found if 0.
+	PID int
+}
+
+// Frame is an ADTS frame, or a LOAS AudioMuxElement for StreamTypeLatm.
+type Frame struct {
+	// The frame including its header, as a decoder configured with
+	// TtMp4Adts or TtMp4Loas takes it.
+	Data []byte
+	// Presentation and decode time in ClockRate units, or -1 before the first
+	// PTS. Frames that do not start a PES packet get the times of the first
+	// frame plus the duration of the frames in between.
+	PTS, DTS int64
+	// Number of frames lost right before this one, because of continuity
+	// counter errors or transport errors. It is estimated from the PTS gap
+	// and is at least 1 after a loss.
+	Lost int
+}
+
+// pesTime is the PTS and DTS of the first frame starting at or after offset.
+type pesTime struct {
+	offset   int
+	pts, dts int64
+}
+
+// Demuxer reads the frames of an AAC stream from an MPEG transport stream. It
+// follows the PAT and PMT to find the stream and reassembles its PES packets.
+// Lost packets do not stop the Demuxer, the frames they carried are dropped
+// and reported through Frame.Lost, so that the decoder can conceal them.
+type Demuxer struct {
+	r       *bufio.Reader
+	packet  [PacketSize]byte
+	synced  bool
+	skipped int64
+	eof     bool
+
+	pid      int
+	programs map[int]int
+	streams  []Stream
+	stream   *Stream
+	framer   framer
+
+	cc      int
+	inPES   bool
+	pes     []byte
+	buf     []byte
+	times   []pesTime
+	resync  bool
+	lost    bool
+	lostPTS int64
+	frames  []*Frame
+
+	// Time of the next frame, base plus samples at rate.
+	timed            bool
+	basePTS, baseDTS int64
+	samples          int64
+	rate             int
+}
+
+// NewDemuxer returns a Demuxer reading from r. It reads until the PMT listing
+// the stream has been found.
+func NewDemuxer(r io.Reader, config *DemuxerConfig) (*Demuxer, error) {
+	d := &Demuxer{
+		r:        bufio.NewReader(r),
+		programs: map[int]int{},
+		cc:       -1,
+	}
+	if config != nil {
+		d.pid = config.PID
+	}
+
+	for d.stream == nil {
+		p, err := d.readPacket()
+		if err == io.EOF {
+			if d.pid != 0 {
+				return nil, fmt.Errorf("%w: PID 0x%x", ErrNoStream, d.pid)
+			}
+			return nil, ErrNoStream
+		} else if err != nil {
+			return nil, err
+		}
+		d.handlePacket(p)
+	}
+	return d, nil
+}
+
+// Streams returns the AAC streams found in the PMTs so far.
+func (d *Demuxer) Streams() []Stream {
+	return d.streams
+}
+
+// Stream returns the stream being read.
+func (d *Demuxer) Stream() Stream {
+	return *d.stream
+}
+
+// Skipped returns the number of bytes skipped to find the packet sync.
+func (d *Demuxer) Skipped() int64 {
+	return d.skipped
+}
+
+// ReadFrame returns the next frame, or io.EOF at the end of the stream.
+func (d *Demuxer) ReadFrame() (*Frame, error) {
+	for len(d.frames) == 0 {
+		if d.eof {
+			return nil, io.EOF
+		}
+		p, err := d.readPacket()
+		if err == io.EOF {
+			d.endPES()
+			d.eof = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		d.handlePacket(p)
+	}
+
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+// Config returns nil, ADTS and LOAS frames carry their own configuration.
+func (d *Demuxer) Config() []byte {
+	return nil
+}
+
+// Delay returns 0, transport streams do not signal the encoder delay.
+func (d *Demuxer) Delay() time.Duration {
+	return 0
+}
+
+// ReadAU returns the data of the next frame and the number of frames lost
+// before it, or io.EOF at the end of the stream. With Config and Delay, it
+// lets fdkaac.NewDemuxDecodeReader decode the stream with concealment, using
+// TtMp4Adts, or TtMp4Loas for StreamTypeLatm.
+func (d *Demuxer) ReadAU() ([]byte, int, error) {
+	f, err := d.ReadFrame()
+	if err != nil {
+		return nil, 0, err
+	}
+	return f.Data, f.Lost, nil
+}
+
+// readPacket returns the next packet. While not synchronized, a sync byte is
+// only accepted when followed by another one a packet later.
+func (d *Demuxer) readPacket() ([]byte, error) {
+	for {
+		p, err := d.r.Peek(PacketSize + 1)
+		if len(p) < PacketSize {
+			if err == io.EOF {
+				d.skipped += int64(len(p))
+			}
+			return nil, err
+		}
+		if p[0] == syncByte && (d.synced || len(p) == PacketSize || p[PacketSize] == syncByte) {
+			d.synced = true
+			copy(d.packet[:], p)
+			d.r.Discard(PacketSize)
+			return d.packet[:], nil
+		}
+		d.synced = false
+		d.r.Discard(1)
+		d.skipped++
+	}
+}
+
+func (d *Demuxer) handlePacket(p []byte) {
+	pid := int(p[1]&0x1F)<<8 | int(p[2])
+	start := p[1]&0x40 != 0
+	control := p[3] >> 4 & 0x03
+	cc := int(p[3] & 0x0F)
+
+	payload := p[4:]
+	discontinuity := false
+	if control&0x02 != 0 {
+		n := 1 + int(payload[0])
+		if n > len(payload) {
+			return
+		}
+		discontinuity = n > 1 && payload[1]&0x80 != 0
+		payload = payload[n:]
+	}
+	if control&0x01 == 0 {
+		payload = nil
+	}
+
+	if d.stream == nil || pid != d.stream.PID {
+		if start && len(payload) > 0 && (pid == patPID || d.programs[pid] != 0) {
+			d.parseSection(pid, payload)
+		}
+		return
+	}
+
+	if p[1]&0x80 != 0 {
+		// transport_error_indicator
+		d.lose()
+		return
+	}
+	if control&0x01 != 0 {
+		if d.cc >= 0 && !discontinuity {
+			if cc == d.cc {
+				// Duplicate packet.
+				return
+			}
+			if cc != (d.cc+1)&0x0F {
+				d.lose()
+			}
+		}
+		d.cc = cc
+	}
+
+	if start {
+		d.endPES()
+		d.inPES = true
+	} else if !d.inPES {
+		return
+	}
+	d.pes = append(d.pes, payload...)
+	if len(d.pes) >= 6 {
+		// End the PES packet as soon as it is complete.
+		if n := int(d.pes[4])<<8 | int(d.pes[5]); n > 0 && len(d.pes) >= 6+n {
+			d.endPES()
+		}
+	}
+}
+
+// parseSection parses a PAT or a PMT contained in one packet.
+func (d *Demuxer) parseSection(pid int, payload []byte) {
+	pointer := 1 + int(payload[0])
+	if pointer+3 > len(payload) {
+		return
+	}
+	s := payload[pointer:]
+	n := 3 + (int(s[1]&0x0F)<<8 | int(s[2]))
+	if n < 12 || n > len(s) || CRC32(s[:n]) != 0 {
+		return
+	}
+	// Only the current version applies.
+	if s[5]&0x01 == 0 {
+		return
+	}
+	s = s[:n-4]
+
+	switch {
+	case pid == patPID && s[0] == 0x00:
+		for e := s[8:]; len(e) >= 4; e = e[4:] {
+			program := int(e[0])<<8 | int(e[1])
+			// Program 0 is the network PID.
+			if program != 0 {
+				d.programs[int(e[2]&0x1F)<<8|int(e[3])] = program
+			}
+		}
+	case pid != patPID && s[0] == 0x02:
+		// The header up to program_info_length.
+		if len(s) < 12 {
+			return
+		}
+		program := int(s[3])<<8 | int(s[4])
+		infoLength := int(s[10]&0x0F)<<8 | int(s[11])
+		if 12+infoLength > len(s) {
+			return
+		}
+		for e := s[12+infoLength:]; len(e) >= 5; {
+			st := Stream{
+				Program:    program,
+				PID:        int(e[1]&0x1F)<<8 | int(e[2]),
+				StreamType: StreamType(e[0]),
+			}
+			esInfoLength := 5 + (int(e[3]&0x0F)<<8 | int(e[4]))
+			if esInfoLength > len(e) {
+				return
+			}
+			e = e[esInfoLength:]
+			if st.StreamType == StreamTypeAdts || st.StreamType == StreamTypeLatm {
+				d.addStream(st)
+			}
+		}
+	}
+}
+
+func (d *Demuxer) addStream(st Stream) {
+	for _, s := range d.streams {
+		if s.PID == st.PID {
+			return
+		}
+	}
+	d.streams = append(d.streams, st)
+	if d.stream == nil && (d.pid == 0 || d.pid == st.PID) {
+		d.stream = &d.streams[len(d.streams)-1]
+		d.framer, _ = newFramer(st.StreamType)
+	}
+}
+
+// lose drops the data of the stream received so far, until the next PES
+// packet starts.
+func (d *Demuxer) lose() {
+	if !d.lost {
+		d.lost = true
+		d.lostPTS = -1
+		if d.timed {
+			d.lostPTS = d.nextPTS()
+		}
+	}
+	d.inPES = false
+	d.pes = d.pes[:0]
+	d.buf = d.buf[:0]
+	d.times = d.times[:0]
+	d.resync = true
+}
+
+func (d *Demuxer) nextPTS() int64 {
+	if d.rate == 0 {
+		return d.basePTS
+	}
+	return d.basePTS + d.samples*ClockRate/int64(d.rate)
+}
+
+// endPES passes the payload of the current PES packet to the frame buffer.
+func (d *Demuxer) endPES() {
+	if !d.inPES {
+		return
+	}
+	pes := d.pes
+	d.inPES = false
+	d.pes = d.pes[:0]
+
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[6]&0xC0 != 0x80 {
+		d.lose()
+		return
+	}
+	n := int(pes[4])<<8 | int(pes[5])
+	flags := pes[7] >> 6
+	header := 9 + int(pes[8])
+	if header > len(pes) || n > 0 && 6+n < header {
+		d.lose()
+		return
+	}
+	if n > 0 && 6+n < len(pes) {
+		pes = pes[:6+n]
+	}
+
+	if flags&0x02 != 0 && header >= 14 {
+		t := pesTime{offset: len(d.buf), pts: readTimestamp(pes[9:])}
+		t.dts = t.pts
+		if flags == 0x03 && header >= 19 {
+			t.dts = readTimestamp(pes[14:])
+		}
+		d.times = append(d.times, t)
+	}
+	// Reuse the space of the frames already split off.
+	d.buf = append(d.buf[:0], d.buf...)
+	d.buf = append(d.buf, pes[header:]...)
+	d.splitFrames()
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]&0x0E)<<29 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// splitFrames moves the complete frames from the frame buffer to the queue.
+func (d *Demuxer) splitFrames() {
+	for len(d.buf) > 0 {
+		if d.resync {
+			d.consume(scan.Next(d.buf, d.framer.isSync))
+			if len(d.buf) < 2 {
+				break
+			}
+		}
+
+		n, err := d.framer.size(d.buf)
+		if err != nil {
+			d.resync = true
+			d.consume(1)
+			continue
+		}
+		if n == 0 || len(d.buf) < n {
+			break
+		}
+		samples, rate, err := d.framer.duration(d.buf[:n])
+		if errors.Is(err, latm.ErrNoConfig) {
+			// The decoder cannot use the frame before the first config.
+			d.resync = false
+			d.consume(n)
+			continue
+		} else if err != nil {
+			d.resync = true
+			d.consume(1)
+			continue
+		}
+		d.resync = false
+
+		if len(d.times) > 0 && d.times[0].offset <= 0 {
+			d.timed = true
+			d.basePTS, d.baseDTS = d.times[0].pts, d.times[0].dts
+			d.samples = 0
+			d.rate = rate
+			d.times = d.times[1:]
+		} else if rate != d.rate {
+			d.basePTS = d.nextPTS()
+			d.baseDTS += d.samples * ClockRate / int64(max(d.rate, 1))
+			d.samples = 0
+			d.rate = rate
+		}
+
+		f := &Frame{
+			Data: append([]byte(nil), d.buf[:n]...),
+			PTS:  -1,
+			DTS:  -1,
+		}
+		if d.timed {
+			delta := d.samples * ClockRate / int64(rate)
+			f.PTS = (d.basePTS + delta) & maxPTS
+			f.DTS = (d.baseDTS + delta) & maxPTS
+		}
+		if d.lost {
+			f.Lost = d.lostFrames(f.PTS, samples*ClockRate/rate)
+			d.lost = false
+		}
+		d.frames = append(d.frames, f)
+		d.samples += int64(samples)
+		d.consume(n)
+	}
+}
+
+// lostFrames estimates the number of frames of the given duration lost
+// between the time of the loss and pts.
+func (d *Demuxer) lostFrames(pts int64, duration int) int {
+	if pts < 0 || d.lostPTS < 0 {
+		return 1
+	}
+	gap := (pts - d.lostPTS) & maxPTS
+	if gap > maxPTS/2 {
+		// Time went backwards.
+		return 1
+	}
+	return max(int((gap+int64(duration)/2)/int64(duration)), 1)
+}
+
+// consume removes n bytes from the front of the frame buffer.
+func (d *Demuxer) consume(n int) {
+	d.buf = d.buf[n:]
+	for i := range d.times {
+		d.times[i].offset -= n
+	}
+	// A time that ends up before the frame in front now was inside a
+	// dropped frame and belongs to the next frame.
+	for len(d.times) > 1 && d.times[1].offset <= 0 {
+		d.times = d.times[1:]
+	}
+}
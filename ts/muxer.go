@@ -0,0 +1,353 @@
+package ts
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lizc2003/audio-fdkaac/internal/scan"
+	"github.com/lizc2003/audio-fdkaac/latm"
+)
+
+const (
+	// Default PID of the audio stream.
+	DefaultPID = 0x0100
+	// Default PID of the PMT.
+	DefaultPMTPID = 0x1000
+	// Default PTS of the first frame, 1.4 seconds.
+	DefaultStartPTS = 126000
+	// Default interval between PAT/PMT repetitions.
+	DefaultTableInterval = 100 * time.Millisecond
+
+	// The PCR runs this far behind the PTS, leaving the decoder 0.7 seconds
+	// of buffering.
+	pcrDelay = 63000
+	// Maximum time between PCRs, 40 ms, well below the 100 ms allowed.
+	pcrInterval = 3600
+)
+
+// MuxerConfig configures a Muxer.
+type MuxerConfig struct {
+	// Stream type, StreamTypeAdts if 0. StreamTypeLatm expects a LOAS
+	// AudioSyncStream, as written by an encoder configured with TtMp4Loas.
+	StreamType StreamType
+	// PID of the audio stream, which also carries the PCR (default DefaultPID).
+	PID int
+	// PID of the PMT (default DefaultPMTPID).
+	PMTPID int
+	// program_number of the PAT and PMT (default 1).
+	ProgramNumber int
+	// Number of frames packed into one PES packet (default 1).
+	FramesPerPES int
+	// PTS of the first frame in ClockRate units (default DefaultStartPTS).
+	StartPTS int64
+	// Interval between PAT/PMT repetitions (default DefaultTableInterval).
+	TableInterval time.Duration
+}
+
+// Muxer writes an ADTS or LOAS stream as an MPEG transport stream with a
+// single program. The PTS of each PES packet is derived from the number of
+// samples written before it, so it does not drift.
+type Muxer struct {
+	w             io.Writer
+	streamType    StreamType
+	pid           int
+	pmtPID        int
+	program       int
+	framesPerPES  int
+	tableInterval int64
+	tablesPTS     int64
+	writeTables   bool
+	patCC         uint8
+	pmtCC         uint8
+	cc            uint8
+	framer        framer
+
+	// Time of the next frame, basePTS plus samples at rate.
+	basePTS int64
+	samples int64
+	rate    int
+
+	buf    []byte
+	pes    []byte
+	pesPTS int64
+	frames int
+	out    []byte
+}
+
+// NewMuxer returns a Muxer writing to w. All packets of a PES packet, and the
+// tables preceding it, are passed to w in a single Write call.
+func NewMuxer(w io.Writer, config *MuxerConfig) (*Muxer, error) {
+	m := &Muxer{
+		w:            w,
+		streamType:   StreamTypeAdts,
+		pid:          DefaultPID,
+		pmtPID:       DefaultPMTPID,
+		program:      1,
+		framesPerPES: 1,
+		basePTS:      DefaultStartPTS,
+		writeTables:  true,
+	}
+	interval := DefaultTableInterval
+	if config != nil {
+		if config.StreamType != 0 {
+			m.streamType = config.StreamType
+		}
+		if config.PID > 0 {
+			m.pid = config.PID
+		}
+		if config.PMTPID > 0 {
+			m.pmtPID = config.PMTPID
+		}
+		if config.ProgramNumber > 0 {
+			m.program = config.ProgramNumber
+		}
+		if config.FramesPerPES > 0 {
+			m.framesPerPES = config.FramesPerPES
+		}
+		if config.StartPTS > 0 {
+			m.basePTS = config.StartPTS
+		}
+		if config.TableInterval > 0 {
+			interval = config.TableInterval
+		}
+	}
+	m.tableInterval = int64(interval * ClockRate / time.Second)
+
+	var err error
+	if m.framer, err = newFramer(m.streamType); err != nil {
+		return nil, err
+	}
+	if !validPID(m.pid) || !validPID(m.pmtPID) || m.pid == m.pmtPID {
+		return nil, fmt.Errorf("%w: PID 0x%x, PMT PID 0x%x", ErrInvalid, m.pid, m.pmtPID)
+	}
+	if m.program > 0xFFFF || m.basePTS > maxPTS {
+		return nil, fmt.Errorf("%w: program number %d, start PTS %d", ErrInvalid, m.program, m.basePTS)
+	}
+	return m, nil
+}
+
+func validPID(pid int) bool {
+	return pid >= 0x0010 && pid < nullPID
+}
+
+// PTS returns the PTS of the next frame in ClockRate units.
+func (m *Muxer) PTS() int64 {
+	if m.rate == 0 {
+		return m.basePTS
+	}
+	return m.basePTS + m.samples*ClockRate/int64(m.rate)
+}
+
+// Write adds ADTS frames, or LOAS AudioMuxElements for StreamTypeLatm. The
+// frames may be split across calls, an incomplete frame at the end of p is
+// kept until the rest arrives. Invalid data is dropped up to the next frame
+// header; the frames after it are still written and the first such error is
+// returned.
+func (m *Muxer) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	var invalid error
+	for len(m.buf) > 0 {
+		n, err := m.framer.size(m.buf)
+		if err != nil {
+			invalid = cmp.Or(invalid, err)
+			m.buf = m.buf[m.resync():]
+			continue
+		}
+		if n == 0 || len(m.buf) < n {
+			break
+		}
+		samples, rate, err := m.framer.duration(m.buf[:n])
+		if err != nil {
+			invalid = cmp.Or(invalid, err)
+			if errors.Is(err, latm.ErrNoConfig) {
+				// A valid element before the first config, drop all of it.
+				m.buf = m.buf[n:]
+			} else {
+				m.buf = m.buf[m.resync():]
+			}
+			continue
+		}
+		if err := m.writeFrame(m.buf[:n], samples, rate); err != nil {
+			return len(p), err
+		}
+		m.buf = m.buf[n:]
+	}
+	// Move the incomplete frame to the front, so buf does not grow.
+	m.buf = append(m.buf[:0], m.buf...)
+	return len(p), invalid
+}
+
+// resync returns the offset of the next possible frame header after the
+// first byte of buf.
+func (m *Muxer) resync() int {
+	return 1 + scan.Next(m.buf[1:], m.framer.isSync)
+}
+
+func (m *Muxer) writeFrame(frame []byte, samples, rate int) error {
+	if rate != m.rate {
+		m.basePTS = m.PTS()
+		m.samples = 0
+		m.rate = rate
+	}
+
+	if m.frames == 0 {
+		m.pesPTS = m.PTS()
+	}
+	m.pes = append(m.pes, frame...)
+	m.frames++
+	m.samples += int64(samples)
+	if m.frames < m.framesPerPES {
+		return nil
+	}
+	return m.Flush()
+}
+
+// WriteTables makes the Muxer write the PAT and PMT in front of the next PES
+// packet, so that a segment cut there can be decoded on its own.
+func (m *Muxer) WriteTables() {
+	m.writeTables = true
+}
+
+// Flush writes the pending frames as a PES packet, even if there are fewer
+// than FramesPerPES. An incomplete frame passed to Write stays buffered.
+func (m *Muxer) Flush() error {
+	if m.frames == 0 {
+		return nil
+	}
+	pts := m.pesPTS & maxPTS
+	m.out = m.out[:0]
+	if m.writeTables || m.pesPTS-m.tablesPTS >= m.tableInterval {
+		m.out = m.appendSection(m.out, patPID, &m.patCC, m.pat())
+		m.out = m.appendSection(m.out, m.pmtPID, &m.pmtCC, m.pmt())
+		m.tablesPTS = m.pesPTS
+		m.writeTables = false
+	}
+
+	pcr := pts - pcrDelay
+	if pcr < 0 {
+		pcr += maxPTS + 1
+	}
+	m.out = m.appendPES(m.out, pts, pcr, m.PTS()-m.pesPTS)
+	m.pes = m.pes[:0]
+	m.frames = 0
+
+	_, err := m.w.Write(m.out)
+	return err
+}
+
+func (m *Muxer) pat() []byte {
+	return section(0x00, 1, []byte{
+		byte(m.program >> 8), byte(m.program),
+		0xE0 | byte(m.pmtPID>>8), byte(m.pmtPID),
+	})
+}
+
+func (m *Muxer) pmt() []byte {
+	return section(0x02, m.program, []byte{
+		// PCR_PID, no program descriptors.
+		0xE0 | byte(m.pid>>8), byte(m.pid),
+		0xF0, 0x00,
+		// The audio stream without descriptors.
+		byte(m.streamType),
+		0xE0 | byte(m.pid>>8), byte(m.pid),
+		0xF0, 0x00,
+	})
+}
+
+// section returns a PSI section of version 0 with a single part.
+func section(tableID uint8, idExtension int, data []byte) []byte {
+	n := 5 + len(data) + 4
+	b := make([]byte, 0, 3+n)
+	b = append(b, tableID, 0xB0|byte(n>>8), byte(n),
+		byte(idExtension>>8), byte(idExtension), 0xC1, 0x00, 0x00)
+	b = append(b, data...)
+	crc := CRC32(b)
+	return append(b, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// appendSection appends a packet carrying a section, which must fit into it.
+func (m *Muxer) appendSection(out []byte, pid int, cc *uint8, section []byte) []byte {
+	p := appendHeader(out, pid, cc, true, false)
+	p = append(p, 0x00)
+	p = append(p, section...)
+	for len(p)-len(out) < PacketSize {
+		p = append(p, 0xFF)
+	}
+	return p
+}
+
+// appendPES appends the packets of a PES packet holding the pending frames,
+// which play for duration. The first packet carries the PCR, and later ones
+// repeat it every pcrInterval, advanced by the time of their offset into the
+// PES packet.
+func (m *Muxer) appendPES(out []byte, pts, pcr, duration int64) []byte {
+	n := 3 + 5 + len(m.pes)
+	if n > 0xFFFF {
+		// Unbounded, only allowed for video but tolerated by demuxers.
+		n = 0
+	}
+	header := []byte{
+		0x00, 0x00, 0x01, streamIDAudio, byte(n >> 8), byte(n),
+		// data_alignment_indicator, PTS only.
+		0x84, 0x80, 5,
+		0x21 | byte(pts>>29)&0x0E, byte(pts >> 22), byte(pts>>14) | 0x01, byte(pts >> 7), byte(pts<<1) | 0x01,
+	}
+	data := append(header, m.pes...)
+	total := int64(len(data))
+
+	lastPCR := int64(0)
+	for first := true; len(data) > 0; first = false {
+		elapsed := duration * (total - int64(len(data))) / total
+		withPCR := first || elapsed-lastPCR >= pcrInterval
+		// Size of the adaptation field including its length byte.
+		af := 0
+		if withPCR {
+			af = 8
+			lastPCR = elapsed
+		}
+		size := PacketSize - 4 - af
+		if len(data) < size {
+			af += size - len(data)
+			size = len(data)
+		}
+
+		out = appendHeader(out, m.pid, &m.cc, first, af > 0)
+		if af > 0 {
+			start := len(out)
+			out = append(out, byte(af-1))
+			if af > 1 {
+				if withPCR {
+					v := (pcr + elapsed) & maxPTS
+					out = append(out, 0x10,
+						byte(v>>25), byte(v>>17), byte(v>>9), byte(v>>1), byte(v<<7)|0x7E, 0x00)
+				} else {
+					out = append(out, 0x00)
+				}
+			}
+			for len(out)-start < af {
+				out = append(out, 0xFF)
+			}
+		}
+		out = append(out, data[:size]...)
+		data = data[size:]
+	}
+	return out
+}
+
+// appendHeader appends a packet header for a packet with payload.
+func appendHeader(out []byte, pid int, cc *uint8, start, adaptation bool) []byte {
+	b1 := byte(pid>>8) & 0x1F
+	if start {
+		b1 |= 0x40
+	}
+	control := byte(0x10)
+	if adaptation {
+		control = 0x30
+	}
+	out = append(out, syncByte, b1, byte(pid), control|*cc)
+	*cc = (*cc + 1) & 0x0F
+	return out
+}
@@ -0,0 +1,135 @@
+// Package ts writes and reads MPEG transport streams carrying AAC audio as
+// ADTS (stream type 0x0F) or LATM/LOAS (stream type 0x11).
+package ts
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/latm"
+)
+
+// StreamType is the stream_type of the PMT.
+type StreamType uint8
+
+const (
+	// ISO/IEC 13818-7 audio with ADTS transport syntax.
+	StreamTypeAdts StreamType = 0x0F
+	// ISO/IEC 14496-3 audio with the LATM transport syntax, as LOAS.
+	StreamTypeLatm StreamType = 0x11
+)
+
+const (
+	// Size of a transport stream packet.
+	PacketSize = 188
+	// Frequency of the PTS and the PCR base.
+	ClockRate = 90000
+
+	syncByte      = 0x47
+	patPID        = 0x0000
+	nullPID       = 0x1FFF
+	streamIDAudio = 0xC0
+	maxPTS        = 1<<33 - 1
+)
+
+var (
+	ErrInvalid     = errors.New("ts: invalid data")
+	ErrUnsupported = errors.New("ts: unsupported data")
+)
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// CRC32 computes the CRC_32 of PSI sections, polynomial 0x04C11DB7 with an
+// initial value of 0xFFFFFFFF.
+func CRC32(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// framer splits an ADTS or LOAS stream into frames.
+type framer struct {
+	streamType StreamType
+	latm       *latm.Demuxer
+}
+
+func newFramer(streamType StreamType) (framer, error) {
+	switch streamType {
+	case StreamTypeAdts:
+		return framer{streamType: streamType}, nil
+	case StreamTypeLatm:
+		return framer{streamType: streamType, latm: latm.NewDemuxer(nil)}, nil
+	}
+	return framer{}, fmt.Errorf("%w: stream type 0x%02x", ErrUnsupported, uint8(streamType))
+}
+
+// size returns the size of the frame at the start of b, or 0 if its header
+// is incomplete.
+func (f *framer) size(b []byte) (int, error) {
+	if f.streamType == StreamTypeLatm {
+		if len(b) < latm.SyncHeaderSize {
+			return 0, nil
+		}
+		if !f.isSync(b) {
+			return 0, fmt.Errorf("%w: LOAS syncword not found", ErrInvalid)
+		}
+		return latm.SyncHeaderSize + (int(b[1]&0x1F)<<8 | int(b[2])), nil
+	}
+
+	h, err := adts.Parse(b)
+	if errors.Is(err, adts.ErrShortHeader) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return h.FrameLength, nil
+}
+
+// isSync reports whether b, which has at least two bytes, starts with the
+// syncword of a frame header.
+func (f *framer) isSync(b []byte) bool {
+	if f.streamType == StreamTypeLatm {
+		return b[0] == 0x56 && b[1]&0xE0 == 0xE0
+	}
+	return b[0] == 0xFF && b[1]&0xF0 == 0xF0
+}
+
+// duration returns the number of samples and the sample rate of a frame.
+// For LOAS the error also matches latm.ErrNoConfig until a StreamMuxConfig
+// was seen.
+func (f *framer) duration(frame []byte) (int, int, error) {
+	if f.streamType == StreamTypeLatm {
+		e, err := f.latm.Parse(frame[latm.SyncHeaderSize:])
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		config := e.Config
+		if len(config.Streams) == 0 || config.Streams[0].Config == nil {
+			return 0, 0, fmt.Errorf("%w: no audio stream", ErrUnsupported)
+		}
+		ac := config.Streams[0].Config
+		return ac.FrameLength() * (config.NumSubFrames + 1), ac.SampleRate(), nil
+	}
+
+	h, err := adts.Parse(frame)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return h.Samples(), h.SampleRate(), nil
+}
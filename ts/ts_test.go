@@ -0,0 +1,459 @@
+package ts
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lizc2003/audio-fdkaac/adts"
+	"github.com/lizc2003/audio-fdkaac/asc"
+	"github.com/lizc2003/audio-fdkaac/internal/testutil"
+	"github.com/lizc2003/audio-fdkaac/latm"
+	"github.com/lizc2003/audio-fdkaac/remux"
+)
+
+// testStream returns frames of LC 44.1 kHz stereo wrapped in format.
+func testStream(t *testing.T, format remux.Format, frames [][]byte) []byte {
+	config, err := asc.Parse([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatalf("asc.Parse failed: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := remux.NewWriter(&buf, format, config)
+	if err != nil {
+		t.Fatalf("remux.NewWriter failed: %v", err)
+	}
+	for _, f := range frames {
+		if err := w.WriteAU(f); err != nil {
+			t.Fatalf("WriteAU failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+type testPES struct {
+	pts, pcr int64
+	data     []byte
+}
+
+// parseTS checks the packet syntax, continuity counters and section CRCs,
+// and returns the PES packets of pid along with the stream type in the PMT.
+func parseTS(t *testing.T, b []byte, pid int) ([]testPES, StreamType) {
+	if len(b)%PacketSize != 0 {
+		t.Fatalf("stream size %d is not a multiple of %d", len(b), PacketSize)
+	}
+	var pes []testPES
+	var streamType StreamType
+	cc := map[int]int{}
+	for ; len(b) > 0; b = b[PacketSize:] {
+		p := b[:PacketSize]
+		if p[0] != syncByte {
+			t.Fatalf("lost sync")
+		}
+		pktPID := int(p[1]&0x1F)<<8 | int(p[2])
+		if last, ok := cc[pktPID]; ok && int(p[3]&0x0F) != (last+1)&0x0F {
+			t.Errorf("PID 0x%x: continuity counter %d after %d", pktPID, p[3]&0x0F, last)
+		}
+		cc[pktPID] = int(p[3] & 0x0F)
+		start := p[1]&0x40 != 0
+		payload := p[4:]
+		pcr := int64(-1)
+		if p[3]&0x20 != 0 {
+			af := payload[:1+int(payload[0])]
+			if len(af) > 1 && af[1]&0x10 != 0 {
+				pcr = int64(af[2])<<25 | int64(af[3])<<17 | int64(af[4])<<9 | int64(af[5])<<1 | int64(af[6])>>7
+			}
+			payload = payload[len(af):]
+		}
+
+		switch {
+		case pktPID == patPID || pktPID == DefaultPMTPID:
+			s := payload[1+int(payload[0]):]
+			n := 3 + (int(s[1]&0x0F)<<8 | int(s[2]))
+			if CRC32(s[:n]) != 0 {
+				t.Errorf("PID 0x%x: CRC mismatch", pktPID)
+			}
+			if s[0] == 0x02 {
+				streamType = StreamType(s[12])
+			}
+		case pktPID == pid:
+			if start {
+				if !bytes.Equal(payload[:4], []byte{0, 0, 1, streamIDAudio}) {
+					t.Fatalf("invalid PES start code % x", payload[:4])
+				}
+				h := payload[9:14]
+				pts := int64(h[0]&0x0E)<<29 | int64(h[1])<<22 | int64(h[2]>>1)<<15 | int64(h[3])<<7 | int64(h[4]>>1)
+				pes = append(pes, testPES{pts: pts, pcr: pcr, data: append([]byte(nil), payload[14:]...)})
+				if n := int(payload[4])<<8 | int(payload[5]); n != 0 && n != 8+len(pes[len(pes)-1].data) && len(b) == PacketSize {
+					t.Errorf("PES packet length %d", n)
+				}
+			} else if len(pes) > 0 {
+				pes[len(pes)-1].data = append(pes[len(pes)-1].data, payload...)
+			}
+		}
+	}
+	return pes, streamType
+}
+
+func TestMuxer(t *testing.T) {
+	t.Run("CRC32", func(t *testing.T) {
+		if crc := CRC32([]byte("123456789")); crc != 0x0376E6E7 {
+			t.Errorf("expected CRC 0376e6e7, got %08x", crc)
+		}
+	})
+
+	t.Run("ADTS", func(t *testing.T) {
+		frames := testutil.Frames(10, 150, 37)
+		stream := testStream(t, remux.FormatAdts, frames)
+		var buf bytes.Buffer
+		m, err := NewMuxer(&buf, &MuxerConfig{FramesPerPES: 3})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		// Split the input across frame boundaries.
+		for p := stream; len(p) > 0; {
+			n := min(len(p), 101)
+			if _, err := m.Write(p[:n]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			p = p[n:]
+		}
+		if err := m.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if pts := m.PTS(); pts != DefaultStartPTS+10*1024*ClockRate/44100 {
+			t.Errorf("unexpected next PTS %d", pts)
+		}
+
+		pes, streamType := parseTS(t, buf.Bytes(), DefaultPID)
+		if streamType != StreamTypeAdts {
+			t.Errorf("expected stream type 0x0f, got 0x%02x", uint8(streamType))
+		}
+		if len(pes) != 4 {
+			t.Fatalf("expected 4 PES packets, got %d", len(pes))
+		}
+		var data []byte
+		for i, p := range pes {
+			pts := int64(DefaultStartPTS + i*3*1024*ClockRate/44100)
+			if p.pts != pts || p.pcr != pts-pcrDelay {
+				t.Errorf("PES %d: expected PTS %d, got PTS %d, PCR %d", i, pts, p.pts, p.pcr)
+			}
+			data = append(data, p.data...)
+		}
+		if !bytes.Equal(data, stream) {
+			t.Errorf("PES payloads differ from the ADTS stream")
+		}
+	})
+
+	t.Run("LATM", func(t *testing.T) {
+		frames := testutil.Frames(5, 150, 37)
+		stream := testStream(t, remux.FormatLoas, frames)
+		var buf bytes.Buffer
+		m, err := NewMuxer(&buf, &MuxerConfig{StreamType: StreamTypeLatm, PID: 0x44, StartPTS: 90000})
+		if err != nil {
+			t.Fatalf("NewMuxer failed: %v", err)
+		}
+		if _, err := m.Write(stream); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+
+		pes, streamType := parseTS(t, buf.Bytes(), 0x44)
+		if streamType != StreamTypeLatm {
+			t.Errorf("expected stream type 0x11, got 0x%02x", uint8(streamType))
+		}
+		if len(pes) != len(frames) {
+			t.Fatalf("expected %d PES packets, got %d", len(frames), len(pes))
+		}
+		if pes[4].pts != 90000+4*1024*ClockRate/44100 {
+			t.Errorf("unexpected PTS %d", pes[4].pts)
+		}
+	})
+
+	t.Run("Tables", func(t *testing.T) {
+		frames := testutil.Frames(20, 150, 37)
+		var buf bytes.Buffer
+		m, _ := NewMuxer(&buf, nil)
+		if _, err := m.Write(testStream(t, remux.FormatAdts, frames[:12])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		m.WriteTables()
+		if _, err := m.Write(testStream(t, remux.FormatAdts, frames[12:])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		tables := 0
+		for b := buf.Bytes(); len(b) > 0; b = b[PacketSize:] {
+			if b[1] == 0x40 && b[2] == 0x00 {
+				tables++
+			}
+		}
+		// Every fifth frame of 23.2 ms, and again from the forced one at frame 12.
+		if tables != 5 {
+			t.Errorf("expected 5 PATs, got %d", tables)
+		}
+	})
+
+	t.Run("PCR interval", func(t *testing.T) {
+		frames := testutil.Frames(40, 150, 37)
+		var buf bytes.Buffer
+		m, _ := NewMuxer(&buf, &MuxerConfig{FramesPerPES: 20})
+		if _, err := m.Write(testStream(t, remux.FormatAdts, frames)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		var pcrs []int64
+		for b := buf.Bytes(); len(b) > 0; b = b[PacketSize:] {
+			if int(b[1]&0x1F)<<8|int(b[2]) == DefaultPID && b[3]&0x20 != 0 && b[4] > 0 && b[5]&0x10 != 0 {
+				pcrs = append(pcrs, int64(b[6])<<25|int64(b[7])<<17|int64(b[8])<<9|int64(b[9])<<1|int64(b[10])>>7)
+			}
+		}
+		// Two PES packets of 464 ms each.
+		if len(pcrs) < 2*464/40 {
+			t.Fatalf("expected PCRs every 40 ms, got %d", len(pcrs))
+		}
+		if pcrs[0] != DefaultStartPTS-pcrDelay {
+			t.Errorf("expected first PCR %d, got %d", DefaultStartPTS-pcrDelay, pcrs[0])
+		}
+		for i := 1; i < len(pcrs); i++ {
+			if d := pcrs[i] - pcrs[i-1]; d <= 0 || d > 9000 {
+				t.Errorf("PCR %d: %d after %d", i, pcrs[i], pcrs[i-1])
+			}
+		}
+	})
+
+	t.Run("Resync", func(t *testing.T) {
+		frames := testutil.Frames(6, 150, 37)
+		var buf bytes.Buffer
+		m, _ := NewMuxer(&buf, nil)
+		bad := append([]byte{0x12, 0xFF, 0x34}, testStream(t, remux.FormatAdts, frames[:3])...)
+		if _, err := m.Write(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		// The garbage is gone, later writes succeed.
+		if _, err := m.Write(testStream(t, remux.FormatAdts, frames[3:])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		pes, _ := parseTS(t, buf.Bytes(), DefaultPID)
+		if len(pes) != len(frames) {
+			t.Fatalf("expected %d PES packets, got %d", len(frames), len(pes))
+		}
+		for i, p := range pes {
+			if !bytes.Equal(p.data[7:], frames[i]) {
+				t.Errorf("PES %d differs", i)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewMuxer(nil, &MuxerConfig{StreamType: 0x1B}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+		if _, err := NewMuxer(nil, &MuxerConfig{PID: DefaultPMTPID}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+		m, _ := NewMuxer(&bytes.Buffer{}, nil)
+		if _, err := m.Write([]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+}
+
+// muxFrames returns frames muxed with one frame per PES packet.
+func muxFrames(t *testing.T, format remux.Format, config *MuxerConfig, frames [][]byte) []byte {
+	var buf bytes.Buffer
+	m, err := NewMuxer(&buf, config)
+	if err != nil {
+		t.Fatalf("NewMuxer failed: %v", err)
+	}
+	if _, err := m.Write(testStream(t, format, frames)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readFrames(t *testing.T, d *Demuxer) []*Frame {
+	var frames []*Frame
+	for {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func TestDemuxer(t *testing.T) {
+	t.Run("ADTS round trip", func(t *testing.T) {
+		frames := testutil.Frames(10, 150, 37)
+		stream := muxFrames(t, remux.FormatAdts, &MuxerConfig{FramesPerPES: 3}, frames)
+		d, err := NewDemuxer(bytes.NewReader(append([]byte{0x47, 0x00, 0x47}, stream...)), nil)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if s := d.Stream(); s.PID != DefaultPID || s.StreamType != StreamTypeAdts || s.Program != 1 {
+			t.Errorf("unexpected stream %+v", s)
+		}
+
+		got := readFrames(t, d)
+		if d.Skipped() != 3 {
+			t.Errorf("expected 3 bytes skipped, got %d", d.Skipped())
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("expected %d frames, got %d", len(frames), len(got))
+		}
+		for i, f := range got {
+			h, _ := adts.Parse(f.Data)
+			if h == nil || !bytes.Equal(f.Data[h.Size():], frames[i]) {
+				t.Errorf("frame %d differs", i)
+			}
+			// Extrapolated times start from the truncated PTS of the PES packet.
+			pts := int64(DefaultStartPTS + i*1024*ClockRate/44100)
+			if f.PTS < pts-1 || f.PTS > pts || f.DTS != f.PTS || f.Lost != 0 {
+				t.Errorf("frame %d: expected PTS %d, got %d, DTS %d, lost %d", i, pts, f.PTS, f.DTS, f.Lost)
+			}
+		}
+	})
+
+	t.Run("LATM", func(t *testing.T) {
+		frames := testutil.Frames(6, 150, 37)
+		stream := muxFrames(t, remux.FormatLoas, &MuxerConfig{StreamType: StreamTypeLatm, PID: 0x31}, frames)
+		d, err := NewDemuxer(bytes.NewReader(stream), &DemuxerConfig{PID: 0x31})
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		got := readFrames(t, d)
+		if len(got) != len(frames) {
+			t.Fatalf("expected %d frames, got %d", len(frames), len(got))
+		}
+		dm := latm.NewDemuxer(nil)
+		for i, f := range got {
+			e, err := dm.Parse(f.Data[latm.SyncHeaderSize:])
+			if err != nil || !bytes.Equal(e.Payloads[0].Data, frames[i]) {
+				t.Errorf("frame %d differs (%v)", i, err)
+			}
+		}
+	})
+
+	t.Run("Loss", func(t *testing.T) {
+		frames := testutil.Frames(10, 150, 37)
+		stream := muxFrames(t, remux.FormatAdts, nil, frames)
+		// Collect the packets of each PES packet.
+		var pes [][]int
+		for i := 0; i*PacketSize < len(stream); i++ {
+			p := stream[i*PacketSize:]
+			if int(p[1]&0x1F)<<8|int(p[2]) != DefaultPID {
+				continue
+			}
+			if p[1]&0x40 != 0 {
+				pes = append(pes, nil)
+			}
+			pes[len(pes)-1] = append(pes[len(pes)-1], i)
+		}
+		drop := map[int]bool{}
+		// The second packet of frame 2, and frames 5 and 6.
+		drop[pes[2][1]] = true
+		for _, i := range append(pes[5], pes[6]...) {
+			drop[i] = true
+		}
+		var damaged []byte
+		for i := 0; i*PacketSize < len(stream); i++ {
+			if !drop[i] {
+				damaged = append(damaged, stream[i*PacketSize:(i+1)*PacketSize]...)
+			}
+		}
+
+		d, err := NewDemuxer(bytes.NewReader(damaged), nil)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		got := readFrames(t, d)
+		var index []int
+		lost := map[int]int{}
+		for _, f := range got {
+			i := int((f.PTS - DefaultStartPTS) * 44100 / (1024 * ClockRate))
+			if (f.PTS-DefaultStartPTS)*44100%(1024*ClockRate) != 0 {
+				i++
+			}
+			index = append(index, i)
+			if f.Lost != 0 {
+				lost[i] = f.Lost
+			}
+		}
+		if len(index) != 7 || index[2] != 3 || index[4] != 7 {
+			t.Errorf("unexpected frames %v", index)
+		}
+		if len(lost) != 2 || lost[3] != 1 || lost[7] != 2 {
+			t.Errorf("unexpected losses %v", lost)
+		}
+	})
+
+	t.Run("Access units", func(t *testing.T) {
+		frames := testutil.Frames(4, 150, 37)
+		stream := muxFrames(t, remux.FormatAdts, nil, frames)
+		// Drop the PES packet of the second frame.
+		var damaged []byte
+		audio := 0
+		for i := 0; i < len(stream); i += PacketSize {
+			p := stream[i : i+PacketSize]
+			if int(p[1]&0x1F)<<8|int(p[2]) == DefaultPID {
+				if p[1]&0x40 != 0 {
+					audio++
+				}
+				if audio == 2 {
+					continue
+				}
+			}
+			damaged = append(damaged, p...)
+		}
+
+		d, err := NewDemuxer(bytes.NewReader(damaged), nil)
+		if err != nil {
+			t.Fatalf("NewDemuxer failed: %v", err)
+		}
+		if d.Config() != nil || d.Delay() != 0 {
+			t.Errorf("expected no config and delay, got % x and %v", d.Config(), d.Delay())
+		}
+		for _, want := range []struct{ frame, lost int }{{0, 0}, {2, 1}, {3, 0}} {
+			au, lost, err := d.ReadAU()
+			if err != nil {
+				t.Fatalf("ReadAU failed: %v", err)
+			}
+			h, _ := adts.Parse(au)
+			if h == nil || !bytes.Equal(au[h.Size():], frames[want.frame]) || lost != want.lost {
+				t.Errorf("expected frame %d after %d lost, got %d lost", want.frame, want.lost, lost)
+			}
+		}
+		if _, _, err := d.ReadAU(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("No stream", func(t *testing.T) {
+		stream := muxFrames(t, remux.FormatAdts, nil, testutil.Frames(2, 150, 37))
+		if _, err := NewDemuxer(bytes.NewReader(stream), &DemuxerConfig{PID: 0x200}); !errors.Is(err, ErrNoStream) {
+			t.Errorf("expected ErrNoStream, got %v", err)
+		}
+		if _, err := NewDemuxer(bytes.NewReader(nil), nil); !errors.Is(err, ErrNoStream) {
+			t.Errorf("expected ErrNoStream, got %v", err)
+		}
+	})
+
+	t.Run("Short PMT", func(t *testing.T) {
+		m := &Muxer{program: 1, pmtPID: DefaultPMTPID}
+		var cc uint8
+		stream := m.appendSection(nil, patPID, &cc, m.pat())
+		// A CRC-valid PMT that ends before program_info_length.
+		stream = m.appendSection(stream, DefaultPMTPID, &cc, section(0x02, 1, []byte{0xE1}))
+		if _, err := NewDemuxer(bytes.NewReader(stream), nil); !errors.Is(err, ErrNoStream) {
+			t.Errorf("expected ErrNoStream, got %v", err)
+		}
+	})
+}
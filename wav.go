@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/lizc2003/audio-fdkaac/mp4"
 )
 
 const (
@@ -83,39 +85,58 @@ func EncodeFromWav(wavStream io.Reader, writer io.Writer, config *EncoderConfig)
 }
 
 // DecodeToWav decodes an AAC stream (aacStream) to WAV format and writes it to the output writer (writer).
+// If aacStream is an io.ReadSeeker starting with an ftyp box, it is read as an
+// MP4/M4A file: the first AAC track is decoded with TtMp4Raw and the samples
+// before the start of its edit list, usually the encoder delay, are dropped.
 // Note: This function writes a WAV header.
 func DecodeToWav(aacStream io.Reader, writer io.WriteSeeker, config *DecoderConfig) (totalBytes int, totalSamples int, sampleRate int, err error) {
-	decoder, err := NewDecoder(config)
+	var reader *DecodeReader
+	if rs, ok := aacStream.(io.ReadSeeker); ok && isMp4(rs) {
+		var demux *mp4.Demuxer
+		if demux, err = mp4.NewDemuxer(rs); err != nil {
+			return 0, 0, 0, err
+		}
+		c := *populateDecConfig(config)
+		c.TransportFmt = TtMp4Raw
+		reader, err = NewDemuxDecodeReader(demux, &c)
+	} else {
+		reader, err = NewDecodeReader(aacStream, config)
+	}
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	defer decoder.Close()
+	defer reader.Close()
+	return decodeReaderToWav(reader, writer)
+}
 
-	pcmBuf := make([]byte, decoder.EstimateOutBufBytes(EstimateFrames))
-	chunk := make([]byte, 2048)
+// isMp4 reports whether rs starts with an ftyp box, leaving its position unchanged.
+func isMp4(rs io.ReadSeeker) bool {
+	var header [8]byte
+	n, _ := io.ReadFull(rs, header[:])
+	if _, err := rs.Seek(int64(-n), io.SeekCurrent); err != nil {
+		return false
+	}
+	return n == len(header) && string(header[4:]) == "ftyp"
+}
+
+func decodeReaderToWav(reader *DecodeReader, writer io.WriteSeeker) (totalBytes int, totalSamples int, sampleRate int, err error) {
+	pcmBuf := make([]byte, 32*1024)
 
 	for {
-		n, readErr := aacStream.Read(chunk)
+		n, readErr := reader.Read(pcmBuf)
 		if n > 0 {
-			decodedN, decErr := decoder.Decode(chunk[:n], pcmBuf)
-			if decErr != nil {
-				return 0, 0, 0, decErr
-			}
-
-			if decodedN > 0 {
-				if totalBytes == 0 {
-					// Write placeholder WAV header
-					headerBuf := make([]byte, WavHeaderSize)
-					if _, err := writer.Write(headerBuf); err != nil {
-						return 0, 0, 0, fmt.Errorf("write placeholder header failed: %w", err)
-					}
+			if totalBytes == 0 {
+				// Write placeholder WAV header
+				headerBuf := make([]byte, WavHeaderSize)
+				if _, err := writer.Write(headerBuf); err != nil {
+					return 0, 0, 0, fmt.Errorf("write placeholder header failed: %w", err)
 				}
+			}
 
-				if _, wErr := writer.Write(pcmBuf[:decodedN]); wErr != nil {
-					return 0, 0, 0, wErr
-				}
-				totalBytes += decodedN
+			if _, wErr := writer.Write(pcmBuf[:n]); wErr != nil {
+				return 0, 0, 0, wErr
 			}
+			totalBytes += n
 		}
 
 		if readErr != nil {
@@ -136,7 +157,7 @@ func DecodeToWav(aacStream io.Reader, writer io.WriteSeeker, config *DecoderConf
 		return 0, 0, 0, fmt.Errorf("seek to start failed: %w", err)
 	}
 
-	info, _ := decoder.GetStreamInfo()
+	info, _ := reader.StreamInfo()
 	header := GenerateWavHeader(totalBytes, info.SampleRate, info.NumChannels, SampleBitDepth)
 	if _, err := writer.Write(header); err != nil {
 		return 0, 0, 0, fmt.Errorf("write real header failed: %w", err)